@@ -16,15 +16,15 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
 	"github.com/spf13/cobra"
 )
 
@@ -46,8 +46,43 @@ func (n newAnimeInfo) String() string {
 	)
 }
 
+type recStatusInfo struct {
+	Status  foltia.RecStatus
+	Text    string
+	TID     int
+	EpNum   int
+	PID     int
+	Title   string
+	Station string
+	Time    time.Time
+	Since   time.Time
+}
+
+func (r recStatusInfo) String() string {
+	ep := ""
+	if r.TID != -1 {
+		ep = fmt.Sprintf("(%d:%d)", r.TID, r.EpNum)
+	}
+	s := fmt.Sprintf("[%s] %s : %s%s %s [%d]",
+		r.Status.Label(),
+		r.Time.Format("2006/01/02")+"("+jweek[r.Time.Weekday()]+") "+r.Time.Format("15:04"),
+		r.Title,
+		ep,
+		r.Station,
+		r.PID,
+	)
+	if (r.Status == foltia.RecFailed || r.Status == foltia.RecUnknown) && r.Text != "" {
+		s += " " + r.Text
+	}
+	if !r.Since.IsZero() {
+		s += " " + r.Since.Format("01/02 15:04") + "から"
+	}
+	return s
+}
+
 type foltiaStatus struct {
 	version        string
+	profile        string
 	serial         string
 	storage        string
 	storageRemain  string
@@ -85,33 +120,46 @@ var checkCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalln(err)
 		}
-		c := checkFlag(newAnime, tidFlag, packet, reserve)
+		recStatus, err := cmd.Flags().GetBool("rec-status")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		c := checkFlag(newAnime, tidFlag, packet, reserve, recStatus)
 		if c == 0 {
-			err = showStatus()
+			err = showStatus(cmd.Context())
 			if err != nil {
 				log.Fatalln(err)
 			}
 		} else if c == 1 {
+			if tidFlag || packet != 0 || recStatus {
+				openStore()
+			}
 			if newAnime {
-				err = checkNewAnime()
+				err = checkNewAnime(cmd.Context())
 				if err != nil {
 					log.Fatalln(err)
 				}
 			}
 			if tidFlag {
-				err = showTitle()
+				err = showTitle(store)
 				if err != nil {
 					log.Fatalln(err)
 				}
 			}
 			if packet != 0 {
-				err = showTSInfo(packet)
+				err = showTSInfo(store.Repos(), packet)
 				if err != nil {
 					log.Fatalln(err)
 				}
 			}
 			if reserve != 0 {
-				err = showReservationList(reserve)
+				err = showReservationList(cmd.Context(), reserve)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+			if recStatus {
+				err = showRecStatus(store.Repos())
 				if err != nil {
 					log.Fatalln(err)
 				}
@@ -129,11 +177,12 @@ func init() {
 	checkCmd.Flags().BoolP("tid", "t", false, "TIDの一覧を確認")
 	checkCmd.Flags().IntP("reserve", "r", 0, "録画予約一覧を確認 (表示する日数を指定)")
 	checkCmd.Flags().IntP("packet", "p", 0, "TSドロップの発生しているファイルを確認 (閾値を指定)")
+	checkCmd.Flags().BoolP("rec-status", "s", false, "録画中・MP4変換中・失敗した録画を確認")
 }
 
-func showReservationList(r int) error {
+func showReservationList(ctx context.Context, r int) error {
 	fmt.Println("予約一覧")
-	rl, err := getReservationList()
+	rl, err := getReservationList(ctx)
 	if err != nil {
 		return err
 	}
@@ -156,43 +205,24 @@ func filterReservation(rl []reservation, d int) []reservation {
 	return newRl
 }
 
-func getReservationList() ([]reservation, error) {
-	loc, err := time.LoadLocation("Asia/Tokyo")
-	if err != nil {
+func getReservationList(ctx context.Context) ([]reservation, error) {
+	pl, err := fc.ListPrograms(ctx)
+	var errs foltia.RowErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			log.Printf("解析できない予約番組をスキップ : %v", e)
+		}
+	} else if err != nil {
 		return []reservation{}, err
 	}
 	var rl []reservation
-	url := "http://" + conf.fHost + "/reservation/index.php"
-	for i := 1; i < 100; i++ {
-		u := url + fmt.Sprintf("?p=%d", i)
-		doc, err := goquery.NewDocument(u)
-		if err != nil {
-			return []reservation{}, err
-		}
-		data := doc.Find("#contents > table > tbody > tr")
-		if data.Length() == 1 {
-			break
-		}
-		data.Each(func(i int, s *goquery.Selection) {
-			if i != 0 {
-				var r reservation
-				r.TID, _ = strconv.Atoi(s.Find("td:nth-child(2)").Text())
-				if r.TID != -1 {
-					r.Station = strings.TrimSpace(s.Find("td:nth-child(3)").Text())
-					r.Title = strings.TrimSpace(s.Find("td:nth-child(4) > a").Text())
-					r.EpNum, _ = strconv.Atoi(s.Find("td:nth-child(5)").Text())
-					r.EpTitle = strings.TrimSpace(s.Find("td:nth-child(6)").Text())
-					t := strings.TrimSpace(s.Find("td:nth-child(7)").Text())
-					r.Time, _ = time.ParseInLocation("2006/01/02 15:04", strings.Split(t, "(")[0]+" "+strings.Split(strings.Split(t, ")")[1], " ")[1], loc)
-					rl = append(rl, r)
-				}
-			}
-		})
+	for _, p := range pl {
+		rl = append(rl, reservation{TID: p.TID, Station: p.Station, Title: p.Title, EpNum: p.EpNum, EpTitle: p.EpTitle, Time: p.Time})
 	}
 	return rl, nil
 }
 
-func checkFlag(n bool, t bool, p int, r int) int {
+func checkFlag(n bool, t bool, p int, r int, s bool) int {
 	count := 0
 	if n {
 		count++
@@ -206,79 +236,116 @@ func checkFlag(n bool, t bool, p int, r int) int {
 	if r != 0 {
 		count++
 	}
+	if s {
+		count++
+	}
 	return count
 }
 
-func showTSInfo(p int) error {
-	data, err := db.GetAllVideoFile()
+func showTSInfo(r db.Repos, p int) error {
+	data, err := r.VideoFiles.GetAllVideoFile()
 	if err != nil {
 		return err
 	}
-	title, err := db.GetAllTitle()
+	names, err := getTitleNames(r.Titles)
 	if err != nil {
 		return err
 	}
 	for _, d := range data {
 		if d.Drop > p {
-			var name string
-			for _, t := range title {
-				if t.TID == d.TID {
-					name = t.Title
-					break
-				}
-			}
-			if name != "" {
+			if name := names[d.TID]; name != "" {
 				fmt.Printf("%s(%d:%d) (%d) D:%d S:%d\n", name, d.TID, d.EpNum, d.PID, d.Drop, d.Scramble)
 			} else {
 				return fmt.Errorf("TIDが見つかりません : %d", d.TID)
 			}
+			sl, err := r.TSSegments.GetTSSegments(d.PID)
+			if err != nil {
+				return err
+			}
+			for _, s := range sl {
+				fmt.Printf("  %s total:%d D:%d S:%d\n", s.Name, s.Total, s.Drop, s.Scramble)
+			}
 		}
 	}
 	return nil
 }
 
-func showStatus() error {
-	s, err := getStatus()
+func showRecStatus(r db.Repos) error {
+	rsil, err := getRecStatusList(r)
 	if err != nil {
 		return err
 	}
-	fmt.Println("foltia ANIME LOCKERシステム情報")
-	fmt.Printf("  Version : %s\n", s.version)
-	fmt.Printf("  Serial No. : %s\n", s.serial)
-	fmt.Printf("  Running : %d days\n", s.runningDays)
-	fmt.Printf("  Storage : %s/%s (Rem %d%s)\n", s.storageRemain, s.storage, 100-s.storagePercent, "%")
+	for _, r := range rsil {
+		fmt.Println(r)
+	}
 	return nil
 }
 
-func getStatus() (foltiaStatus, error) {
-	url := "http://" + conf.fHost + "/setup/about.php"
-	doc, err := goquery.NewDocument(url)
+// getRecStatusList returns the recordings in the local DB which are not completed, in order of the recording time
+func getRecStatusList(r db.Repos) ([]recStatusInfo, error) {
+	vl, err := r.VideoFiles.GetAllVideoFile()
 	if err != nil {
-		return foltiaStatus{}, err
+		return []recStatusInfo{}, err
 	}
-	var fs foltiaStatus
-	fs.version = strings.TrimSpace(doc.Find("#setUpTable > table > tbody > tr:nth-child(2) > td:nth-child(2)").Text())
-	fs.serial = strings.TrimSpace(strings.Split(doc.Find("#setUpTable > table > tbody > tr:nth-child(3) > td:nth-child(2)").Text(), "\n")[0])
-	fs.runningDays, err = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(doc.Find("#setUpTable > table > tbody > tr:nth-child(8) > td:nth-child(2)").Text(), "days")))
+	names, err := getTitleNames(r.Titles)
 	if err != nil {
-		return foltiaStatus{}, err
+		return []recStatusInfo{}, err
 	}
-	url = "http://" + conf.fHost + "/recorded/recfiles_tid.php"
-	doc, err = goquery.NewDocument(url)
+	kl, err := r.KeywordRecs.GetAllKeywordRecFile()
 	if err != nil {
-		return foltiaStatus{}, err
+		return []recStatusInfo{}, err
+	}
+	var rsil []recStatusInfo
+	for _, v := range vl {
+		if v.RecStatus == string(foltia.RecCompleted) {
+			continue
+		}
+		rsil = append(rsil, recStatusInfo{Status: foltia.RecStatus(v.RecStatus), Text: v.RecStatusText, TID: v.TID, EpNum: v.EpNum, PID: v.PID, Title: names[v.TID], Station: v.Station, Time: v.Time, Since: v.RecStatusAt})
+	}
+	for _, k := range kl {
+		if k.RecStatus == string(foltia.RecCompleted) {
+			continue
+		}
+		rsil = append(rsil, recStatusInfo{Status: foltia.RecStatus(k.RecStatus), Text: k.RecStatusText, TID: -1, PID: k.PID, Title: k.Title, Station: k.Station, Time: k.Time, Since: k.RecStatusAt})
+	}
+	sort.Slice(rsil, func(i, j int) bool {
+		return rsil[i].Time.Before(rsil[j].Time)
+	})
+	return rsil, nil
+}
+
+func showStatus(ctx context.Context) error {
+	s, err := getStatus(ctx)
+	if err != nil {
+		return err
 	}
-	fs.storagePercent, err = strconv.Atoi(strings.TrimSuffix(doc.Find("#HDDremainder > dl > dd > span.spent").Text(), "%"))
+	fmt.Println("foltia ANIME LOCKERシステム情報")
+	fmt.Printf("  Version : %s\n", s.version)
+	fmt.Printf("  Profile : %s\n", s.profile)
+	fmt.Printf("  Serial No. : %s\n", s.serial)
+	fmt.Printf("  Running : %d days\n", s.runningDays)
+	fmt.Printf("  Storage : %s/%s (Rem %d%s)\n", s.storageRemain, s.storage, 100-s.storagePercent, "%")
+	return nil
+}
+
+func getStatus(ctx context.Context) (foltiaStatus, error) {
+	st, err := fc.SystemStatus(ctx)
 	if err != nil {
 		return foltiaStatus{}, err
 	}
-	fs.storage = strings.TrimSpace(doc.Find("#HDDtotal").Text())
-	fs.storageRemain = strings.TrimSpace(doc.Find("#HDDrest").Text())
+	var fs foltiaStatus
+	fs.version = st.Version
+	fs.profile = st.Profile
+	fs.serial = st.Serial
+	fs.storage = st.Storage
+	fs.storageRemain = st.StorageRemain
+	fs.storagePercent = st.StoragePercent
+	fs.runningDays = st.RunningDays
 	return fs, nil
 }
 
-func checkNewAnime() error {
-	nail, err := getNewAnime()
+func checkNewAnime(ctx context.Context) error {
+	nail, err := getNewAnime(ctx)
 	if err != nil {
 		return err
 	}
@@ -288,36 +355,20 @@ func checkNewAnime() error {
 	return nil
 }
 
-func getNewAnime() ([]newAnimeInfo, error) {
-	url := "http://" + conf.fHost + "/animeprogram/index.php?filter=crp&view=np"
-	doc, err := goquery.NewDocument(url)
+func getNewAnime(ctx context.Context) ([]newAnimeInfo, error) {
+	nal, err := fc.ListNewAnime(ctx)
 	if err != nil {
 		return []newAnimeInfo{}, err
 	}
 	var nail []newAnimeInfo
-	loc, err := time.LoadLocation("Asia/Tokyo")
-	if err != nil {
-		return []newAnimeInfo{}, err
+	for _, n := range nal {
+		nail = append(nail, newAnimeInfo{TID: n.TID, Station: n.Station, Title: n.Title, Time: n.Time})
 	}
-	doc.Find("#contents > table > tbody > tr").Each(func(i int, s *goquery.Selection) {
-		if i != 0 {
-			var n newAnimeInfo
-			n.TID, _ = strconv.Atoi(s.Find("td[class=TID]").Text())
-			n.Station = s.Find("td[class=station]").Text()
-			n.Title = s.Find("td[class=title]").Find("a").Text()
-			d := s.Find("td[class=date]").Text()
-			d1 := strings.Split(d, "(")[0]
-			d2 := strings.Split(d, "(")[1]
-			d2 = strings.Split(d2, ")")[1]
-			n.Time, _ = time.ParseInLocation("2006/01/02 15:04", strings.TrimSpace(d1)+" "+strings.TrimSpace(d2), loc)
-			nail = append(nail, n)
-		}
-	})
 	return nail, nil
 }
 
-func showTitle() error {
-	data, err := db.GetAllTitle()
+func showTitle(titles db.TitleRepo) error {
+	data, err := titles.GetAllTitle()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/liebe-magi/falko/foltia"
+)
+
+func TestCheckStatus(t *testing.T) {
+	s := setTestEnv(t)
+	s.SetVersion("6.1.6")
+	fs, err := getStatus(context.Background())
+	if err != nil {
+		t.Fatalf("getStatus : %v", err)
+	}
+	want := foltiaStatus{version: "6.1.6", profile: "v6", serial: "ANILOC-0000-1234", storage: "3.6TB", storageRemain: "2.1TB", storagePercent: 42, runningDays: 123}
+	if fs != want {
+		t.Errorf("getStatus = %+v, want %+v", fs, want)
+	}
+}
+
+func TestCheckNewAnime(t *testing.T) {
+	setTestEnv(t)
+	loc := tokyo(t)
+	nail, err := getNewAnime(context.Background())
+	if err != nil {
+		t.Fatalf("getNewAnime : %v", err)
+	}
+	want := []newAnimeInfo{
+		{TID: 1730, Station: "BS11イレブン", Title: "とある科学の超電磁砲T", Time: time.Date(2020, 5, 30, 23, 0, 0, 0, loc)},
+		{TID: 5800, Station: "TOKYO MX", Title: "新作テストアニメ", Time: time.Date(2020, 6, 2, 1, 5, 0, 0, loc)},
+	}
+	if len(nail) != len(want) {
+		t.Fatalf("getNewAnime = %+v, want %+v", nail, want)
+	}
+	for i := range want {
+		if nail[i].TID != want[i].TID || nail[i].Station != want[i].Station || nail[i].Title != want[i].Title || !nail[i].Time.Equal(want[i].Time) {
+			t.Errorf("getNewAnime[%d] = %+v, want %+v", i, nail[i], want[i])
+		}
+	}
+}
+
+func TestCheckReservationList(t *testing.T) {
+	setTestEnv(t)
+	rl, err := getReservationList(context.Background())
+	if err != nil {
+		t.Fatalf("getReservationList : %v", err)
+	}
+	want := reservation{TID: 1730, Station: "BS11イレブン", Title: "とある科学の超電磁砲T", EpNum: 3, EpTitle: "姉妹", Time: time.Date(2020, 6, 6, 23, 0, 0, 0, tokyo(t))}
+	if len(rl) != 1 || rl[0].TID != want.TID || rl[0].Station != want.Station || rl[0].Title != want.Title || rl[0].EpNum != want.EpNum || rl[0].EpTitle != want.EpTitle || !rl[0].Time.Equal(want.Time) {
+		t.Errorf("getReservationList = %+v, want [%+v]", rl, want)
+	}
+	if fl := filterReservation(rl, 1); len(fl) != 1 {
+		t.Errorf("filterReservation of the past program = %+v, want it kept", fl)
+	}
+}
+
+func TestCheckRecStatus(t *testing.T) {
+	setTestEnv(t)
+	runUpdate(t, false)
+	rsil, err := getRecStatusList(store.Repos())
+	if err != nil {
+		t.Fatalf("getRecStatusList : %v", err)
+	}
+	if len(rsil) != 1 {
+		t.Fatalf("getRecStatusList = %+v, want only 100004", rsil)
+	}
+	r := rsil[0]
+	if r.PID != 100004 || r.Status != foltia.RecEncoding || r.TID != 1730 || r.EpNum != 2 || r.Title != "とある科学の超電磁砲T" || r.Since.IsZero() {
+		t.Errorf("getRecStatusList[0] = %+v", r)
+	}
+}
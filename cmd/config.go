@@ -23,11 +23,17 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/liebe-magi/falko/foltia"
 	"github.com/spf13/cobra"
 )
 
 var (
 	host         string
+	user         string
+	password     string
+	caFile       string
+	insecure     bool
+	profile      string
 	path         string
 	dest         string
 	filename     string
@@ -40,6 +46,10 @@ var (
 	slackTime    string
 	slackName    string
 	slackChannel string
+	httpTimeout  int
+	httpRetry    int
+	concurrency  int
+	rps          float64
 )
 
 // configCmd represents the config command
@@ -47,13 +57,34 @@ var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "設定変更",
 	Run: func(cmd *cobra.Command, args []string) {
-		if checkFlags() {
+		insecureSet := cmd.Flags().Changed("foltia-insecure")
+		if checkFlags() && !insecureSet {
 			fmt.Println(conf)
 			os.Exit(0)
 		}
 		if host != "" {
 			conf.fHost = host
 		}
+		if user != "" {
+			conf.fUser = user
+		}
+		if password != "" {
+			conf.fPassword = password
+		}
+		if caFile != "" {
+			conf.fCAFile = caFile
+		}
+		if insecureSet {
+			conf.fInsecure = insecure
+		}
+		if profile == "auto" {
+			conf.fProfile = ""
+		} else if profile != "" {
+			if _, err := foltia.ProfileByName(profile); err != nil {
+				log.Fatalln(err)
+			}
+			conf.fProfile = profile
+		}
 		if path != "" {
 			conf.fPath = path
 		}
@@ -81,6 +112,18 @@ var configCmd = &cobra.Command{
 		if slackToken != "" {
 			conf.sToken = slackToken
 		}
+		if httpTimeout >= 0 {
+			conf.httpTimeout = httpTimeout
+		}
+		if httpRetry >= 0 {
+			conf.httpRetry = httpRetry
+		}
+		if concurrency > 0 {
+			conf.concurrency = concurrency
+		}
+		if rps >= 0 {
+			conf.rps = rps
+		}
 		err := checkTime(slackTime)
 		if err != nil {
 			log.Fatalln(err)
@@ -113,7 +156,12 @@ func writeConfig() error {
 func init() {
 	rootCmd.AddCommand(configCmd)
 
-	configCmd.Flags().StringVarP(&host, "foltia-ip", "i", "", "foltia ANIME LOCKERのIPアドレスを設定")
+	configCmd.Flags().StringVarP(&host, "foltia-ip", "i", "", "foltia ANIME LOCKERのIPアドレスまたはURLを設定")
+	configCmd.Flags().StringVar(&user, "foltia-user", "", "foltia ANIME LOCKERのBasic認証のユーザー名を設定")
+	configCmd.Flags().StringVar(&password, "foltia-password", "", "foltia ANIME LOCKERのBasic認証のパスワードを設定")
+	configCmd.Flags().StringVar(&caFile, "foltia-ca", "", "foltia ANIME LOCKERのサーバー証明書を検証するCA証明書ファイルを設定")
+	configCmd.Flags().BoolVar(&insecure, "foltia-insecure", false, "foltia ANIME LOCKERのサーバー証明書を検証しない")
+	configCmd.Flags().StringVar(&profile, "foltia-profile", "", "foltia ANIME LOCKERの画面解析プロファイルを設定 (autoでバージョンから自動選択)")
 	configCmd.Flags().StringVarP(&path, "foltia-path", "s", "", "foltia ANIME LOCKERをマウントしているディレクトリを設定")
 	configCmd.Flags().StringVarP(&dest, "dest-copy", "d", "", "コピー先のディレクトリを設定")
 	configCmd.Flags().StringVarP(&filename, "filename", "n", "", "コピー時のファイル名フォーマットを設定")
@@ -124,10 +172,14 @@ func init() {
 	configCmd.Flags().IntVarP(&mp4cut, "mp4cm_cut", "y", -1, "予約時のMP4編集設定")
 	configCmd.Flags().StringVarP(&slackToken, "slack_token", "b", "", "Slack botトークンの設定")
 	configCmd.Flags().StringVarP(&slackTime, "slack_time", "c", "00:00", "Slack通知を送る時間の設定")
+	configCmd.Flags().IntVar(&httpTimeout, "http-timeout", -1, "HTTPリクエスト1回あたりのタイムアウト秒数の設定")
+	configCmd.Flags().IntVar(&httpRetry, "http-retry", -1, "HTTPリクエスト失敗時の最大試行回数の設定")
+	configCmd.Flags().IntVar(&concurrency, "update-concurrency", 0, "ローカルDB更新時の同時リクエスト数の設定")
+	configCmd.Flags().Float64Var(&rps, "foltia-rps", -1, "foltia ANIME LOCKERへの毎秒リクエスト数の上限の設定 (0で無制限)")
 }
 
 func checkFlags() bool {
-	if host == "" && path == "" && dest == "" && filename == "" && filetype == "" && dropThresh == 0 && encQuality == -1 && mp2cut == -1 && mp4cut == -1 && slackToken == "" && slackTime == "00:00" {
+	if host == "" && user == "" && password == "" && caFile == "" && profile == "" && path == "" && dest == "" && filename == "" && filetype == "" && dropThresh == -1 && encQuality == -1 && mp2cut == -1 && mp4cut == -1 && slackToken == "" && slackTime == "00:00" && httpTimeout == -1 && httpRetry == -1 && concurrency == 0 && rps == -1 {
 		return true
 	}
 	return false
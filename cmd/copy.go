@@ -16,6 +16,8 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,9 +25,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
 	"github.com/spf13/cobra"
 )
 
@@ -58,6 +62,15 @@ var copyCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalln(err)
 		}
+		wait, err := cmd.Flags().GetDuration("wait")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if wait > 0 && (list || reset) {
+			log.Fatalln("--waitはコピー時のみ指定して下さい")
+		}
+		openStore()
+		repos := store.Repos()
 		tid := -1
 		epNum := -1
 		if len(args) == 1 {
@@ -76,7 +89,7 @@ var copyCmd = &cobra.Command{
 			}
 		}
 		if list && !reset {
-			err = showCopyList(tid, epNum, ignore)
+			err = showCopyList(repos, tid, epNum, ignore)
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -84,13 +97,19 @@ var copyCmd = &cobra.Command{
 			if len(args) != 2 {
 				log.Fatalln("TIDとエピソード番号を指定して下さい")
 			} else {
-				err = resetCopyStatus(tid, epNum)
+				err = resetCopyStatus(repos, tid, epNum)
 				if err != nil {
 					log.Fatalln(err)
 				}
 			}
 		} else if !list && !reset {
-			err = copyFiles(tid, epNum, ignore)
+			if wait > 0 {
+				err = waitRecordings(cmd.Context(), repos, tid, epNum, wait)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+			err = copyFiles(cmd.Context(), repos, tid, epNum, ignore)
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -106,10 +125,81 @@ func init() {
 	copyCmd.Flags().BoolP("list", "l", false, "コピー予定のファイル一覧を表示")
 	copyCmd.Flags().BoolP("reset", "r", false, "動画ファイルのコピー済みフラグを削除")
 	copyCmd.Flags().BoolP("ignoreDrop", "i", false, "TSドロップを無視してコピー")
+	copyCmd.Flags().DurationP("wait", "w", 0, "録画中・MP4変換中の録画が完了するまでローカルDBを更新しながら待機する最大時間 (ex: 30m)")
 }
 
-func showCopyList(tid int, epNum int, ignore bool) error {
-	fcil, err := getCopyList(ignore)
+// copyWaitInterval is the interval of the local DB update while waiting for the recordings
+const copyWaitInterval = time.Minute
+
+// waitRecordings updates the local DB until no target recording is being recorded or encoded, or the wait expires
+func waitRecordings(ctx context.Context, r db.Repos, tid int, epNum int, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		n, err := countInProgress(r, tid, epNum)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			log.Printf("待機時間を過ぎたため、完了している録画のみコピーします (未完了 : %d件)", n)
+			return nil
+		}
+		log.Printf("録画中・MP4変換中の録画が%d件あるため待機", n)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(copyWaitInterval, remain)):
+		}
+		p, err := planUpdate(ctx, r, false, tsRefresh{})
+		if err != nil {
+			return err
+		}
+		err = applyUpdate(r, p)
+		if err != nil {
+			return err
+		}
+		if len(p.Errors) > 0 {
+			p.printErrors()
+		}
+	}
+}
+
+// countInProgress returns the number of the target recordings being recorded or encoded
+func countInProgress(r db.Repos, tid int, epNum int) (int, error) {
+	inProgress := func(status string) bool {
+		return status == string(foltia.RecRecording) || status == string(foltia.RecEncoding)
+	}
+	n := 0
+	vl, err := r.VideoFiles.GetAllVideoFile()
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range vl {
+		if !inProgress(v.RecStatus) || (tid != -1 && v.TID != tid) || (epNum != -1 && v.EpNum != epNum) {
+			continue
+		}
+		n++
+	}
+	if tid != -1 {
+		return n, nil
+	}
+	kl, err := r.KeywordRecs.GetAllKeywordRecFile()
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range kl {
+		if !k.Copy && inProgress(k.RecStatus) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func showCopyList(r db.Repos, tid int, epNum int, ignore bool) error {
+	fcil, err := getCopyList(r, ignore)
 	if err != nil {
 		return err
 	}
@@ -140,9 +230,9 @@ func filter(fcil []fileCopyInfo, tid int, epNum int) ([]fileCopyInfo, error) {
 	return fcilNew, nil
 }
 
-func copyFiles(tid int, epNum int, ignore bool) error {
+func copyFiles(ctx context.Context, r db.Repos, tid int, epNum int, ignore bool) error {
 	log.Println("コピー開始")
-	fcil, err := getCopyList(ignore)
+	fcil, err := getCopyList(r, ignore)
 	if err != nil {
 		return err
 	}
@@ -152,15 +242,10 @@ func copyFiles(tid int, epNum int, ignore bool) error {
 			return err
 		}
 	}
-	ep, err := db.GetAllEpisode()
-	if err != nil {
-		return err
-	}
-	key, err := db.GetAllKeywordRecFile()
-	if err != nil {
-		return err
-	}
 	for i, f := range fcil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		log.Printf("[%d/%d] %s (%d:%s)", i+1, len(fcil), f.title, f.epNum, f.epTitle)
 		if f.scramble {
 			log.Println("スクランブルが未解除")
@@ -169,30 +254,43 @@ func copyFiles(tid int, epNum int, ignore bool) error {
 		src := filepath.Join(conf.fPath, f.srcname)
 		f.dstname = fixFileName(f.dstname)
 		dst := filepath.Join(conf.cDest, f.dstname)
-		err = copyVideoFile(src, dst)
+		err = copyVideoFile(ctx, src, dst)
 		if err != nil {
 			return err
 		}
-		if f.tid != -1 {
-			for _, e := range ep {
-				if !ignore && (e.TID == f.tid && e.EpNum == f.epNum) {
-					db.UpdateEpisode(e.ID, e.TID, e.EpNum, e.EpTitle, true)
-					break
-				}
-			}
-		} else {
-			for _, k := range key {
-				if f.pid == k.PID {
-					db.UpdateKeywordRecFile(k.ID, k.Keyword, k.Title, k.PID, k.FileTS, k.FileMP4HD, k.FileMP4SD, k.Station, k.Time, k.Drop, k.Scramble, true)
-					break
-				}
-			}
+		err = setCopied(r, f, ignore)
+		if err != nil {
+			return err
 		}
 	}
 	log.Println("コピー完了")
 	return nil
 }
 
+// setCopied sets the copy flag of the episode or the keyword recording of the copied file.
+// The episode is left as it is if ignore is set.
+func setCopied(r db.Repos, f fileCopyInfo, ignore bool) error {
+	if f.tid != -1 {
+		if ignore {
+			return nil
+		}
+		e, err := r.Episodes.GetEpisodeByTIDEpNum(f.tid, f.epNum)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return r.Episodes.UpdateEpisode(e.ID, e.TID, e.EpNum, e.EpTitle, true)
+	}
+	k, err := r.KeywordRecs.GetKeywordRecFileByPID(f.pid)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return r.KeywordRecs.UpdateKeywordRecFile(k.ID, k.Keyword, k.Title, k.PID, k.FileTS, k.FileMP4HD, k.FileMP4SD, k.Station, k.Time, k.Drop, k.Scramble, true)
+}
+
 func fixFileName(name string) string {
 	name = strings.Replace(name, "/", "-", -1)
 	name = strings.Replace(name, "?", "？", -1)
@@ -212,7 +310,7 @@ func fixFileNameLength(name string) string {
 	return fixFileNameLength(nn + "." + n[1])
 }
 
-func copyVideoFile(src string, dst string) error {
+func copyVideoFile(ctx context.Context, src string, dst string) error {
 	s, err := os.Open(src)
 	if err != nil {
 		return err
@@ -230,10 +328,17 @@ func copyVideoFile(src string, dst string) error {
 	defer d.Close()
 
 	bar := pb.New64(srcSize).SetTemplateString(barTemp).Start()
-	reader := bar.NewProxyReader(s)
+	reader := bar.NewProxyReader(ctxReader{ctx: ctx, r: s})
 
 	for {
 		_, err = io.Copy(d, reader)
+		if ctx.Err() != nil {
+			bar.Finish()
+			d.Close()
+			os.Remove(dst)
+			log.Printf("コピーを中断しました : %s", dst)
+			return ctx.Err()
+		}
 		if err != nil {
 			log.Println(err)
 			log.Println("コピー処理が失敗しました。リトライします。")
@@ -246,106 +351,131 @@ func copyVideoFile(src string, dst string) error {
 	return nil
 }
 
-func getCopyList(ignore bool) ([]fileCopyInfo, error) {
-	title, err := db.GetAllTitle()
+// ctxReader stops reading when the context is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func getCopyList(r db.Repos, ignore bool) ([]fileCopyInfo, error) {
+	title, err := r.Titles.GetAllTitle()
 	if err != nil {
 		return []fileCopyInfo{}, err
 	}
-	episode, err := db.GetAllEpisode()
+	episode, err := r.Episodes.GetAllEpisode()
 	if err != nil {
 		return []fileCopyInfo{}, err
 	}
-	videofile, err := db.GetAllVideoFile()
+	videofile, err := r.VideoFiles.GetAllVideoFile()
 	if err != nil {
 		return []fileCopyInfo{}, err
 	}
+	episodes := map[int][]db.AnimeEpisode{}
+	for _, e := range episode {
+		if !e.CopyStatus {
+			episodes[e.TID] = append(episodes[e.TID], e)
+		}
+	}
+	files := map[episodeKey][]db.VideoFile{}
+	for _, v := range videofile {
+		key := episodeKey{v.TID, v.EpNum}
+		files[key] = append(files[key], v)
+	}
 	var fcil []fileCopyInfo
 	for _, t := range title {
-		for _, e := range episode {
-			if e.CopyStatus {
-				continue
-			}
-			if t.TID == e.TID {
-				var f fileCopyInfo
-				f.tid = t.TID
-				f.title = t.Title
-				f.epNum = e.EpNum
-				f.epTitle = e.EpTitle
-				f.dstname = conf.cFilename
-				f.dstname = strings.Replace(f.dstname, "%title%", t.Title, -1)
-				f.dstname = strings.Replace(f.dstname, "%epnum%", fmt.Sprintf("%02d", e.EpNum), -1)
-				f.dstname = strings.Replace(f.dstname, "%eptitle%", e.EpTitle, -1)
-				if conf.cFiletype == "TS" {
-					f.dstname = f.dstname + ".ts"
-				} else if conf.cFiletype == "MP4" {
-					f.dstname = f.dstname + ".mp4"
-				} else {
-					return []fileCopyInfo{}, fmt.Errorf("設定が異常値 : copy_filetype")
-				}
+		for _, e := range episodes[t.TID] {
+			var f fileCopyInfo
+			f.tid = t.TID
+			f.title = t.Title
+			f.epNum = e.EpNum
+			f.epTitle = e.EpTitle
+			var recTime time.Time
 
-				nonDropExists := false
-				fileExists := false
-				for _, v := range videofile {
-					if e.TID == v.TID && e.EpNum == v.EpNum {
-						fileExists = true
-						if ignore || (v.Drop < conf.cDropThresh) {
-							nonDropExists = true
-							if f.srcname == "" {
-								name, check := getSrcname(v)
-								if check {
-									f.srcname = name
-									f.pid = v.PID
-									f.station = v.Station
-									if v.Scramble != 0 {
-										f.scramble = true
-									} else {
-										f.scramble = false
-									}
-								} else {
-									continue
-								}
+			nonDropExists := false
+			fileExists := false
+			var unfinished []string
+			for _, v := range files[episodeKey{e.TID, e.EpNum}] {
+				if v.RecStatus != string(foltia.RecCompleted) {
+					unfinished = append(unfinished, foltia.RecStatus(v.RecStatus).Label())
+					continue
+				}
+				fileExists = true
+				if ignore || (v.Drop < conf.cDropThresh) {
+					nonDropExists = true
+					if f.srcname == "" {
+						name, check := getSrcname(v)
+						if check {
+							f.srcname = name
+							f.pid = v.PID
+							f.station = v.Station
+							recTime = v.Time
+							if v.Scramble != 0 {
+								f.scramble = true
 							} else {
-								p1, err := getStationPriority(f.station)
-								if err != nil {
-									return []fileCopyInfo{}, err
-								}
-								p2, err := getStationPriority(v.Station)
-								if err != nil {
-									return []fileCopyInfo{}, err
-								}
-								if p2 > p1 {
-									name, check := getSrcname(v)
-									if check {
-										f.srcname = name
-										f.pid = v.PID
-										f.station = v.Station
-										if v.Scramble != 0 {
-											f.scramble = true
-										} else {
-											f.scramble = false
-										}
-									}
+								f.scramble = false
+							}
+						} else {
+							continue
+						}
+					} else {
+						p1, err := getStationPriority(f.station)
+						if err != nil {
+							return []fileCopyInfo{}, err
+						}
+						p2, err := getStationPriority(v.Station)
+						if err != nil {
+							return []fileCopyInfo{}, err
+						}
+						if p2 > p1 {
+							name, check := getSrcname(v)
+							if check {
+								f.srcname = name
+								f.pid = v.PID
+								f.station = v.Station
+								recTime = v.Time
+								if v.Scramble != 0 {
+									f.scramble = true
+								} else {
+									f.scramble = false
 								}
 							}
 						}
 					}
 				}
-				if f.srcname != "" {
-					fcil = append(fcil, f)
-				} else {
-					if !nonDropExists && fileExists {
-						log.Printf("設定値を超えたTSドロップが発生 : %s (%d:%s)", t.Title, e.EpNum, e.EpTitle)
-					}
+			}
+			if f.srcname != "" {
+				f.dstname, err = copyDstName(t, e, recTime)
+				if err != nil {
+					return []fileCopyInfo{}, err
+				}
+				fcil = append(fcil, f)
+			} else if len(unfinished) > 0 {
+				log.Printf("録画が完了していないためコピーを保留 : %s (%d:%s) [%s]", t.Title, e.EpNum, e.EpTitle, strings.Join(unfinished, ", "))
+			} else {
+				if !nonDropExists && fileExists {
+					log.Printf("設定値を超えたTSドロップが発生 : %s (%d:%s)", t.Title, e.EpNum, e.EpTitle)
 				}
 			}
 		}
 	}
+	fcil = renameDuplicates(fcil)
 	//キーワード録画を追加
-	key, err := db.GetAllKeywordRecFile()
+	key, err := r.KeywordRecs.GetAllKeywordRecFile()
 	if err != nil {
 		return []fileCopyInfo{}, err
 	}
 	for _, k := range key {
+		if !k.Copy && k.RecStatus != string(foltia.RecCompleted) {
+			log.Printf("録画が完了していないためコピーを保留 : %s [%s]", k.Title, foltia.RecStatus(k.RecStatus).Label())
+			continue
+		}
 		if !k.Copy {
 			var fci fileCopyInfo
 			fci.title = k.Title
@@ -378,9 +508,44 @@ func getCopyList(ignore bool) ([]fileCopyInfo, error) {
 	return fcil, nil
 }
 
+// copyDstName makes the file name of the episode from copy_filename.
+// Every special is "SP" in %epnum%, so the time of the recording is added to tell them apart.
+func copyDstName(t db.AnimeTitle, e db.AnimeEpisode, recTime time.Time) (string, error) {
+	name := conf.cFilename
+	name = strings.Replace(name, "%title%", t.Title, -1)
+	epnum := fmt.Sprintf("%02d", e.EpNum)
+	if e.Special {
+		epnum = "SP" + recTime.Format("20060102-1504")
+	}
+	name = strings.Replace(name, "%epnum%", epnum, -1)
+	name = strings.Replace(name, "%eptitle%", e.EpTitle, -1)
+	if conf.cFiletype == "TS" {
+		return name + ".ts", nil
+	} else if conf.cFiletype == "MP4" {
+		return name + ".mp4", nil
+	}
+	return "", fmt.Errorf("設定が異常値 : copy_filetype")
+}
+
+// renameDuplicates adds the PID to the file names used by more than one file so that they do not overwrite each other.
+// It happens if the format has no %epnum% or specials are recorded at the same time.
+func renameDuplicates(fcil []fileCopyInfo) []fileCopyInfo {
+	count := map[string]int{}
+	for _, f := range fcil {
+		count[f.dstname]++
+	}
+	for i, f := range fcil {
+		if count[f.dstname] > 1 {
+			ext := filepath.Ext(f.dstname)
+			fcil[i].dstname = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(f.dstname, ext), f.pid, ext)
+			log.Printf("コピー先のファイル名が重複するためPIDを付加 : %s", fcil[i].dstname)
+		}
+	}
+	return fcil
+}
+
 func getStationPriority(st string) (int, error) {
-	station := getStationList()
-	for _, s := range station {
+	for _, s := range foltia.Stations() {
 		if s.Name == st {
 			return s.StType, nil
 		}
@@ -426,23 +591,17 @@ func showList(fcil []fileCopyInfo) {
 	}
 }
 
-func resetCopyStatus(t int, e int) error {
-	data, err := db.GetAllEpisode()
-	if err != nil {
+func resetCopyStatus(r db.Repos, t int, e int) error {
+	d, err := r.Episodes.GetEpisodeByTIDEpNum(t, e)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	} else if err != nil {
 		return err
 	}
-	for _, d := range data {
-		if d.TID == t && d.EpNum == e {
-			title, err := getTitle(t)
-			if err != nil {
-				return err
-			}
-			log.Printf("コピー済みフラグをリセット : (%d)%s (%d:%s)", d.TID, title, d.EpNum, d.EpTitle)
-			err = db.UpdateEpisode(d.ID, d.TID, d.EpNum, d.EpTitle, false)
-			if err != nil {
-				return err
-			}
-		}
+	title, err := getTitle(r.Titles, t)
+	if err != nil {
+		return err
 	}
-	return nil
+	log.Printf("コピー済みフラグをリセット : (%d)%s (%d:%s)", d.TID, title, d.EpNum, d.EpTitle)
+	return r.Episodes.UpdateEpisode(d.ID, d.TID, d.EpNum, d.EpTitle, false)
 }
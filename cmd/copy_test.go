@@ -0,0 +1,312 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
+)
+
+// seedRepos returns the repositories in memory with the rows written by f
+func seedRepos(t *testing.T, f func(b db.Batch) error) db.Repos {
+	t.Helper()
+	m := db.NewMemory()
+	if err := m.ApplyBatch(f); err != nil {
+		t.Fatalf("ApplyBatch : %v", err)
+	}
+	return m.Repos()
+}
+
+// setCopyConf sets the copy settings for the test and restores them after it
+func setCopyConf(t *testing.T, filename string, filetype string, dropThresh int) {
+	saved := conf
+	t.Cleanup(func() { conf = saved })
+	conf.cFilename = filename
+	conf.cFiletype = filetype
+	conf.cDropThresh = dropThresh
+}
+
+func TestGetCopyList(t *testing.T) {
+	setCopyConf(t, "%title%_%epnum%_%eptitle%", "TS", 10)
+	loc := tokyo(t)
+	at := func(day, hour int) time.Time { return time.Date(2020, 6, day, hour, 0, 0, 0, loc) }
+	recording := string(foltia.RecRecording)
+	r := seedRepos(t, func(b db.Batch) error {
+		err := b.UpsertTitles([]db.AnimeTitle{{TID: 100, Title: "タイトルA"}, {TID: 200, Title: "タイトルB"}})
+		if err != nil {
+			return err
+		}
+		err = b.UpsertEpisodes([]db.AnimeEpisode{
+			{TID: 100, EpNum: 1, EpTitle: "第一話"},
+			{TID: 100, EpNum: 2, EpTitle: "第二話", CopyStatus: true},
+			{TID: 100, EpNum: -5001, EpTitle: "特別編", Special: true},
+			{TID: 100, EpNum: -5002, EpTitle: "特別編", Special: true},
+			{TID: 100, EpNum: -5003, EpTitle: "特別編", Special: true},
+			{TID: 200, EpNum: 1, EpTitle: "始まり"},
+			{TID: 200, EpNum: 2, EpTitle: "録画中"},
+			{TID: 200, EpNum: 3, EpTitle: "ドロップ"},
+		})
+		if err != nil {
+			return err
+		}
+		err = b.UpsertVideoFiles([]db.VideoFile{
+			{TID: 100, EpNum: 1, PID: 1, FileTS: "1.m2t", Station: "NHK総合", Time: at(1, 23)},
+			{TID: 100, EpNum: 2, PID: 2, FileTS: "2.m2t", Station: "NHK総合", Time: at(8, 23)},
+			{TID: 100, EpNum: -5001, PID: 3, FileTS: "3.m2t", Station: "NHK総合", Time: at(2, 23)},
+			{TID: 100, EpNum: -5002, PID: 4, FileTS: "4.m2t", Station: "NHK総合", Time: at(3, 23)},
+			{TID: 100, EpNum: -5003, PID: 5, FileTS: "5.m2t", Station: "BSフジ", Time: at(3, 23)},
+			{TID: 200, EpNum: 1, PID: 6, FileTS: "6.m2t", Station: "NHK総合", Time: at(4, 1)},
+			{TID: 200, EpNum: 1, PID: 7, FileTS: "7.m2t", Station: "BSフジ", Time: at(4, 2), Scramble: 1},
+			{TID: 200, EpNum: 2, PID: 8, FileTS: "8.m2t", Station: "NHK総合", Time: at(5, 1), RecStatus: recording},
+			{TID: 200, EpNum: 3, PID: 9, FileTS: "9.m2t", Station: "NHK総合", Time: at(6, 1), Drop: 50},
+		})
+		if err != nil {
+			return err
+		}
+		return b.UpsertKeywordRecFiles([]db.KeywordRecFile{
+			{Keyword: "声優", Title: "ラジオ", PID: 10, FileTS: "10.m2t", Station: "NHK総合", Time: at(7, 12), Drop: 3},
+			{Keyword: "声優", Title: "コピー済み", PID: 11, FileTS: "11.m2t", Station: "NHK総合", Time: at(7, 13), Copy: true},
+			{Keyword: "声優", Title: "録画中", PID: 12, FileTS: "12.m2t", Station: "NHK総合", Time: at(7, 14), RecStatus: recording},
+		})
+	})
+
+	fcil, err := getCopyList(r, false)
+	if err != nil {
+		t.Fatalf("getCopyList : %v", err)
+	}
+	type copyFile struct {
+		src      string
+		dst      string
+		scramble bool
+	}
+	want := map[int]copyFile{
+		1:  {"1.m2t", "タイトルA_01_第一話.ts", false},
+		3:  {"3.m2t", "タイトルA_SP20200602-2300_特別編.ts", false},
+		4:  {"4.m2t", "タイトルA_SP20200603-2300_特別編_4.ts", false},
+		5:  {"5.m2t", "タイトルA_SP20200603-2300_特別編_5.ts", false},
+		7:  {"7.m2t", "タイトルB_01_始まり.ts", true},
+		10: {"10.m2t", "[D3]声優(NHK総合)_20200607120000_ラジオ.ts", false},
+	}
+	got := map[int]copyFile{}
+	for _, f := range fcil {
+		got[f.pid] = copyFile{f.srcname, f.dstname, f.scramble}
+	}
+	if len(got) != len(fcil) {
+		t.Errorf("getCopyList has duplicate PIDs : %+v", fcil)
+	}
+	for pid, w := range want {
+		if g, ok := got[pid]; !ok {
+			t.Errorf("PID %d is not in the copy list", pid)
+		} else if g != w {
+			t.Errorf("PID %d = %+v, want %+v", pid, g, w)
+		}
+	}
+	for pid := range got {
+		if _, ok := want[pid]; !ok {
+			t.Errorf("PID %d should not be in the copy list", pid)
+		}
+	}
+
+	fcil, err = getCopyList(r, true)
+	if err != nil {
+		t.Fatalf("getCopyList ignoring drops : %v", err)
+	}
+	found := false
+	for _, f := range fcil {
+		found = found || f.pid == 9
+	}
+	if !found {
+		t.Errorf("getCopyList ignoring drops does not have PID 9")
+	}
+}
+
+func TestGetCopyListInvalidFiletype(t *testing.T) {
+	setCopyConf(t, "%title%_%epnum%", "MKV", 10)
+	r := seedRepos(t, func(b db.Batch) error {
+		err := b.UpsertTitles([]db.AnimeTitle{{TID: 100, Title: "タイトルA"}})
+		if err != nil {
+			return err
+		}
+		err = b.UpsertEpisodes([]db.AnimeEpisode{{TID: 100, EpNum: 1}})
+		if err != nil {
+			return err
+		}
+		return b.UpsertVideoFiles([]db.VideoFile{{TID: 100, EpNum: 1, PID: 1, FileMP4HD: "1.mp4", Station: "NHK総合"}})
+	})
+	_, err := getCopyList(r, false)
+	if err == nil {
+		t.Errorf("getCopyList with copy_filetype MKV succeeded")
+	}
+}
+
+func TestWaitRecordings(t *testing.T) {
+	recording := string(foltia.RecRecording)
+	encoding := string(foltia.RecEncoding)
+	r := seedRepos(t, func(b db.Batch) error {
+		err := b.UpsertVideoFiles([]db.VideoFile{
+			{TID: 100, EpNum: 1, PID: 1, Station: "NHK総合", RecStatus: recording},
+			{TID: 100, EpNum: 2, PID: 2, Station: "NHK総合"},
+			{TID: 100, EpNum: 3, PID: 3, Station: "NHK総合", RecStatus: string(foltia.RecFailed)},
+			{TID: 200, EpNum: 1, PID: 4, Station: "NHK総合", RecStatus: encoding},
+		})
+		if err != nil {
+			return err
+		}
+		return b.UpsertKeywordRecFiles([]db.KeywordRecFile{
+			{Keyword: "声優", PID: 5, Station: "NHK総合", RecStatus: encoding},
+			{Keyword: "声優", PID: 6, Station: "NHK総合", RecStatus: recording, Copy: true},
+		})
+	})
+
+	for _, c := range []struct {
+		tid   int
+		epNum int
+		want  int
+	}{
+		{100, 1, 1},
+		{100, 2, 0},
+		{100, 3, 0},
+		{100, -1, 1},
+		{200, -1, 1},
+		{-1, -1, 3},
+	} {
+		n, err := countInProgress(r, c.tid, c.epNum)
+		if err != nil {
+			t.Fatalf("countInProgress(%d, %d) : %v", c.tid, c.epNum, err)
+		}
+		if n != c.want {
+			t.Errorf("countInProgress(%d, %d) = %d, want %d", c.tid, c.epNum, n, c.want)
+		}
+	}
+
+	// These return without updating the local DB, which would fail since there is no foltia client
+	ctx := context.Background()
+	err := waitRecordings(ctx, r, 100, 2, time.Hour)
+	if err != nil {
+		t.Errorf("waitRecordings without recordings in progress : %v", err)
+	}
+	err = waitRecordings(ctx, r, 100, 1, 0)
+	if err != nil {
+		t.Errorf("waitRecordings after the wait expires : %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = waitRecordings(canceled, r, -1, -1, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("waitRecordings with the canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestCopyFiles(t *testing.T) {
+	setTestEnv(t)
+	runUpdate(t, false)
+	setCopyConf(t, "%title%_%epnum%_%eptitle%", "TS", 10)
+	conf.fPath = t.TempDir()
+	conf.cDest = t.TempDir()
+	for _, name := range []string{"1730-1-20200516-2300.m2t", "1730-1-20200517-0130.m2t", "1730-0-20200530-2300.m2t", "1730-2-20200523-2300.m2t", "5500-1-20200531-2100.m2t", "K1-20200529-2200.m2t"} {
+		err := os.WriteFile(filepath.Join(conf.fPath, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := store.Repos()
+	err := copyFiles(context.Background(), r, -1, -1, false)
+	if err != nil {
+		t.Fatalf("copyFiles : %v", err)
+	}
+	want := map[string]string{
+		"とある科学の超電磁砲T_01_超電磁砲.ts":                       "1730-1-20200516-2300.m2t",
+		"とある科学の超電磁砲T_SP20200530-2300_特別編.ts":           "1730-0-20200530-2300.m2t",
+		"劇場版テスト_01_.ts":                                "5500-1-20200531-2100.m2t",
+		"[S][D0]声優(NHK総合)_20200529220000_声優特番スペシャル.ts": "K1-20200529-2200.m2t",
+	}
+	entries, err := os.ReadDir(conf.cDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Errorf("%d files are copied, want %d", len(entries), len(want))
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(conf.cDest, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src, ok := want[e.Name()]; !ok || string(b) != src {
+			t.Errorf("%s is copied from %s, want %q", e.Name(), b, src)
+		}
+	}
+
+	for _, c := range []struct {
+		tid    int
+		epNum  int
+		copied bool
+	}{
+		{1730, 1, true},
+		{1730, -5001, true},
+		{1730, 2, false},
+		{5500, 1, true},
+	} {
+		e, err := store.GetEpisodeByTIDEpNum(c.tid, c.epNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.CopyStatus != c.copied {
+			t.Errorf("copy status of %d:%d = %t, want %t", c.tid, c.epNum, e.CopyStatus, c.copied)
+		}
+	}
+	k, err := store.GetKeywordRecFileByPID(300001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k.Copy {
+		t.Errorf("copy status of keyword recording 300001 = false, want true")
+	}
+	fcil, err := getCopyList(r, false)
+	if err != nil || len(fcil) != 0 {
+		t.Errorf("getCopyList after copy = %+v, %v, want none", fcil, err)
+	}
+}
+
+func BenchmarkGetCopyList(b *testing.B) {
+	setBenchEnv(b)
+	runUpdate(b, true)
+	saved := conf
+	b.Cleanup(func() { conf = saved })
+	conf.cFilename = "%title%_%epnum%_%eptitle%"
+	conf.cFiletype = "TS"
+	conf.cDropThresh = 15
+	r := store.Repos()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fcil, err := getCopyList(r, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(fcil) != benchTitles*benchEpisodes+benchKeywordRec {
+			b.Fatalf("%d files to copy, want %d", len(fcil), benchTitles*benchEpisodes+benchKeywordRec)
+		}
+	}
+}
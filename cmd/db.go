@@ -0,0 +1,218 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/liebe-magi/falko/db"
+	"github.com/spf13/cobra"
+)
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "ローカルDBのスキーマの管理",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "ローカルDBのスキーマを更新",
+	Run: func(cmd *cobra.Command, args []string) {
+		to, err := cmd.Flags().GetInt("to")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = migrateDB(to)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "ローカルDBのスキーマのバージョンを確認",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := showDBStatus()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var dbRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "ローカルDBのスキーマを元に戻す",
+	Run: func(cmd *cobra.Command, args []string) {
+		to, err := cmd.Flags().GetInt("to")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = rollbackDB(to, cmd.Flags().Changed("to"))
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var dbDedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "ローカルDBの重複した録画などを削除",
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = dedupeDB(dryRun)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbStatusCmd)
+	dbCmd.AddCommand(dbRollbackCmd)
+	dbCmd.AddCommand(dbDedupeCmd)
+
+	dbMigrateCmd.Flags().Int("to", 0, "更新先のバージョン (未指定時は最新)")
+	dbRollbackCmd.Flags().Int("to", 0, "戻し先のバージョン (未指定時は1つ前)")
+	dbDedupeCmd.Flags().Bool("dry-run", false, "削除せずに重複しているデータを表示")
+}
+
+// openUnmigratedStore opens the local DB without migrating it for the db commands
+func openUnmigratedStore() (*db.Store, error) {
+	return db.OpenUnmigrated(filepath.Join(filepath.Dir(configPath), db.FileName))
+}
+
+func migrateDB(to int) error {
+	s, err := openUnmigratedStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	done, err := s.Migrate(to)
+	for _, m := range done {
+		log.Printf("スキーマを更新 : %d %s", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		log.Println("スキーマは最新です")
+	}
+	return nil
+}
+
+func rollbackDB(to int, specified bool) error {
+	s, err := openUnmigratedStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if !specified {
+		cur, err := s.Version()
+		if err != nil {
+			return err
+		}
+		to = cur - 1
+	}
+	done, err := s.Rollback(to)
+	for _, m := range done {
+		log.Printf("スキーマを元に戻しました : %d %s", m.Version, m.Name)
+	}
+	return err
+}
+
+func dedupeDB(dryRun bool) error {
+	s, err := openUnmigratedStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	cur, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if cur > db.LatestVersion() {
+		return fmt.Errorf("%w (DB : %d, falko : %d)", db.ErrSchemaTooNew, cur, db.LatestVersion())
+	}
+	var dl []db.Duplicate
+	if dryRun {
+		dl, err = s.FindDuplicates()
+	} else {
+		dl, err = s.Dedupe()
+	}
+	if err != nil {
+		return err
+	}
+	if len(dl) == 0 {
+		log.Println("重複しているデータはありません")
+		return nil
+	}
+	for _, d := range dl {
+		fmt.Printf("%s %s : ID %d を残して %v を削除\n", d.Table, d.Key, d.Keep, d.Remove)
+	}
+	if dryRun {
+		log.Printf("%d件の重複が見つかりました", len(dl))
+	} else {
+		log.Printf("%d件の重複を削除しました", len(dl))
+	}
+	return nil
+}
+
+func showDBStatus() error {
+	s, err := openUnmigratedStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	cur, err := s.Version()
+	if err != nil {
+		return err
+	}
+	ml, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("スキーマのバージョン : %d (このfalkoの最新 : %d)\n", cur, db.LatestVersion())
+	if cur > db.LatestVersion() {
+		fmt.Println("ローカルDBがこのバージョンのfalkoより新しいため、falkoを更新して下さい")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATE\tAPPLIED\tNAME")
+	for _, m := range ml {
+		state := "未適用"
+		applied := "-"
+		if m.Applied {
+			state = "適用済み"
+			applied = m.AppliedAt.Local().Format("2006/01/02 15:04")
+		}
+		if m.Applied && !m.Reversible && m.Version <= db.LatestVersion() {
+			state += " (戻せません)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", m.Version, state, applied, m.Name)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,245 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
+	"github.com/liebe-magi/falko/foltia/foltiatest"
+	"github.com/liebe-magi/falko/syoboi"
+)
+
+// fakeSyoboi is a Syoboi Calendar serving the titles and programs in memory
+type fakeSyoboi struct {
+	titles   []syoboi.Title
+	programs []syoboi.Program
+	// lookups is the number of ProgLookup calls
+	lookups atomic.Int32
+}
+
+func (f *fakeSyoboi) TitleLookup(ctx context.Context, since time.Time) ([]syoboi.Title, error) {
+	var tl []syoboi.Title
+	for _, t := range f.titles {
+		if since.IsZero() || t.LastUpdate.After(since) {
+			tl = append(tl, t)
+		}
+	}
+	return tl, nil
+}
+
+func (f *fakeSyoboi) ProgLookup(ctx context.Context, tid int, chid int, from time.Time, to time.Time) ([]syoboi.Program, error) {
+	f.lookups.Add(1)
+	var pl []syoboi.Program
+	for _, p := range f.programs {
+		if p.TID == tid && p.ChID == chid && !p.StTime.Before(from) && !p.StTime.After(to) {
+			pl = append(pl, p)
+		}
+	}
+	return pl, nil
+}
+
+// tokyo is the time zone of foltia ANIME LOCKER and Syoboi Calendar
+func tokyo(tb testing.TB) *time.Location {
+	tb.Helper()
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		tb.Fatalf("LoadLocation : %v", err)
+	}
+	return loc
+}
+
+// newTestSyoboi returns a Syoboi Calendar which knows the titles of the fake foltia ANIME LOCKER
+// and the special recorded at 2020/05/30 23:00
+func newTestSyoboi(t *testing.T) *fakeSyoboi {
+	loc := tokyo(t)
+	chid, err := foltia.StationID("BS11イレブン")
+	if err != nil {
+		t.Fatalf("StationID : %v", err)
+	}
+	updated := time.Date(2020, 6, 1, 12, 0, 0, 0, loc)
+	return &fakeSyoboi{
+		titles: []syoboi.Title{
+			{TID: 1730, Title: "とある科学の超電磁砲T", Yomi: "とあるかがくのれーるがんT", FirstYear: 2020, LastUpdate: updated},
+			{TID: 5500, Title: "劇場版テスト", Yomi: "げきじょうばんてすと", FirstYear: 2019, LastUpdate: updated.Add(-time.Hour)},
+			{TID: 5800, Title: "新作テストアニメ", Yomi: "しんさくてすとあにめ", FirstYear: 2020, LastUpdate: updated.Add(-2 * time.Hour)},
+		},
+		programs: []syoboi.Program{
+			{PID: 5001, TID: 1730, ChID: chid, StTime: time.Date(2020, 5, 30, 23, 0, 0, 0, loc), Count: -1, SubTitle: "特別編"},
+		},
+	}
+}
+
+// setTestDB points the local DB of the commands to a new DB in the temporary directory
+// and restores the settings, the clients and the local DB after the test
+func setTestDB(tb testing.TB) {
+	tb.Helper()
+	savedConf, savedFC, savedSC, savedStore := conf, fc, sc, store
+	tb.Cleanup(func() {
+		if store != nil {
+			store.Close()
+		}
+		conf, fc, sc, store = savedConf, savedFC, savedSC, savedStore
+	})
+	conf.concurrency = 2
+	openTestDB(tb)
+}
+
+// openTestDB replaces the local DB of the commands by a new one in the temporary directory
+func openTestDB(tb testing.TB) {
+	tb.Helper()
+	if store != nil {
+		store.Close()
+	}
+	st, err := db.Open(filepath.Join(tb.TempDir(), db.FileName))
+	if err != nil {
+		tb.Fatalf("db.Open : %v", err)
+	}
+	store = st
+}
+
+// setTestEnv points the clients and the local DB of the commands to the fake foltia ANIME LOCKER,
+// the fake Syoboi Calendar and a new DB in the temporary directory, and restores them after the test
+func setTestEnv(t *testing.T) *foltiatest.Server {
+	t.Helper()
+	setTestDB(t)
+	s := foltiatest.NewServer()
+	t.Cleanup(s.Close)
+	fc = foltia.NewClient(s.Host(), nil, "")
+	sc = newTestSyoboi(t)
+	return s
+}
+
+// runUpdate updates the local DB like falko update and returns the plan
+func runUpdate(t testing.TB, full bool) *updatePlan {
+	t.Helper()
+	p, err := planUpdate(context.Background(), store.Repos(), full, tsRefresh{})
+	if err != nil {
+		t.Fatalf("planUpdate : %v", err)
+	}
+	if len(p.Errors) > 0 {
+		t.Fatalf("planUpdate skipped : %+v", p.Errors)
+	}
+	err = applyUpdate(store.Repos(), p)
+	if err != nil {
+		t.Fatalf("applyUpdate : %v", err)
+	}
+	return p
+}
+
+// Size of the library of the benchmarks
+const (
+	benchTitles     = 100
+	benchEpisodes   = 12
+	benchKeywordRec = 200
+)
+
+// benchFoltia is a foltia ANIME LOCKER of many titles served from memory for the benchmarks.
+// Each episode is recorded on two stations.
+type benchFoltia struct {
+	foltia.Client
+	library    []foltia.LibraryTitle
+	recordings map[int][]foltia.Recording
+	keywordRec []foltia.Recording
+}
+
+func newBenchFoltia(loc *time.Location) *benchFoltia {
+	f := &benchFoltia{recordings: map[int][]foltia.Recording{}}
+	base := time.Date(2020, 4, 1, 23, 0, 0, 0, loc)
+	for i := 0; i < benchTitles; i++ {
+		tid := 10000 + i
+		var latest time.Time
+		for ep := 1; ep <= benchEpisodes; ep++ {
+			for j, st := range []string{"NHK総合", "BSフジ"} {
+				pid := tid*100 + ep*2 + j
+				t := base.Add(time.Duration(i)*time.Hour + time.Duration(ep*7*24+j)*time.Hour)
+				f.recordings[tid] = append(f.recordings[tid], foltia.Recording{
+					TID: tid, PID: pid, EpNum: ep, EpTitle: fmt.Sprintf("第%d話", ep), Time: t, Station: st,
+					FileTS: fmt.Sprintf("%d-%d-%d.m2t", tid, ep, pid), FileMP4HD: fmt.Sprintf("MAQ-%d-%d-%d.MP4", tid, ep, pid),
+					Status: foltia.RecCompleted,
+				})
+				latest = t
+			}
+		}
+		f.library = append(f.library, foltia.LibraryTitle{TID: tid, Files: benchEpisodes * 2, Latest: latest})
+	}
+	for i := 0; i < benchKeywordRec; i++ {
+		pid := 900000 + i
+		f.keywordRec = append(f.keywordRec, foltia.Recording{
+			TID: -1, PID: pid, EpNum: -1, EpTitle: fmt.Sprintf("特番%d", i), Time: base.Add(time.Duration(i) * time.Hour), Station: "NHK総合",
+			FileTS: fmt.Sprintf("K1-%d.m2t", pid), Status: foltia.RecCompleted,
+		})
+	}
+	return f
+}
+
+func (f *benchFoltia) ListLibraryTitles(ctx context.Context) ([]foltia.LibraryTitle, error) {
+	return f.library, nil
+}
+
+func (f *benchFoltia) ListRecordings(ctx context.Context, tid int) ([]foltia.Recording, error) {
+	return f.recordings[tid], nil
+}
+
+func (f *benchFoltia) ListKeywords(ctx context.Context) ([]foltia.Keyword, error) {
+	return []foltia.Keyword{{Name: "声優", Path: "./recfiles_key.php?kid=1"}}, nil
+}
+
+func (f *benchFoltia) ListKeywordRecordings(ctx context.Context, k foltia.Keyword) ([]foltia.Recording, error) {
+	return f.keywordRec, nil
+}
+
+func (f *benchFoltia) GetCMInfo(ctx context.Context, pid int) (foltia.CMInfo, error) {
+	drop := pid % 3 * 10
+	return foltia.CMInfo{PID: pid, Drop: drop, Segments: []foltia.TSSegment{{Name: "0x0100", Total: 120000, Drop: drop}, {Name: "0x0110", Total: 4000}}}, nil
+}
+
+// setBenchEnv points the clients of the commands to the library of the benchmarks and the local DB to an empty one.
+// The logs and the progress bars of update are discarded.
+func setBenchEnv(b *testing.B) {
+	b.Helper()
+	setTestDB(b)
+	loc := tokyo(b)
+	f := newBenchFoltia(loc)
+	s := &fakeSyoboi{}
+	for _, l := range f.library {
+		s.titles = append(s.titles, syoboi.Title{TID: l.TID, Title: fmt.Sprintf("タイトル%d", l.TID), FirstYear: 2020, LastUpdate: time.Date(2020, 6, 1, 12, 0, 0, 0, loc)})
+	}
+	fc = f
+	sc = s
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	stderr := os.Stderr
+	os.Stderr = null
+	b.Cleanup(func() {
+		log.SetOutput(stderr)
+		os.Stderr = stderr
+		null.Close()
+	})
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/liebe-magi/falko/db"
+	"github.com/spf13/cobra"
+)
+
+// Labels of the events shown by history
+var historyLabels = map[string]string{
+	db.EventAppeared: "追加",
+	db.EventChanged:  "変更",
+	db.EventRemoved:  "削除",
+	db.EventRestored: "復元",
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "録画の追加・変更・削除の履歴を確認",
+	Run: func(cmd *cobra.Command, args []string) {
+		var q db.HistoryQuery
+		var err error
+		q.PID, err = cmd.Flags().GetInt("pid")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		q.TID, err = cmd.Flags().GetInt("tid")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		q.Limit, err = cmd.Flags().GetInt("limit")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		days, err := cmd.Flags().GetInt("days")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if days > 0 {
+			q.Since = time.Now().AddDate(0, 0, -days)
+		}
+		openStore()
+		err = showHistory(store, q)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().IntP("pid", "p", 0, "PIDを指定して履歴を確認")
+	historyCmd.Flags().IntP("tid", "t", 0, "TIDを指定して履歴を確認")
+	historyCmd.Flags().IntP("days", "d", 0, "表示する日数を指定 (0で全期間)")
+	historyCmd.Flags().IntP("limit", "n", 50, "表示する件数の上限 (0で無制限)")
+}
+
+func showHistory(history db.HistoryRepo, q db.HistoryQuery) error {
+	hl, err := history.GetHistory(q)
+	if err != nil {
+		return err
+	}
+	if len(hl) == 0 {
+		fmt.Println("履歴はありません")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tEVENT\tPID\tTITLE\tSTATION\tDETAIL")
+	for _, h := range hl {
+		title := fmt.Sprintf("%s(%d:%d)", h.Title, h.TID, h.EpNum)
+		if h.Kind == db.KindKeywordRecFile {
+			title = fmt.Sprintf("%s [%s]", h.Title, h.Keyword)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", h.CreatedAt.Local().Format("2006/01/02 15:04"), historyLabels[h.Event], h.PID, title, h.Station, h.Detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,247 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/liebe-magi/falko/foltia"
+	"github.com/spf13/cobra"
+)
+
+// keywordCmd represents the keyword command
+var keywordCmd = &cobra.Command{
+	Use:   "keyword",
+	Short: "キーワード録画の管理",
+}
+
+var keywordListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "キーワード録画の一覧を表示",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := showKeywordRules(cmd.Context())
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var keywordAddCmd = &cobra.Command{
+	Use:   "add [キーワード] (放送局名)",
+	Short: "キーワード録画を登録",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		k := foltia.KeywordRule{Keyword: args[0], Quality: conf.encQuality, MP2CM: conf.mp2cut, MP4CM: conf.mp4cut}
+		if len(args) == 2 {
+			sid, err := foltia.StationID(args[1])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			k.Station = sid
+		}
+		err := setKeywordFlags(cmd, &k)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = addKeywordRule(cmd.Context(), k)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var keywordEditCmd = &cobra.Command{
+	Use:   "edit [ID]",
+	Short: "キーワード録画の設定を変更",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		k, err := getKeywordRule(cmd.Context(), id)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = setKeywordFlags(cmd, &k)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = editKeywordRule(cmd.Context(), k)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var keywordRemoveCmd = &cobra.Command{
+	Use:   "remove [ID]",
+	Short: "キーワード録画を削除",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = removeKeywordRule(cmd.Context(), id)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keywordCmd)
+	keywordCmd.AddCommand(keywordListCmd)
+	keywordCmd.AddCommand(keywordAddCmd)
+	keywordCmd.AddCommand(keywordEditCmd)
+	keywordCmd.AddCommand(keywordRemoveCmd)
+
+	for _, c := range []*cobra.Command{keywordAddCmd, keywordEditCmd} {
+		c.Flags().IntP("encode-quality", "e", -1, "エンコード設定 (未指定時は設定ファイルの値)")
+		c.Flags().IntP("mp2cm_cut", "x", -1, "MPEG2編集設定 (未指定時は設定ファイルの値)")
+		c.Flags().IntP("mp4cm_cut", "y", -1, "MP4編集設定 (未指定時は設定ファイルの値)")
+	}
+	keywordEditCmd.Flags().StringP("keyword", "k", "", "キーワードを変更")
+	keywordEditCmd.Flags().StringP("station", "s", "", "放送局を変更 ([全局]で制限なし)")
+}
+
+// setKeywordFlags overwrites the rule with the flags which are set
+func setKeywordFlags(cmd *cobra.Command, k *foltia.KeywordRule) error {
+	for _, f := range []struct {
+		name string
+		v    *int
+	}{{"encode-quality", &k.Quality}, {"mp2cm_cut", &k.MP2CM}, {"mp4cm_cut", &k.MP4CM}} {
+		n, err := cmd.Flags().GetInt(f.name)
+		if err != nil {
+			return err
+		}
+		if n != -1 {
+			*f.v = n
+		}
+	}
+	if _, err := foltia.Quality(k.Quality); err != nil {
+		return err
+	}
+	if _, err := foltia.CMEdit(k.MP2CM); err != nil {
+		return err
+	}
+	if _, err := foltia.CMEdit(k.MP4CM); err != nil {
+		return err
+	}
+	if cmd.Flags().Lookup("keyword") == nil {
+		return nil
+	}
+	kw, err := cmd.Flags().GetString("keyword")
+	if err != nil {
+		return err
+	}
+	if kw != "" {
+		k.Keyword = kw
+	}
+	st, err := cmd.Flags().GetString("station")
+	if err != nil {
+		return err
+	}
+	if st != "" {
+		k.Station, err = foltia.StationID(st)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listKeywordRules gets the keyword recording rules and logs the rows which cannot be parsed
+func listKeywordRules(ctx context.Context) ([]foltia.KeywordRule, error) {
+	kl, err := fc.ListKeywordRules(ctx)
+	var errs foltia.RowErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			log.Printf("解析できないキーワード録画をスキップ : %v", e)
+		}
+	} else if err != nil {
+		return []foltia.KeywordRule{}, err
+	}
+	return kl, nil
+}
+
+func showKeywordRules(ctx context.Context) error {
+	kl, err := listKeywordRules(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println("キーワード録画一覧")
+	for _, k := range kl {
+		fmt.Println(k)
+	}
+	return nil
+}
+
+func getKeywordRule(ctx context.Context, id int) (foltia.KeywordRule, error) {
+	kl, err := listKeywordRules(ctx)
+	if err != nil {
+		return foltia.KeywordRule{}, err
+	}
+	for _, k := range kl {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return foltia.KeywordRule{}, &foltia.KeywordError{ID: id, Err: foltia.ErrUnknownKeyword}
+}
+
+func addKeywordRule(ctx context.Context, k foltia.KeywordRule) error {
+	log.Printf("キーワード登録 : %s", k.Keyword)
+	added, err := fc.AddKeywordRule(ctx, k)
+	if err != nil {
+		return err
+	}
+	log.Printf("キーワード登録完了 : %s", added)
+	return nil
+}
+
+func editKeywordRule(ctx context.Context, k foltia.KeywordRule) error {
+	log.Printf("キーワード変更 : %s", k)
+	err := fc.EditKeywordRule(ctx, k)
+	if err != nil {
+		return err
+	}
+	log.Printf("キーワード変更完了 : %s", k)
+	return nil
+}
+
+func removeKeywordRule(ctx context.Context, id int) error {
+	k, err := getKeywordRule(ctx, id)
+	if errors.Is(err, foltia.ErrUnknownKeyword) {
+		// The rule which cannot be parsed can be removed by its ID
+		k = foltia.KeywordRule{ID: id}
+	} else if err != nil {
+		return err
+	}
+	log.Printf("キーワード削除 : %s", k)
+	err = fc.RemoveKeywordRule(ctx, id)
+	if err != nil {
+		return err
+	}
+	log.Printf("キーワード削除完了 : %s", k)
+	return nil
+}
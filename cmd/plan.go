@@ -0,0 +1,421 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
+)
+
+// Actions of the changes in updatePlan
+const (
+	actionAdd        = "add"
+	actionChange     = "change"
+	actionRemove     = "remove"
+	actionActivate   = "activate"
+	actionDeactivate = "deactivate"
+	actionRestore    = "restore"
+)
+
+// Events of History DB recorded for the actions of the files
+var historyEvents = map[string]string{
+	actionAdd:     db.EventAppeared,
+	actionChange:  db.EventChanged,
+	actionRemove:  db.EventRemoved,
+	actionRestore: db.EventRestored,
+}
+
+// updatePlan is a set of changes which update applies to the local DB
+type updatePlan struct {
+	Titles           []titleChange          `json:"titles"`
+	Episodes         []episodeChange        `json:"episodes"`
+	VideoFiles       []videoFileChange      `json:"video_files"`
+	KeywordRecFiles  []keywordRecFileChange `json:"keyword_rec_files"`
+	SyoboiLastUpdate string                 `json:"syoboi_last_update,omitempty"`
+	Errors           []planError            `json:"errors"`
+
+	fingerprints      []db.TitleFingerprint
+	staleFingerprints []int
+	tsSegments        map[int][]db.TSSegment
+}
+
+// planError is an item which is skipped in the update because of the error
+type planError struct {
+	Target string `json:"target"`
+	Error  string `json:"error"`
+}
+
+type titleChange struct {
+	Action string `json:"action"`
+	TID    int    `json:"tid"`
+	Title  string `json:"title"`
+	Yomi   string `json:"yomi"`
+	Year   int    `json:"year"`
+	Active bool   `json:"active"`
+	record db.AnimeTitle
+}
+
+type episodeChange struct {
+	Action  string `json:"action"`
+	TID     int    `json:"tid"`
+	Title   string `json:"title"`
+	EpNum   int    `json:"ep_num"`
+	EpTitle string `json:"ep_title"`
+	record  db.AnimeEpisode
+}
+
+type videoFileChange struct {
+	Action    string    `json:"action"`
+	TID       int       `json:"tid"`
+	Title     string    `json:"title"`
+	EpNum     int       `json:"ep_num"`
+	EpTitle   string    `json:"ep_title"`
+	PID       int       `json:"pid"`
+	Station   string    `json:"station"`
+	Time      time.Time `json:"time"`
+	FileTS    string    `json:"file_ts"`
+	FileMP4HD string    `json:"file_mp4hd"`
+	FileMP4SD string    `json:"file_mp4sd"`
+	Drop      int       `json:"drop"`
+	Scramble  int       `json:"scramble"`
+	RecStatus string    `json:"rec_status"`
+	record    db.VideoFile
+	previous  *db.VideoFile
+}
+
+type keywordRecFileChange struct {
+	Action    string    `json:"action"`
+	Keyword   string    `json:"keyword"`
+	Title     string    `json:"title"`
+	PID       int       `json:"pid"`
+	Station   string    `json:"station"`
+	Time      time.Time `json:"time"`
+	FileTS    string    `json:"file_ts"`
+	FileMP4HD string    `json:"file_mp4hd"`
+	FileMP4SD string    `json:"file_mp4sd"`
+	Drop      int       `json:"drop"`
+	Scramble  int       `json:"scramble"`
+	RecStatus string    `json:"rec_status"`
+	record    db.KeywordRecFile
+	previous  *db.KeywordRecFile
+}
+
+func newUpdatePlan() *updatePlan {
+	return &updatePlan{
+		Titles:          []titleChange{},
+		Episodes:        []episodeChange{},
+		VideoFiles:      []videoFileChange{},
+		KeywordRecFiles: []keywordRecFileChange{},
+		Errors:          []planError{},
+		tsSegments:      map[int][]db.TSSegment{},
+	}
+}
+
+func newTitleChange(action string, t db.AnimeTitle) titleChange {
+	return titleChange{Action: action, TID: t.TID, Title: t.Title, Yomi: t.TitleYomi, Year: t.Year, Active: t.Active, record: t}
+}
+
+func newVideoFileChange(action string, title string, epTitle string, v db.VideoFile) videoFileChange {
+	return videoFileChange{Action: action, TID: v.TID, Title: title, EpNum: v.EpNum, EpTitle: epTitle, PID: v.PID, Station: v.Station, Time: v.Time, FileTS: v.FileTS, FileMP4HD: v.FileMP4HD, FileMP4SD: v.FileMP4SD, Drop: v.Drop, Scramble: v.Scramble, RecStatus: v.RecStatus, record: v}
+}
+
+func newKeywordRecFileChange(action string, k db.KeywordRecFile) keywordRecFileChange {
+	return keywordRecFileChange{Action: action, Keyword: k.Keyword, Title: k.Title, PID: k.PID, Station: k.Station, Time: k.Time, FileTS: k.FileTS, FileMP4HD: k.FileMP4HD, FileMP4SD: k.FileMP4SD, Drop: k.Drop, Scramble: k.Scramble, RecStatus: k.RecStatus, record: k}
+}
+
+// setTSSegments records the per-segment TS packet information to be saved with the recording
+func (p *updatePlan) setTSSegments(ci foltia.CMInfo) {
+	var sl []db.TSSegment
+	for _, s := range ci.Segments {
+		sl = append(sl, db.TSSegment{PID: ci.PID, Name: s.Name, Total: s.Total, Drop: s.Drop, Scramble: s.Scramble})
+	}
+	p.tsSegments[ci.PID] = sl
+}
+
+// skip records the error of the item which is skipped in the update
+func (p *updatePlan) skip(target string, err error) {
+	var re *foltia.RecordingError
+	var pe *foltia.ParseError
+	if errors.As(err, &re) {
+		log.Printf("録画情報の解析に失敗したためスキップします : %s : %s", target, err)
+	} else if errors.As(err, &pe) {
+		log.Printf("ページの解析に失敗したため今回は更新しません : %s : %s", target, err)
+	} else {
+		log.Printf("ページの取得に失敗したため今回は更新しません : %s : %s", target, err)
+	}
+	p.Errors = append(p.Errors, planError{Target: target, Error: err.Error()})
+}
+
+// printErrors writes the summary of the skipped items
+func (p *updatePlan) printErrors() {
+	log.Printf("%d件の項目でエラーが発生したためスキップしました", len(p.Errors))
+	for _, e := range p.Errors {
+		log.Printf("  %s : %s", e.Target, e.Error)
+	}
+}
+
+// count returns the number of the changes in the plan
+func (p *updatePlan) count() int {
+	return len(p.Titles) + len(p.Episodes) + len(p.VideoFiles) + len(p.KeywordRecFiles)
+}
+
+// print writes the plan to w in the format of "table" or "json"
+func (p *updatePlan) print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case "table":
+		return p.printTable(w)
+	}
+	return fmt.Errorf("出力形式が不正 : %s (table または json を指定して下さい)", format)
+}
+
+func (p *updatePlan) printTable(w io.Writer) error {
+	if p.count() == 0 {
+		_, err := fmt.Fprintln(w, "変更はありません")
+		if err != nil || len(p.Errors) == 0 {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%d件のスキップ\n", len(p.Errors))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tTYPE\tTID\tEP\tPID\tTITLE")
+	for _, t := range p.Titles {
+		fmt.Fprintf(tw, "%s\ttitle\t%d\t-\t-\t%s\n", t.Action, t.TID, t.Title)
+	}
+	for _, e := range p.Episodes {
+		fmt.Fprintf(tw, "%s\tepisode\t%d\t%d\t-\t%s (%s)\n", e.Action, e.TID, e.EpNum, e.Title, e.EpTitle)
+	}
+	for _, v := range p.VideoFiles {
+		fmt.Fprintf(tw, "%s\tvideo\t%d\t%d\t%d\t%s (%s) %s%s\n", v.Action, v.TID, v.EpNum, v.PID, v.Title, v.EpTitle, v.Station, recStatusSuffix(v.RecStatus))
+	}
+	for _, k := range p.KeywordRecFiles {
+		fmt.Fprintf(tw, "%s\tkeyword\t-\t-\t%d\t%s [%s] %s%s\n", k.Action, k.PID, k.Title, k.Keyword, k.Station, recStatusSuffix(k.RecStatus))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%d件の変更\n", p.count())
+	if err != nil {
+		return err
+	}
+	if len(p.Errors) > 0 {
+		_, err = fmt.Fprintf(w, "%d件のスキップ\n", len(p.Errors))
+	}
+	return err
+}
+
+// recStatusSuffix returns the label of the recording status shown after the title unless it is completed
+func recStatusSuffix(status string) string {
+	if status == string(foltia.RecCompleted) {
+		return ""
+	}
+	return " (" + foltia.RecStatus(status).Label() + ")"
+}
+
+// applyUpdate writes the changes in the plan to the local DB in a batch
+func applyUpdate(r db.Repos, p *updatePlan) error {
+	return r.Updater.ApplyBatch(func(tx db.Batch) error {
+		log.Println("アニメタイトルDBを更新")
+		var addedTitles, changedTitles []db.AnimeTitle
+		for _, t := range p.Titles {
+			if t.Action == actionAdd {
+				addedTitles = append(addedTitles, t.record)
+			} else {
+				changedTitles = append(changedTitles, t.record)
+			}
+		}
+		err := tx.SaveTitles(changedTitles)
+		if err != nil {
+			return err
+		}
+		err = tx.UpsertTitles(addedTitles)
+		if err != nil {
+			return err
+		}
+		if p.SyoboiLastUpdate != "" {
+			err = tx.SetSyncState(db.SyncSyoboiLastUpdate, p.SyoboiLastUpdate)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Println("エピソードDBの更新を開始")
+		var el []db.AnimeEpisode
+		for _, e := range p.Episodes {
+			el = append(el, e.record)
+		}
+		err = tx.UpsertEpisodes(el)
+		if err != nil {
+			return err
+		}
+
+		log.Println("動画ファイルDBの更新を開始")
+		var addedFiles, changedFiles []db.VideoFile
+		for _, v := range p.VideoFiles {
+			switch v.Action {
+			case actionAdd:
+				addedFiles = append(addedFiles, v.record)
+			case actionChange:
+				log.Printf("動画ファイルの情報を更新 : %s (%d:%s)", v.Title, v.EpNum, v.EpTitle)
+				changedFiles = append(changedFiles, v.record)
+			case actionRemove:
+				log.Printf("動画ファイルを削除済みに変更 : %s (%d): %d", v.Title, v.EpNum, v.PID)
+				changedFiles = append(changedFiles, v.record)
+			case actionRestore:
+				log.Printf("削除済みの動画ファイルを復元 : %s (%d:%s)", v.Title, v.EpNum, v.EpTitle)
+				changedFiles = append(changedFiles, v.record)
+			}
+		}
+		err = tx.SaveVideoFiles(changedFiles)
+		if err != nil {
+			return err
+		}
+		err = tx.UpsertVideoFiles(addedFiles)
+		if err != nil {
+			return err
+		}
+
+		log.Println("キーワード録画ファイルDBの更新を開始")
+		var addedKeys, changedKeys []db.KeywordRecFile
+		for _, k := range p.KeywordRecFiles {
+			switch k.Action {
+			case actionAdd:
+				addedKeys = append(addedKeys, k.record)
+			case actionChange:
+				log.Printf("動画ファイルの情報を更新 : %s (%d)", k.Title, k.PID)
+				changedKeys = append(changedKeys, k.record)
+			case actionRemove:
+				log.Printf("動画ファイルを削除済みに変更 : %s (%d)", k.Title, k.PID)
+				changedKeys = append(changedKeys, k.record)
+			case actionRestore:
+				log.Printf("削除済みの動画ファイルを復元 : %s (%d)", k.Title, k.PID)
+				changedKeys = append(changedKeys, k.record)
+			}
+		}
+		err = tx.SaveKeywordRecFiles(changedKeys)
+		if err != nil {
+			return err
+		}
+		err = tx.UpsertKeywordRecFiles(addedKeys)
+		if err != nil {
+			return err
+		}
+		for pid, sl := range p.tsSegments {
+			err = tx.SetTSSegments(pid, sl)
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.InsertHistoryEvents(p.history())
+		if err != nil {
+			return err
+		}
+
+		err = tx.SetFingerprints(p.fingerprints)
+		if err != nil {
+			return err
+		}
+		return tx.DeleteFingerprints(p.staleFingerprints)
+	})
+}
+
+// history returns the events of the files in the plan to be recorded in History DB
+func (p *updatePlan) history() []db.HistoryEvent {
+	var hl []db.HistoryEvent
+	for _, v := range p.VideoFiles {
+		h := db.HistoryEvent{Kind: db.KindVideoFile, Event: historyEvents[v.Action], PID: v.PID, TID: v.TID, EpNum: v.EpNum, Title: v.Title, Station: v.Station}
+		if v.previous != nil {
+			h.Detail = fileDiff(v.previous.FileTS, v.previous.FileMP4HD, v.previous.FileMP4SD, v.FileTS, v.FileMP4HD, v.FileMP4SD)
+			if v.previous.EpNum != v.EpNum {
+				h.Detail = strings.TrimSpace(fmt.Sprintf("EP: %d → %d %s", v.previous.EpNum, v.EpNum, h.Detail))
+			}
+			h.Detail = strings.TrimSpace(h.Detail + " " + tsDiff(v.previous.Drop, v.previous.Scramble, v.Drop, v.Scramble))
+			h.Detail = strings.TrimSpace(statusDiff(v.previous.RecStatus, v.RecStatus) + " " + h.Detail)
+		} else if v.RecStatus != string(foltia.RecCompleted) && v.Action != actionRemove {
+			h.Detail = foltia.RecStatus(v.RecStatus).Label()
+		} else {
+			h.Detail = fmt.Sprintf("D:%d S:%d", v.Drop, v.Scramble)
+		}
+		hl = append(hl, h)
+	}
+	for _, k := range p.KeywordRecFiles {
+		h := db.HistoryEvent{Kind: db.KindKeywordRecFile, Event: historyEvents[k.Action], PID: k.PID, Keyword: k.Keyword, Title: k.Title, Station: k.Station}
+		if k.previous != nil {
+			h.Detail = fileDiff(k.previous.FileTS, k.previous.FileMP4HD, k.previous.FileMP4SD, k.FileTS, k.FileMP4HD, k.FileMP4SD)
+			h.Detail = strings.TrimSpace(h.Detail + " " + tsDiff(k.previous.Drop, k.previous.Scramble, k.Drop, k.Scramble))
+			h.Detail = strings.TrimSpace(statusDiff(k.previous.RecStatus, k.RecStatus) + " " + h.Detail)
+		} else if k.RecStatus != string(foltia.RecCompleted) && k.Action != actionRemove {
+			h.Detail = foltia.RecStatus(k.RecStatus).Label()
+		} else {
+			h.Detail = fmt.Sprintf("D:%d S:%d", k.Drop, k.Scramble)
+		}
+		hl = append(hl, h)
+	}
+	return hl
+}
+
+// fileDiff describes the files which differ between before and after
+func fileDiff(ts, hd, sd, newTS, newHD, newSD string) string {
+	var d []string
+	for _, f := range []struct{ name, before, after string }{
+		{"TS", ts, newTS},
+		{"MP4HD", hd, newHD},
+		{"MP4SD", sd, newSD},
+	} {
+		if f.before == f.after {
+			continue
+		}
+		if f.before == "" {
+			f.before = "-"
+		}
+		if f.after == "" {
+			f.after = "-"
+		}
+		d = append(d, fmt.Sprintf("%s: %s → %s", f.name, f.before, f.after))
+	}
+	return strings.Join(d, " ")
+}
+
+// tsDiff describes the change of TS packet information
+func tsDiff(drop, scramble, newDrop, newScramble int) string {
+	if drop == newDrop && scramble == newScramble {
+		return ""
+	}
+	return fmt.Sprintf("D:%d → %d S:%d → %d", drop, newDrop, scramble, newScramble)
+}
+
+// statusDiff describes the change of the recording status
+func statusDiff(status, newStatus string) string {
+	if status == newStatus {
+		return ""
+	}
+	return fmt.Sprintf("状態: %s → %s", foltia.RecStatus(status).Label(), foltia.RecStatus(newStatus).Label())
+}
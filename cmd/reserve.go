@@ -16,12 +16,15 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
 	"github.com/spf13/cobra"
 )
 
@@ -36,17 +39,10 @@ type reservedProg struct {
 }
 type resevedProgList []reservedProg
 
-type station struct {
-	Name   string
-	ID     int
-	StType int
-}
-type stationList []station
-
 func (r reservedProg) String() string {
 	t := strconv.Itoa(r.TID)
 	text := t + " : " + r.Title
-	s, _ := getStation(r.Station)
+	s, _ := foltia.StationName(r.Station)
 	text += " " + s + " "
 	text += fmt.Sprintf("[%d, %d, %d]", r.Quality, r.MP2CM, r.MP4CM)
 	return text
@@ -54,7 +50,10 @@ func (r reservedProg) String() string {
 
 // reserveCmd represents the reserve command
 var reserveCmd = &cobra.Command{
-	Use:   "reserve [TID] (放送局名)",
+	Use: "reserve [TID] (放送局名)",
+	Example: `  falko reserve 1730
+  falko reserve 1730 TOKYO\ MX
+  falko reserve --program "2020/06/07 21:00" テレビ東京`,
 	Short: "TID指定による録画予約",
 	Run: func(cmd *cobra.Command, args []string) {
 		list, err := cmd.Flags().GetBool("list")
@@ -65,20 +64,34 @@ var reserveCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalln(err)
 		}
+		program, err := cmd.Flags().GetString("program")
+		if err != nil {
+			log.Fatalln(err)
+		}
 		if list && remove {
 			log.Fatalln(fmt.Errorf("2つのフラグを同時に指定することはできません"))
+		} else if program != "" {
+			if list || remove {
+				log.Fatalln(fmt.Errorf("--programは他のフラグと同時に指定することはできません"))
+			}
+			err = reserveProgramProc(cmd.Context(), program, args)
+			if err != nil {
+				log.Fatalln(err)
+			}
 		} else if list && !remove {
-			err = showReservedList()
+			err = showReservedList(cmd.Context())
 			if err != nil {
 				log.Println(err)
 			}
 		} else if !list && remove {
-			err = dereserveProc(args)
+			openStore()
+			err = dereserveProc(cmd.Context(), store, args)
 			if err != nil {
 				log.Fatalln(err)
 			}
 		} else {
-			err = reserveProc(args)
+			openStore()
+			err = reserveProc(cmd.Context(), store, args)
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -91,56 +104,112 @@ func init() {
 
 	reserveCmd.Flags().BoolP("list", "l", false, "録画予約している番組を表示")
 	reserveCmd.Flags().BoolP("remove", "r", false, "予約の取消")
+	reserveCmd.Flags().StringP("program", "p", "", "番組単位の予約 (開始時刻で指定し、引数に放送局名を指定)")
 }
 
-func reserveProc(args []string) error {
+func reserveProc(ctx context.Context, titles db.TitleRepo, args []string) error {
 	if len(args) == 1 {
 		tid, err := strconv.Atoi(args[0])
 		if err != nil {
 			return err
 		}
-		err = reserve(tid, 0, conf.encQuality, conf.mp2cut, conf.mp4cut)
+		return reserve(ctx, titles, tid, 0, conf.encQuality, conf.mp2cut, conf.mp4cut)
 	} else if len(args) == 2 {
 		tid, err := strconv.Atoi(args[0])
 		if err != nil {
 			return err
 		}
-		sid, err := getStationID(args[1])
+		sid, err := foltia.StationID(args[1])
 		if err != nil {
 			return err
 		}
-		err = reserve(tid, sid, conf.encQuality, conf.mp2cut, conf.mp4cut)
-	} else {
-		return fmt.Errorf("引数の値が不正です")
+		return reserve(ctx, titles, tid, sid, conf.encQuality, conf.mp2cut, conf.mp4cut)
+	}
+	return fmt.Errorf("引数の値が不正です")
+}
+
+func reserveProgramProc(ctx context.Context, program string, args []string) error {
+	p, others, err := findProgram(ctx, program, args)
+	if err != nil {
+		return err
+	}
+	log.Printf("番組予約実行 : %s(%d) %s %s", p.Title, p.TID, p.Station, p.Time.Format("2006/01/02 15:04"))
+	for _, o := range others {
+		log.Printf("同じ放送局の他の放送も予約されます : %s(%d) %s %s", o.Title, o.TID, o.Station, o.Time.Format("2006/01/02 15:04"))
 	}
+	err = fc.ReserveProgram(ctx, p, foltia.ReserveOptions{Quality: conf.encQuality, MP2CM: conf.mp2cut, MP4CM: conf.mp4cut})
+	if err != nil {
+		return err
+	}
+	log.Printf("番組予約完了 : %s(%d) %s %s", p.Title, p.TID, p.Station, p.Time.Format("2006/01/02 15:04"))
 	return nil
 }
 
-func dereserveProc(args []string) error {
+// findProgram finds the upcoming program by start time and station name in the program guide.
+// The other upcoming broadcasts of the title on the station are also returned since foltia ANIME LOCKER reserves them together.
+func findProgram(ctx context.Context, program string, args []string) (foltia.Program, []foltia.Program, error) {
+	if len(args) != 1 {
+		return foltia.Program{}, nil, fmt.Errorf("開始時刻と放送局名を1つ指定してください")
+	}
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return foltia.Program{}, nil, err
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04", program, loc)
+	if err != nil {
+		return foltia.Program{}, nil, fmt.Errorf("開始時刻の形式が不正です (例: 2020/06/07 21:00) : %s", program)
+	}
+	station := args[0]
+	if _, err := foltia.StationID(station); err != nil {
+		return foltia.Program{}, nil, err
+	}
+	bl, err := fc.ListBroadcasts(ctx)
+	var errs foltia.RowErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			log.Printf("解析できない番組をスキップ : %v", e)
+		}
+	} else if err != nil {
+		return foltia.Program{}, nil, err
+	}
+	for _, b := range bl {
+		if b.Station != station || !b.Time.Equal(t) {
+			continue
+		}
+		var others []foltia.Program
+		for _, o := range bl {
+			if o.TID == b.TID && o.Station == station && !o.Time.Equal(t) {
+				others = append(others, o)
+			}
+		}
+		return b, others, nil
+	}
+	return foltia.Program{}, nil, fmt.Errorf("%w : %s %s", foltia.ErrUnknownProgram, program, station)
+}
+
+func dereserveProc(ctx context.Context, titles db.TitleRepo, args []string) error {
 	if len(args) == 1 {
 		tid, err := strconv.Atoi(args[0])
 		if err != nil {
 			return err
 		}
-		err = dereserve(tid, 0)
+		return dereserve(ctx, titles, tid, 0)
 	} else if len(args) == 2 {
 		tid, err := strconv.Atoi(args[0])
 		if err != nil {
 			return err
 		}
-		sid, err := getStationID(args[1])
+		sid, err := foltia.StationID(args[1])
 		if err != nil {
 			return err
 		}
-		err = dereserve(tid, sid)
-	} else {
-		return fmt.Errorf("引数の値が不正です")
+		return dereserve(ctx, titles, tid, sid)
 	}
-	return nil
+	return fmt.Errorf("引数の値が不正です")
 }
 
-func showReservedList() error {
-	rpl, err := getReservedList()
+func showReservedList(ctx context.Context) error {
+	rpl, err := getReservedList(ctx)
 	if err != nil {
 		return err
 	}
@@ -151,208 +220,55 @@ func showReservedList() error {
 	return nil
 }
 
-func getReservedList() (resevedProgList, error) {
-	url := "http://" + conf.fHost + "/setup/listreserve.php"
-	doc, err := goquery.NewDocument(url)
-	if err != nil {
+func getReservedList(ctx context.Context) (resevedProgList, error) {
+	rl, err := fc.ListReservations(ctx)
+	var errs foltia.RowErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			log.Printf("解析できない予約をスキップ : %v", e)
+		}
+	} else if err != nil {
 		return resevedProgList{}, err
 	}
 	var rpl resevedProgList
-	doc.Find("#setUpTable > table > tbody > tr").Each(func(i int, s *goquery.Selection) {
-		var rp reservedProg
-		rp.TID, err = strconv.Atoi(s.Find("td:nth-child(2) > a").Text())
-		if err != nil {
-			log.Fatalln(err)
-		}
-		rp.Station, err = getStationID(strings.TrimSpace(s.Find("td:nth-child(3)").Text()))
-		if err != nil {
-			log.Fatalln(err)
-		}
-		rp.Title = strings.TrimSpace(s.Find("td:nth-child(4) > a").Text())
-		rp.Quality, err = getQualityNum(strings.TrimSpace(s.Find("td:nth-child(5)").Text()))
-		if err != nil {
-			log.Fatalln(err)
-		}
-		rp.MP2CM, err = getCMEditNum(strings.TrimSpace(s.Find("td:nth-child(6)").Text()))
-		if err != nil {
-			log.Fatalln(err)
-		}
-		rp.MP4CM, err = getCMEditNum(strings.TrimSpace(s.Find("td:nth-child(7)").Text()))
-		if err != nil {
-			log.Fatalln(err)
-		}
-		pnum, err := strconv.Atoi(strings.TrimSpace(s.Find("td:nth-child(8)").Text()))
-		if err != nil {
-			log.Fatalln(err)
-		}
-		if pnum != 0 {
-			rp.Now = true
-		} else {
-			rp.Now = false
-		}
-		rpl = append(rpl, rp)
-	})
-	return rpl, nil
-}
-
-func getStationList() stationList {
-	var sl stationList
-	sl = append(sl, station{Name: "NHK総合", ID: 1, StType: 0})
-	sl = append(sl, station{Name: "NHK Eテレ", ID: 2, StType: 0})
-	sl = append(sl, station{Name: "フジテレビ", ID: 3, StType: 0})
-	sl = append(sl, station{Name: "日本テレビ", ID: 4, StType: 0})
-	sl = append(sl, station{Name: "TBS", ID: 5, StType: 0})
-	sl = append(sl, station{Name: "テレビ朝日", ID: 6, StType: 0})
-	sl = append(sl, station{Name: "テレビ東京", ID: 7, StType: 0})
-	sl = append(sl, station{Name: "tvk", ID: 8, StType: 0})
-	sl = append(sl, station{Name: "NHK-BS1", ID: 9, StType: 0})
-	sl = append(sl, station{Name: "NHK-BS2", ID: 10, StType: 0})
-	sl = append(sl, station{Name: "チバテレビ", ID: 13, StType: 0})
-	sl = append(sl, station{Name: "テレ玉", ID: 14, StType: 0})
-	sl = append(sl, station{Name: "BSテレ東", ID: 15, StType: 1})
-	sl = append(sl, station{Name: "BS-TBS", ID: 16, StType: 1})
-	sl = append(sl, station{Name: "BSフジ", ID: 17, StType: 1})
-	sl = append(sl, station{Name: "BS朝日", ID: 18, StType: 1})
-	sl = append(sl, station{Name: "TOKYO MX", ID: 19, StType: 0})
-	sl = append(sl, station{Name: "BS日テレ", ID: 71, StType: 1})
-	sl = append(sl, station{Name: "BS11イレブン", ID: 128, StType: 1})
-	sl = append(sl, station{Name: "BS12トゥエルビ", ID: 129, StType: 1})
-	sl = append(sl, station{Name: "NHK BSプレミアム", ID: 179, StType: 1})
-	sl = append(sl, station{Name: "TOKYO MX2", ID: 187, StType: 0})
-	sl = append(sl, station{Name: "BSJapanext", ID: 273, StType: 1})
-	return sl
-}
-
-func getStation(id int) (string, error) {
-	sl := getStationList()
-	if id == 0 {
-		return "[全局]", nil
-	}
-	for _, s := range sl {
-		if id == s.ID {
-			return s.Name, nil
-		}
-	}
-	return "", fmt.Errorf("放送局IDが定義されていません : %d", id)
-}
-
-func getStationID(st string) (int, error) {
-	sl := getStationList()
-	if st == "[全局]" {
-		return 0, nil
-	}
-	for _, s := range sl {
-		if st == s.Name {
-			return s.ID, nil
-		}
-	}
-	return 0, fmt.Errorf("放送局名が定義されていません : %s", st)
-}
-
-func getQualityNum(s string) (int, error) {
-	if s == "変換しない" {
-		return 0, nil
-	}
-	if s == "SDのみ" {
-		return 1, nil
-	}
-	if s == "HDのみ" {
-		return 2, nil
-	}
-	if s == "SD+HD" {
-		return 3, nil
-	}
-	return 0, fmt.Errorf("変換品質が定義されていません : %s", s)
-}
-
-func getQuality(v int) (string, error) {
-	if v == 0 {
-		return "変換しない", nil
-	}
-	if v == 1 {
-		return "SDのみ", nil
-	}
-	if v == 2 {
-		return "HDのみ", nil
+	for _, r := range rl {
+		rpl = append(rpl, reservedProg{TID: r.TID, Title: r.Title, Station: r.Station, Quality: r.Quality, MP2CM: r.MP2CM, MP4CM: r.MP4CM, Now: r.Now})
 	}
-	if v == 3 {
-		return "SD+HD", nil
-	}
-	return "", fmt.Errorf("変換品質の値が定義されていません : %d", v)
-}
-
-func getCMEditNum(s string) (int, error) {
-	if s == "編集しない" {
-		return 0, nil
-	}
-	if s == "本編のみ(CMカット)" {
-		return 1, nil
-	}
-	if s == "CMのみ(本編カット)" {
-		return 2, nil
-	}
-	if s == "本編+CM(同尺並び替え)" {
-		return 3, nil
-	}
-	if s == "チャプタ追加" {
-		return 4, nil
-	}
-	return 0, fmt.Errorf("CMカットルールが定義されていません : %s", s)
-}
-
-func getCMEdit(v int) (string, error) {
-	if v == 0 {
-		return "編集しない", nil
-	}
-	if v == 1 {
-		return "本編のみ(CMカット)", nil
-	}
-	if v == 2 {
-		return "CMのみ(本編カット)", nil
-	}
-	if v == 3 {
-		return "本編+CM(同尺並び替え)", nil
-	}
-	if v == 4 {
-		return "チャプタ追加", nil
-	}
-
-	return "", fmt.Errorf("CMカットルールの値が定義されていません : %d", v)
+	return rpl, nil
 }
 
-func reserve(tid int, station int, quality int, mp2cm int, mp4cm int) error {
-	title, err := getTitle(tid)
+func reserve(ctx context.Context, titles db.TitleRepo, tid int, station int, quality int, mp2cm int, mp4cm int) error {
+	title, err := getTitle(titles, tid)
 	if err != nil {
 		return err
 	}
-	s, err := getStation(station)
+	s, err := foltia.StationName(station)
 	if err != nil {
 		return err
 	}
 	log.Printf("予約実行 : %s(%d) %s", title, tid, s)
-	url := "http://" + conf.fHost + "/reservation/reservecomp.php"
-	url += fmt.Sprintf("?station=%d", station)
-	url += fmt.Sprintf("&transcodequality=%d", quality)
-	url += fmt.Sprintf("&cmeditrulempeg2=%d", mp2cm)
-	url += fmt.Sprintf("&cmeditrulemp4=%d", mp4cm)
-	url += fmt.Sprintf("&usedigital=1&tid=%d", tid)
-	_, err = goquery.NewDocument(url)
-	return err
+	err = fc.Reserve(ctx, tid, foltia.ReserveOptions{Station: station, Quality: quality, MP2CM: mp2cm, MP4CM: mp4cm})
+	if err != nil {
+		return err
+	}
+	log.Printf("予約完了 : %s(%d) %s", title, tid, s)
+	return nil
 }
 
-func dereserve(tid int, station int) error {
-	title, err := getTitle(tid)
+func dereserve(ctx context.Context, titles db.TitleRepo, tid int, station int) error {
+	title, err := getTitle(titles, tid)
 	if err != nil {
 		return err
 	}
-	s, err := getStation(station)
+	s, err := foltia.StationName(station)
 	if err != nil {
 		return err
 	}
 	log.Printf("予約取消 : %s(%d) %s", title, tid, s)
-	url := "http://" + conf.fHost + "/reservation/delreserve.php"
-	url += fmt.Sprintf("?sid=%d", station)
-	url += fmt.Sprintf("&delflag=1&tid=%d", tid)
-	_, err = goquery.NewDocument(url)
-	return err
+	err = fc.Dereserve(ctx, tid, station)
+	if err != nil {
+		return err
+	}
+	log.Printf("予約取消完了 : %s(%d) %s", title, tid, s)
+	return nil
 }
@@ -0,0 +1,139 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/liebe-magi/falko/foltia"
+)
+
+func TestReserve(t *testing.T) {
+	s := setTestEnv(t)
+	runUpdate(t, false)
+	ctx := context.Background()
+
+	err := reserve(ctx, store, 5500, 7, 2, 0, 1)
+	if err != nil {
+		t.Fatalf("reserve : %v", err)
+	}
+	calls := s.Calls()
+	if len(calls) != 1 || calls[0].Path != "/reservation/reservecomp.php" || calls[0].Query.Get("tid") != "5500" || calls[0].Query.Get("station") != "7" {
+		t.Errorf("calls = %+v", calls)
+	}
+	rpl, err := getReservedList(ctx)
+	if err != nil {
+		t.Fatalf("getReservedList : %v", err)
+	}
+	want := reservedProg{TID: 5500, Title: "劇場版テスト", Station: 7, Quality: 2, MP2CM: 0, MP4CM: 1}
+	found := false
+	for _, r := range rpl {
+		if r.TID == 5500 {
+			found = true
+			if r != want {
+				t.Errorf("reservation of 5500 = %+v, want %+v", r, want)
+			}
+		}
+	}
+	if !found || len(rpl) != 2 {
+		t.Errorf("reservations = %+v, want 1730 and 5500", rpl)
+	}
+
+	err = reserve(ctx, store, 5500, 7, 2, 0, 1)
+	if !errors.Is(err, foltia.ErrAlreadyReserved) {
+		t.Errorf("reserve of the reserved title = %v, want ErrAlreadyReserved", err)
+	}
+	err = reserve(ctx, store, 9999, 0, 2, 0, 0)
+	if err == nil {
+		t.Errorf("reserve of the title not in the local DB succeeded")
+	}
+	if n := len(s.Calls()); n != 1 {
+		t.Errorf("%d calls, want 1 since neither the reserved title nor the unknown title is sent", n)
+	}
+
+	err = dereserve(ctx, store, 1730, 0)
+	if err != nil {
+		t.Fatalf("dereserve : %v", err)
+	}
+	for _, r := range s.Reservations() {
+		if r.TID == 1730 {
+			t.Errorf("1730 is still reserved : %+v", r)
+		}
+	}
+	last := s.Calls()[len(s.Calls())-1]
+	if last.Path != "/reservation/delreserve.php" || last.Query.Get("tid") != "1730" {
+		t.Errorf("last call = %+v", last)
+	}
+}
+
+func TestReserveProgram(t *testing.T) {
+	s := setTestEnv(t)
+	ctx := context.Background()
+
+	err := reserveProgramProc(ctx, "2020/06/07 21:00", []string{"テレビ東京"})
+	if err != nil {
+		t.Fatalf("reserveProgramProc : %v", err)
+	}
+	calls := s.Calls()
+	if len(calls) != 1 || calls[0].Path != "/reservation/reservecomp.php" || calls[0].Query.Get("tid") != "5500" || calls[0].Query.Get("station") != "7" {
+		t.Errorf("calls = %+v", calls)
+	}
+	rl, err := getReservationList(ctx)
+	if err != nil {
+		t.Fatalf("getReservationList : %v", err)
+	}
+	want := time.Date(2020, 6, 7, 21, 0, 0, 0, tokyo(t))
+	if len(rl) != 2 || rl[1].TID != 5500 || rl[1].Station != "テレビ東京" || !rl[1].Time.Equal(want) {
+		t.Errorf("reserved programs = %+v", rl)
+	}
+
+	err = reserveProgramProc(ctx, "2020/06/06 23:00", []string{"BS11イレブン"})
+	if !errors.Is(err, foltia.ErrAlreadyReserved) {
+		t.Errorf("reserveProgramProc of the reserved program = %v, want ErrAlreadyReserved", err)
+	}
+	err = reserveProgramProc(ctx, "2020/06/08 21:00", []string{"テレビ東京"})
+	if !errors.Is(err, foltia.ErrUnknownProgram) {
+		t.Errorf("reserveProgramProc of the unknown program = %v, want ErrUnknownProgram", err)
+	}
+	if n := len(s.Calls()); n != 1 {
+		t.Errorf("%d calls, want 1 since neither the reserved program nor the unknown program is sent", n)
+	}
+}
+
+func TestFindProgram(t *testing.T) {
+	setTestEnv(t)
+	ctx := context.Background()
+
+	p, others, err := findProgram(ctx, "2020/06/07 21:00", []string{"テレビ東京"})
+	if err != nil {
+		t.Fatalf("findProgram : %v", err)
+	}
+	if p.TID != 5500 || p.Title != "劇場版テスト" || p.EpNum != -1 || len(others) != 0 {
+		t.Errorf("findProgram = %+v, %+v", p, others)
+	}
+	_, _, err = findProgram(ctx, "2020/06/07 21:00", nil)
+	if err == nil {
+		t.Errorf("findProgram without the station succeeded")
+	}
+	_, _, err = findProgram(ctx, "500001", []string{"テレビ東京"})
+	if err == nil {
+		t.Errorf("findProgram by PID succeeded")
+	}
+}
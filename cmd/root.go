@@ -17,11 +17,19 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/fetch"
+	"github.com/liebe-magi/falko/foltia"
+	"github.com/liebe-magi/falko/syoboi"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -29,6 +37,11 @@ import (
 
 type config struct {
 	fHost       string
+	fUser       string
+	fPassword   string
+	fCAFile     string
+	fInsecure   bool
+	fProfile    string
 	fPath       string
 	cDest       string
 	cFilename   string
@@ -41,11 +54,20 @@ type config struct {
 	sTime       string
 	sUser       string
 	sChannel    string
+	httpTimeout int
+	httpRetry   int
+	concurrency int
+	rps         float64
 }
 
 func (c config) String() string {
-	return fmt.Sprintf("foltia_host = \"%s\"\nfoltia_path = \"%s\"\ncopy_dest = \"%s\"\ncopy_filename = \"%s\"\ncopy_filetype = \"%s\"\ncopy_drop_thresh = %d\nencode_quality = %d\nmp2cm_cut = %d\nmp4cm_cut = %d\nslack_token = \"%s\"\nslack_time = \"%s\"\nslack_user = \"%s\"\nslack_channel = \"%s\"",
+	return fmt.Sprintf("foltia_host = \"%s\"\nfoltia_user = \"%s\"\nfoltia_password = \"%s\"\nfoltia_ca_file = \"%s\"\nfoltia_insecure = %t\nfoltia_profile = \"%s\"\nfoltia_path = \"%s\"\ncopy_dest = \"%s\"\ncopy_filename = \"%s\"\ncopy_filetype = \"%s\"\ncopy_drop_thresh = %d\nencode_quality = %d\nmp2cm_cut = %d\nmp4cm_cut = %d\nslack_token = \"%s\"\nslack_time = \"%s\"\nslack_user = \"%s\"\nslack_channel = \"%s\"\nhttp_timeout = %d\nhttp_retry = %d\nupdate_concurrency = %d\nfoltia_rps = %g",
 		c.fHost,
+		c.fUser,
+		c.fPassword,
+		c.fCAFile,
+		c.fInsecure,
+		c.fProfile,
 		c.fPath,
 		c.cDest,
 		c.cFilename,
@@ -58,12 +80,25 @@ func (c config) String() string {
 		c.sTime,
 		c.sUser,
 		c.sChannel,
+		c.httpTimeout,
+		c.httpRetry,
+		c.concurrency,
+		c.rps,
 	)
 }
 
 var (
-	conf       config
-	configPath string
+	conf          config
+	configPath    string
+	httpc         *fetch.Client
+	fc            foltia.Client
+	sc            syoboi.Client
+	fhttpc        *fetch.Client
+	store         *db.Store
+	globalTimeout time.Duration
+	cancelTimeout context.CancelFunc = func() {}
+	// exitCode is the exit status set by the command, returned after PersistentPostRun has cleaned up
+	exitCode int
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -71,19 +106,40 @@ var rootCmd = &cobra.Command{
 	Use:   "falko",
 	Short: "FALKO for foltia ANIME LOCKER",
 	Long:  "foltia ANIME LOCKERコマンドラインツール by りーべ",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if globalTimeout > 0 {
+			var ctx context.Context
+			ctx, cancelTimeout = context.WithTimeout(cmd.Context(), globalTimeout)
+			cmd.SetContext(ctx)
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cancelTimeout()
+		if store != nil {
+			store.Close()
+		}
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if exitCode != 0 {
+		stop()
+		os.Exit(exitCode)
+	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+
+	rootCmd.PersistentFlags().DurationVar(&globalTimeout, "timeout", 0, "コマンド全体のタイムアウト (例: 10m)")
 }
 
 func initConfig() {
@@ -103,6 +159,10 @@ func initConfig() {
 	viper.AddConfigPath(configPath)
 	viper.SetConfigName("config.toml")
 	viper.SetConfigType("toml")
+	viper.SetDefault("http_timeout", int(fetch.DefaultTimeout/time.Second))
+	viper.SetDefault("http_retry", fetch.DefaultRetry.Attempts)
+	viper.SetDefault("update_concurrency", 4)
+	viper.SetDefault("foltia_rps", 0)
 
 	configPath = filepath.Join(configPath, "config.toml")
 
@@ -112,16 +172,68 @@ func initConfig() {
 	}
 
 	conf.fHost = viper.GetString("foltia_host")
+	conf.fUser = viper.GetString("foltia_user")
+	conf.fPassword = viper.GetString("foltia_password")
+	conf.fCAFile = viper.GetString("foltia_ca_file")
+	conf.fInsecure = viper.GetBool("foltia_insecure")
+	conf.fProfile = viper.GetString("foltia_profile")
 	conf.fPath = viper.GetString("foltia_path")
 	conf.cDest = viper.GetString("copy_dest")
 	conf.cFilename = viper.GetString("copy_filename")
 	conf.cFiletype = viper.GetString("copy_filetype")
 	conf.cDropThresh = viper.GetInt("copy_drop_thresh")
 	conf.encQuality = viper.GetInt("encode_quality")
-	conf.encQuality = viper.GetInt("mp2cm_cut")
-	conf.encQuality = viper.GetInt("mp4cm_cut")
+	conf.mp2cut = viper.GetInt("mp2cm_cut")
+	conf.mp4cut = viper.GetInt("mp4cm_cut")
 	conf.sToken = viper.GetString("slack_token")
 	conf.sTime = viper.GetString("slack_time")
 	conf.sUser = viper.GetString("slack_user")
 	conf.sChannel = viper.GetString("slack_channel")
+	conf.httpTimeout = viper.GetInt("http_timeout")
+	conf.httpRetry = viper.GetInt("http_retry")
+	conf.concurrency = viper.GetInt("update_concurrency")
+	conf.rps = viper.GetFloat64("foltia_rps")
+
+	httpc = fetch.New(time.Duration(conf.httpTimeout) * time.Second)
+	httpc.Retry.Attempts = conf.httpRetry
+	sc = newSyoboiClient(false, false)
+
+	fhttp, err := fetch.NewTLS(time.Duration(conf.httpTimeout)*time.Second, conf.fCAFile, conf.fInsecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fhttp.Retry.Attempts = conf.httpRetry
+	fhttp.Limiter = fetch.NewLimiter(conf.rps)
+	fhttp.Username = conf.fUser
+	fhttp.Password = conf.fPassword
+	fc = foltia.NewClient(conf.fHost, fhttp, conf.fProfile)
+	fhttpc = fhttp
+}
+
+// openStore opens the local DB in the config directory for the commands which use it
+func openStore() {
+	s, err := db.Open(filepath.Join(filepath.Dir(configPath), db.FileName))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	store = s
+}
+
+// openReadOnlyStore opens the local DB without writing anything for the commands which only read it
+func openReadOnlyStore() {
+	s, err := db.OpenReadOnly(filepath.Join(filepath.Dir(configPath), db.FileName))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	store = s
+}
+
+// newSyoboiClient creates a Syoboi client which caches the responses in the config directory.
+// If readOnly is true, the cache is used but not written.
+func newSyoboiClient(offline bool, readOnly bool) syoboi.Client {
+	return syoboi.NewClient(httpc, syoboi.Options{
+		CacheDir: filepath.Join(filepath.Dir(configPath), "syoboi_cache"),
+		Offline:  offline,
+		ReadOnly: readOnly,
+	})
 }
@@ -16,6 +16,8 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -24,6 +26,7 @@ import (
 	"time"
 
 	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
 	"github.com/slack-go/slack"
 	"github.com/spf13/cobra"
 )
@@ -33,7 +36,8 @@ var slackCmd = &cobra.Command{
 	Use:   "slack",
 	Short: "Slack botを起動",
 	Run: func(cmd *cobra.Command, args []string) {
-		runBot()
+		openStore()
+		runBot(cmd.Context())
 	},
 }
 
@@ -41,7 +45,7 @@ func init() {
 	rootCmd.AddCommand(slackCmd)
 }
 
-func runBot() {
+func runBot(ctx context.Context) {
 	log.Println("Slackクライアントを起動")
 
 	api := slack.New(
@@ -56,19 +60,32 @@ func runBot() {
 	time.Sleep(1 * time.Second)
 
 	if conf.sUser == "" || conf.sChannel == "" {
-		activateSlack(rtm)
+		if activateSlack(ctx, rtm) != nil {
+			rtm.Disconnect()
+			return
+		}
 	}
 
-	go notifyTask(rtm)
+	go notifyTask(ctx, rtm)
 
 	log.Println("Slackクライアントスタンバイ完了")
-	for msg := range rtm.IncomingEvents {
-		switch ev := msg.Data.(type) {
-		case *slack.MessageEvent:
-			if ev.User == conf.sUser && ev.Channel == conf.sChannel {
-				log.Printf("【受信】%s", ev.Msg.Text)
-				text := processMsg(ev.Msg.Text)
-				sendMsg(rtm, text)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Slackクライアントを終了")
+			rtm.Disconnect()
+			return
+		case msg, ok := <-rtm.IncomingEvents:
+			if !ok {
+				return
+			}
+			switch ev := msg.Data.(type) {
+			case *slack.MessageEvent:
+				if ev.User == conf.sUser && ev.Channel == conf.sChannel {
+					log.Printf("【受信】%s", ev.Msg.Text)
+					text := processMsg(ctx, ev.Msg.Text)
+					sendMsg(rtm, text)
+				}
 			}
 		}
 	}
@@ -79,7 +96,7 @@ func sendMsg(rtm *slack.RTM, text string) {
 	rtm.SendMessage(rtm.NewOutgoingMessage(text, conf.sChannel))
 }
 
-func activateSlack(rtm *slack.RTM) error {
+func activateSlack(ctx context.Context, rtm *slack.RTM) error {
 	log.Println("Slackクライアントの初期設定を開始")
 	rand.Seed(time.Now().UnixNano())
 	r := 0
@@ -90,31 +107,34 @@ func activateSlack(rtm *slack.RTM) error {
 		}
 	}
 	log.Printf("Slackよりこのコードを入力して下さい:%d", r)
-	for msg := range rtm.IncomingEvents {
-		switch ev := msg.Data.(type) {
-		case *slack.MessageEvent:
-			num, err := strconv.Atoi(ev.Msg.Text)
-			if err != nil {
-				continue
-			}
-			if num == r {
-				log.Printf("認証完了 User:%s Channel:%s", ev.Channel, ev.User)
-				conf.sUser = ev.User
-				conf.sChannel = ev.Channel
-				writeConfig()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-rtm.IncomingEvents:
+			if !ok {
 				return nil
 			}
+			switch ev := msg.Data.(type) {
+			case *slack.MessageEvent:
+				num, err := strconv.Atoi(ev.Msg.Text)
+				if err != nil {
+					continue
+				}
+				if num == r {
+					log.Printf("認証完了 User:%s Channel:%s", ev.Channel, ev.User)
+					conf.sUser = ev.User
+					conf.sChannel = ev.Channel
+					writeConfig()
+					return nil
+				}
+			}
 		}
 	}
-	return nil
 }
 
-func notifyTask(rtm *slack.RTM) {
+func notifyTask(ctx context.Context, rtm *slack.RTM) {
 	loc, err := time.LoadLocation("Asia/Tokyo")
-	err = db.InitNewAnimeDB()
-	if err != nil {
-		log.Fatalln(err)
-	}
 	h, m, err := getSlackTime()
 	if err != nil {
 		log.Fatalln(err)
@@ -125,23 +145,32 @@ func notifyTask(rtm *slack.RTM) {
 		e := time.Date(t.Year(), t.Month(), t.Day(), h, m+1, 0, 0, loc)
 		if t.After(s) && t.Before(e) {
 			//予約の通知
-			err = notifyReservation(rtm)
-			if err != nil {
+			err = notifyReservation(ctx, rtm)
+			if err != nil && ctx.Err() == nil {
 				log.Fatalln(err)
 			}
 			//新アニメの通知
-			err = notifyNewAnime(rtm)
+			err = notifyNewAnime(ctx, rtm, store)
+			if err != nil && ctx.Err() == nil {
+				log.Fatalln(err)
+			}
+			//録画状況の通知
+			err = notifyRecStatus(rtm, store.Repos())
 			if err != nil {
 				log.Fatalln(err)
 			}
 		}
-		time.Sleep(1 * time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(1 * time.Minute):
+		}
 	}
 }
 
-func notifyReservation(rtm *slack.RTM) error {
+func notifyReservation(ctx context.Context, rtm *slack.RTM) error {
 	log.Println("録画予定の通知開始")
-	rl, err := getReservationList()
+	rl, err := getReservationList(ctx)
 	if err != nil {
 		return err
 	}
@@ -155,13 +184,38 @@ func notifyReservation(rtm *slack.RTM) error {
 	return nil
 }
 
-func notifyNewAnime(rtm *slack.RTM) error {
+func notifyRecStatus(rtm *slack.RTM, r db.Repos) error {
+	log.Println("録画状況の通知開始")
+	rsil, err := getRecStatusList(r)
+	if err != nil {
+		return err
+	}
+	if len(rsil) == 0 {
+		return nil
+	}
+	text := "【録画状況】\n"
+	for _, r := range rsil {
+		text += fmt.Sprintf("[%s] %s [%s]\n", r.Status.Label(), r.Time.Format("1/2 15:04"), r.Station)
+		if r.TID != -1 {
+			text += fmt.Sprintf("    %s(%d)\n", r.Title, r.EpNum)
+		} else {
+			text += fmt.Sprintf("    %s\n", r.Title)
+		}
+	}
+	sendMsg(rtm, text)
+	return nil
+}
+
+func notifyNewAnime(ctx context.Context, rtm *slack.RTM, newAnimes db.NewAnimeRepo) error {
 	log.Println("新アニメ情報の通知開始")
-	newAnime, err := getNewAnime()
+	newAnime, err := getNewAnime(ctx)
 	if err != nil {
 		return fmt.Errorf("新アニメ情報の取得に失敗")
 	}
-	data, err := db.GetAllNewAnime()
+	data, err := newAnimes.GetAllNewAnime()
+	if err != nil {
+		return err
+	}
 	for _, n := range newAnime {
 		exists := false
 		for _, d := range data {
@@ -173,13 +227,16 @@ func notifyNewAnime(rtm *slack.RTM) error {
 			log.Printf("新アニメDBに追加 : %s(%d) %s", n.Title, n.TID, n.Station)
 			sendMsg(rtm, makeNewAnimeInfo(n))
 			time.Sleep(500 * time.Millisecond)
-			err = db.InsertNewAnime(n.TID, n.Title, n.Station, n.Time)
+			err = newAnimes.InsertNewAnime(n.TID, n.Title, n.Station, n.Time)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	data, err = db.GetAllNewAnime()
+	data, err = newAnimes.GetAllNewAnime()
+	if err != nil {
+		return err
+	}
 	for _, d := range data {
 		exists := false
 		for _, n := range newAnime {
@@ -189,7 +246,7 @@ func notifyNewAnime(rtm *slack.RTM) error {
 		}
 		if !exists {
 			log.Printf("新アニメDBから削除 : %s(%d) %s", d.Title, d.TID, d.Station)
-			err = db.DeleteNewAnime(d.ID)
+			err = newAnimes.DeleteNewAnime(d.ID)
 			if err != nil {
 				return err
 			}
@@ -214,7 +271,7 @@ func makeNewAnimeInfo(n newAnimeInfo) string {
 	return text
 }
 
-func processMsg(t string) string {
+func processMsg(ctx context.Context, t string) string {
 	tt := strings.Split(t, " ")
 	if len(tt) != 2 {
 		return "コマンド形式が不正"
@@ -226,11 +283,14 @@ func processMsg(t string) string {
 	if err != nil {
 		return "TIDの指定が不正"
 	}
-	err = reserve(tid, 0, conf.encQuality, conf.mp2cut, conf.mp4cut)
-	if err != nil {
-		return "録画予約失敗"
+	title, _ := getTitle(store, tid)
+	err = reserve(ctx, store, tid, 0, conf.encQuality, conf.mp2cut, conf.mp4cut)
+	if errors.Is(err, foltia.ErrAlreadyReserved) {
+		return fmt.Sprintf("【予約済み】\n%s (%d)", title, tid)
+	} else if err != nil {
+		log.Println(err)
+		return fmt.Sprintf("【録画予約失敗】\n%s (%d)\n%s", title, tid, err)
 	}
-	title, _ := getTitle(tid)
 	text := "【録画予約成功】\n"
 	text += fmt.Sprintf("%s (%d)", title, tid)
 	return text
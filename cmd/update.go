@@ -17,15 +17,20 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"sort"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/cheggaaa/pb/v3"
 	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/fetch"
+	"github.com/liebe-magi/falko/foltia"
+	"github.com/liebe-magi/falko/syoboi"
 	"github.com/spf13/cobra"
 )
 
@@ -37,548 +42,982 @@ type animeTitleInfo struct {
 }
 
 type animeFileInfo struct {
-	TID       int
-	Title     string
-	EpNum     int
-	PID       int
-	EpTitle   string
-	Time      time.Time
-	Station   string
-	FileTS    string
-	FileMP4HD string
-	FileMP4SD string
+	TID        int
+	Title      string
+	EpNum      int
+	EpSource   string
+	Special    bool
+	PID        int
+	EpTitle    string
+	Time       time.Time
+	Station    string
+	FileTS     string
+	FileMP4HD  string
+	FileMP4SD  string
+	Status     foltia.RecStatus
+	StatusText string
 }
 
 type keywordRecFileInfo struct {
-	Keyword   string
-	Title     string
-	PID       int
-	Time      time.Time
-	Station   string
-	FileTS    string
-	FileMP4HD string
-	FileMP4SD string
+	Keyword    string
+	Title      string
+	PID        int
+	Time       time.Time
+	Station    string
+	FileTS     string
+	FileMP4HD  string
+	FileMP4SD  string
+	Status     foltia.RecStatus
+	StatusText string
 }
 
-type keyInfo struct {
-	Key string
-	URL string
+// tsRefresh is a set of the recordings whose TS packet information is fetched again.
+// All recordings in the local DB are refreshed if all is true.
+type tsRefresh struct {
+	all  bool
+	pids map[int]bool
 }
 
+// exitPartialFailure is the exit status of update when some items are skipped because of errors
+const exitPartialFailure = 2
+
 var barTemp = `{{counters .}} {{bar . "|" "=" ">" "_" "|"}} {{ speed .}} {{percent .}} {{rtime . "ETA %s"}}`
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
-	Use:   "update",
+	Use:   "update [--refresh-ts [PID...]]",
 	Short: "Update local DB",
 	Run: func(cmd *cobra.Command, args []string) {
-		updateDB()
+		n, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if n > 0 {
+			conf.concurrency = n
+		}
+		if cmd.Flags().Changed("rps") {
+			rps, err := cmd.Flags().GetFloat64("rps")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			fhttpc.Limiter = fetch.NewLimiter(rps)
+		}
+		offline, err := cmd.Flags().GetBool("offline")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		full, err := cmd.Flags().GetBool("full")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if offline || dryRun {
+			sc = newSyoboiClient(offline, dryRun)
+		}
+		format, err := cmd.Flags().GetString("output")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if cmd.Flags().Changed("output") && !dryRun {
+			log.Fatalln("--outputは--dry-runと同時に指定して下さい")
+		}
+		if format != "table" && format != "json" {
+			log.Fatalf("出力形式が不正 : %s (table または json を指定して下さい)", format)
+		}
+		var refresh tsRefresh
+		refresh.all, err = cmd.Flags().GetBool("refresh-ts")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(args) > 0 && !refresh.all {
+			log.Fatalln("PIDは--refresh-tsと同時に指定して下さい")
+		}
+		if len(args) > 0 {
+			refresh.all = false
+			refresh.pids = map[int]bool{}
+			for _, a := range args {
+				pid, err := strconv.Atoi(a)
+				if err != nil {
+					log.Fatalf("PIDが不正 : %s", a)
+				}
+				refresh.pids[pid] = true
+			}
+		}
+		if dryRun {
+			// A dry run writes neither the local DB nor the cache of Syoboi Calendar
+			openReadOnlyStore()
+		} else {
+			openStore()
+		}
+		updateDB(cmd.Context(), full, dryRun, format, refresh)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(updateCmd)
+
+	updateCmd.Flags().IntP("concurrency", "j", 0, "foltia ANIME LOCKERへの同時リクエスト数 (未指定時は設定ファイルの値)")
+	updateCmd.Flags().Float64("rps", 0, "foltia ANIME LOCKERへの毎秒リクエスト数の上限 (0で無制限, 未指定時は設定ファイルの値)")
+	updateCmd.Flags().Bool("full", false, "前回の同期状態を使わずに全てのタイトルを取得し直す")
+	updateCmd.Flags().Bool("offline", false, "しょぼいカレンダーにアクセスせず、キャッシュ済みの情報のみを使用")
+	updateCmd.Flags().Bool("dry-run", false, "ローカルDBを変更せずに更新内容を表示")
+	updateCmd.Flags().StringP("output", "o", "table", "--dry-run時の出力形式 (table または json)")
+	updateCmd.Flags().Bool("refresh-ts", false, "TSパケット情報を取得し直す (PIDを指定しない場合は全ての録画)")
 }
 
-func updateDB() {
+func updateDB(ctx context.Context, full bool, dryRun bool, format string, refresh tsRefresh) {
 	log.Println("ローカルDBの更新を開始")
 
-	err := db.InitTitleDB()
+	repos := store.Repos()
+	p, err := planUpdate(ctx, repos, full, refresh)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	err = db.InitEpisodeDB()
-	if err != nil {
-		log.Fatalln(err)
+	if dryRun {
+		err = p.print(os.Stdout, format)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		err = applyUpdate(repos, p)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		data, _ := getCopyList(repos, false)
+		log.Printf("%d個の動画ファイルを検出", len(data))
+		log.Println("ローカルDBの更新を完了")
+	}
+	if len(p.Errors) > 0 {
+		p.printErrors()
+		exitCode = exitPartialFailure
+	}
+}
+
+// planUpdate fetches foltia and Syoboi and computes the changes to the local DB without writing it
+func planUpdate(ctx context.Context, r db.Repos, full bool, refresh tsRefresh) (*updatePlan, error) {
+	p := newUpdatePlan()
+
+	log.Println("アニメタイトル情報の取得を開始")
+	since := ""
+	if !full {
+		var err error
+		since, err = r.Sync.GetSyncState(db.SyncSyoboiLastUpdate)
+		if err != nil {
+			return p, err
+		}
+	}
+	atil, lastUpdate, err := getAnimeTitleInfo(ctx, since)
+	if errors.Is(err, syoboi.ErrNotCached) {
+		log.Printf("しょぼいカレンダーのタイトル情報が無いため、登録済みのタイトルのみ更新します : %s", err)
+	} else if err != nil && ctx.Err() == nil {
+		p.skip("しょぼいカレンダーのタイトル情報", err)
+	} else if err != nil {
+		return p, err
 	}
-	err = db.InitVideoFileDB()
+	if lastUpdate > since {
+		p.SyoboiLastUpdate = lastUpdate
+	}
+	ltl, err := fc.ListLibraryTitles(ctx)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	err = db.InitKeywordRecFileDB()
+	active, err := planTitles(r, p, atil, ltl)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
 
-	log.Println("アニメタイトルDBを更新")
-	atil, err := getAnimeTitleInfo()
+	log.Println("録画ライブラリの情報取得を開始")
+	titles, skipped, err := getChangedTitles(r, active, ltl, full)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
+	}
+	if len(skipped) > 0 {
+		log.Printf("前回から変化のない%d個のタイトルをスキップ", len(skipped))
 	}
-	err = insertNewTitle(atil)
+	kl, err := fc.ListKeywords(ctx)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	err = activateAnimeTitle()
+	// One bar counts the library pages and then the TS packet information pages added to its total
+	bar := pb.ProgressBarTemplate(barTemp).Start(len(titles) + len(kl))
+	afil, unknown, err := getVideoFile(ctx, p, titles, bar)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
+	}
+	krfil, unknownKey, err := getKeywordRecFile(ctx, p, kl, bar)
+	if err != nil {
+		return p, err
 	}
 
-	log.Println("動画ファイルの情報取得を開始")
-	afil, err := getVideoFile()
+	err = resolveEpisodes(ctx, r, afil, unknown)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	err = removeVideoFile(afil)
+
+	unfetched := map[int]bool{}
+	for tid := range unknown {
+		unfetched[tid] = true
+	}
+	for tid := range skipped {
+		unfetched[tid] = true
+	}
+
+	targets, err := getTSTargets(r, afil, krfil, unfetched, unknownKey, refresh)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	log.Println("エピソードDBの更新を開始")
-	err = insertNewEpisode(afil)
+	bar.AddTotal(int64(len(targets)))
+	tsInfo, err := getTSInfo(ctx, p, targets, bar)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	log.Println("動画ファイルDBの更新を開始")
-	err = insertNewVideoFile(afil)
+	bar.Finish()
+	for _, a := range afil {
+		if _, ok := tsInfo[a.PID]; !ok && targets[a.PID] {
+			unknown[a.TID] = true
+		}
+	}
+	err = planEpisodes(r, p, afil)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-
-	log.Println("キーワード録画ファイルの情報取得を開始")
-	krfil, err := getKeywordRecFile()
+	err = planVideoFiles(r, p, afil, unfetched, tsInfo)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	log.Println("キーワード録画ファイルDBの更新を開始")
-	err = removeKeywordRecFile(krfil)
+	err = planKeywordRecFiles(r, p, krfil, unknownKey, tsInfo)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-	err = insertNewKeywordRecFile(krfil)
+	err = planFingerprints(r, p, ltl, titles, afil, unknown)
 	if err != nil {
-		log.Fatalln(err)
+		return p, err
 	}
-
-	data, err := getCopyList(false)
-	log.Printf("%d個の動画ファイルを検出", len(data))
-	log.Println("ローカルDBの更新を完了")
+	return p, nil
 }
 
-func getAnimeTitleInfo() ([]animeTitleInfo, error) {
-	url := "http://cal.syoboi.jp/db.php?Command=TitleLookup&TID=*"
-	doc, err := goquery.NewDocument(url)
+// syoboiTimeLayout is the layout of the watermark of Syoboi in Sync DB
+const syoboiTimeLayout = "2006-01-02 15:04:05"
+
+// getAnimeTitleInfo gets titles updated in Syoboi since the watermark, or all titles if since is "".
+// It also returns the newest LastUpdate of the titles as the next watermark.
+func getAnimeTitleInfo(ctx context.Context, since string) ([]animeTitleInfo, string, error) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
 	if err != nil {
-		return []animeTitleInfo{}, err
+		return []animeTitleInfo{}, "", err
 	}
-	var atil []animeTitleInfo
+	var t time.Time
+	if since != "" {
+		t, err = time.ParseInLocation(syoboiTimeLayout, since, loc)
+		if err != nil {
+			return []animeTitleInfo{}, "", err
+		}
+	}
+	tl, err := sc.TitleLookup(ctx, t)
 	if err != nil {
-		return []animeTitleInfo{}, err
+		return []animeTitleInfo{}, "", err
 	}
-	doc.Find("TitleLookupResponse > TitleItems > TitleItem").Each(func(i int, s *goquery.Selection) {
-		var a animeTitleInfo
-		a.TID, _ = strconv.Atoi(s.Find("TID").Text())
-		a.Title = strings.TrimSpace(s.Find("Title").Text())
-		a.Yomi = strings.TrimSpace(s.Find("TitleYomi").Text())
-		a.Year, _ = strconv.Atoi(s.Find("FirstYear").Text())
-		atil = append(atil, a)
-	})
-	return atil, nil
+	var atil []animeTitleInfo
+	lastUpdate := since
+	for _, t := range tl {
+		atil = append(atil, animeTitleInfo{TID: t.TID, Title: t.Title, Yomi: t.Yomi, Year: t.FirstYear})
+		if t.LastUpdate.IsZero() {
+			continue
+		}
+		if u := t.LastUpdate.In(loc).Format(syoboiTimeLayout); u > lastUpdate {
+			lastUpdate = u
+		}
+	}
+	return atil, lastUpdate, nil
 }
 
-func activateAnimeTitle() error {
-	url := "http://" + conf.fHost + "/recorded/recfiles_tid.php?mode=detail"
-	doc, err := goquery.NewDocument(url)
+// planTitles adds new and changed titles to the plan.
+// Titles are activated if they are in the library and deactivated if not.
+// It returns the titles which are active after the update.
+func planTitles(r db.Repos, p *updatePlan, atil []animeTitleInfo, ltl []foltia.LibraryTitle) ([]db.AnimeTitle, error) {
+	data, err := r.Titles.GetAllTitle()
 	if err != nil {
-		return err
+		return []db.AnimeTitle{}, err
 	}
-	data, err := db.GetAllTitle()
-	if err != nil {
-		return err
+	library := map[int]bool{}
+	for _, l := range ltl {
+		library[l.TID] = true
 	}
-	num := doc.Find("#Librarytable > table > tbody").Find("tr").Length()
-	bar := pb.ProgressBarTemplate(barTemp).Start(len(data))
+	titles := map[int]db.AnimeTitle{}
 	for _, d := range data {
-		exists := false
-		for i := 0; i < num; i++ {
-			t := doc.Find(fmt.Sprintf("#Librarytable > table > tbody > tr:nth-child(%d) > td:nth-child(1) > a", i+1)).Text()
-			tid, err := strconv.Atoi(t)
-			if err != nil {
-				log.Fatalln(err)
+		titles[d.TID] = d
+	}
+	var active []db.AnimeTitle
+	changed := map[int]bool{}
+	for _, a := range atil {
+		d, exist := titles[a.TID]
+		if !exist {
+			d = db.AnimeTitle{TID: a.TID, Title: a.Title, TitleYomi: a.Yomi, Year: a.Year, Active: library[a.TID]}
+			titles[a.TID] = d
+			p.Titles = append(p.Titles, newTitleChange(actionAdd, d))
+			if d.Active {
+				active = append(active, d)
 			}
-			if tid == d.TID {
-				if !d.Active {
-					db.UpdateTitle(d.ID, d.TID, d.Title, d.TitleYomi, d.Year, true)
-				}
-				exists = true
-				break
+			continue
+		}
+		if a.Title != d.Title || a.Yomi != d.TitleYomi || a.Year != d.Year {
+			d.Title = a.Title
+			d.TitleYomi = a.Yomi
+			d.Year = a.Year
+			titles[a.TID] = d
+			changed[a.TID] = true
+		}
+	}
+	for _, d := range data {
+		t := titles[d.TID]
+		if t.Active != library[t.TID] {
+			t.Active = library[t.TID]
+			if t.Active {
+				p.Titles = append(p.Titles, newTitleChange(actionActivate, t))
+			} else {
+				p.Titles = append(p.Titles, newTitleChange(actionDeactivate, t))
 			}
+		} else if changed[t.TID] {
+			p.Titles = append(p.Titles, newTitleChange(actionChange, t))
 		}
-		if !exists && d.Active {
-			db.UpdateTitle(d.ID, d.TID, d.Title, d.TitleYomi, d.Year, false)
+		if t.Active {
+			active = append(active, t)
 		}
-		bar.Increment()
 	}
-	bar.Finish()
-	return nil
+	return active, nil
 }
 
-func insertNewTitle(atil []animeTitleInfo) error {
-	data, err := db.GetAllTitle()
-	if err != nil {
-		return err
+// getChangedTitles selects the titles whose library summary differs from the last update.
+// Titles which still have unfinished recordings are always selected.
+// The other titles are returned as skipped.
+func getChangedTitles(r db.Repos, active []db.AnimeTitle, ltl []foltia.LibraryTitle, full bool) ([]db.AnimeTitle, map[int]bool, error) {
+	skipped := map[int]bool{}
+	if full {
+		return active, skipped, nil
 	}
-	for _, a := range atil {
-		exist := false
-		for _, d := range data {
-			if a.TID == d.TID {
-				exist = true
-				break
-			}
-		}
-		if !exist {
-			db.InsertTitle(a.TID, a.Title, a.Yomi, a.Year, false)
+	fl, err := r.Sync.GetAllFingerprint()
+	if err != nil {
+		return []db.AnimeTitle{}, skipped, err
+	}
+	fps := map[int]db.TitleFingerprint{}
+	for _, f := range fl {
+		fps[f.TID] = f
+	}
+	lts := map[int]foltia.LibraryTitle{}
+	for _, l := range ltl {
+		lts[l.TID] = l
+	}
+	var changed []db.AnimeTitle
+	for _, d := range active {
+		f, ok := fps[d.TID]
+		l := lts[d.TID]
+		if ok && f.Files == l.Files && f.Latest.Equal(l.Latest) && f.Completed >= f.Files {
+			skipped[d.TID] = true
+			continue
 		}
+		changed = append(changed, d)
 	}
-	return nil
+	return changed, skipped, nil
 }
 
-func removeVideoFile(afil []animeFileInfo) error {
-	data, err := db.GetAllVideoFile()
+// planFingerprints adds the library summary of the fetched titles to the plan for the next update.
+// Fingerprints of the titles which are no longer in the library are removed.
+func planFingerprints(r db.Repos, p *updatePlan, ltl []foltia.LibraryTitle, fetched []db.AnimeTitle, afil []animeFileInfo, unknown map[int]bool) error {
+	completed := map[int]int{}
+	for _, a := range afil {
+		if a.Status == foltia.RecCompleted {
+			completed[a.TID]++
+		}
+	}
+	lts := map[int]foltia.LibraryTitle{}
+	for _, l := range ltl {
+		lts[l.TID] = l
+	}
+	for _, d := range fetched {
+		l, ok := lts[d.TID]
+		if !ok || unknown[d.TID] {
+			continue
+		}
+		p.fingerprints = append(p.fingerprints, db.TitleFingerprint{TID: d.TID, Files: l.Files, Completed: completed[d.TID], Latest: l.Latest})
+	}
+	fl, err := r.Sync.GetAllFingerprint()
 	if err != nil {
 		return err
 	}
-	for _, d := range data {
-		exists := false
-		for _, a := range afil {
-			if d.PID == a.PID {
-				exists = true
-				if d.FileTS != a.FileTS || d.FileMP4HD != a.FileMP4HD || d.FileMP4SD != a.FileMP4SD {
-					log.Printf("動画ファイルの情報を更新 : %s (%d:%s)", a.Title, a.EpNum, a.EpTitle)
-					err = db.UpdateVideoFile(d.ID, d.TID, d.EpNum, d.PID, a.FileTS, a.FileMP4HD, a.FileMP4SD, d.Station, d.Time, d.Drop, d.Scramble)
-					if err != nil {
-						return err
-					}
-				}
-				break
-			}
-		}
-		if !exists {
-			title, err := getTitle(d.TID)
-			if err != nil {
-				return err
-			}
-			log.Printf("動画ファイルの情報を削除 : %s (%d): %d", title, d.EpNum, d.PID)
-			err = db.DeleteVideoFile(d.ID)
-			if err != nil {
-				return err
-			}
+	for _, f := range fl {
+		if _, ok := lts[f.TID]; !ok {
+			p.staleFingerprints = append(p.staleFingerprints, f.TID)
 		}
 	}
 	return nil
 }
 
-func getTitle(tid int) (string, error) {
-	data, err := db.GetAllTitle()
-	if err != nil {
+func getTitle(titles db.TitleRepo, tid int) (string, error) {
+	t, err := titles.GetTitleByTID(tid)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", fmt.Errorf("TIDが未定義")
+	} else if err != nil {
 		return "", err
 	}
-	for _, d := range data {
-		if d.TID == tid {
-			return d.Title, nil
-		}
-	}
-	return "", fmt.Errorf("TIDが未定義")
+	return t.Title, nil
 }
 
-func getVideoFile() ([]animeFileInfo, error) {
-	var afil []animeFileInfo
-	data, err := db.GetAllTitle()
+// getTitleNames gets the titles in the local DB indexed by TID
+func getTitleNames(titles db.TitleRepo) (map[int]string, error) {
+	data, err := titles.GetAllTitle()
 	if err != nil {
-		return []animeFileInfo{}, err
+		return map[int]string{}, err
 	}
-	loc, err := time.LoadLocation("Asia/Tokyo")
+	names := map[int]string{}
+	for _, d := range data {
+		names[d.TID] = d.Title
+	}
+	return names, nil
+}
+
+// getVideoFile gets the recordings of the titles.
+// Titles whose recordings are not all fetched are returned as unknown.
+func getVideoFile(ctx context.Context, p *updatePlan, titles []db.AnimeTitle, bar *pb.ProgressBar) ([]animeFileInfo, map[int]bool, error) {
+	results := make([][]foltia.Recording, len(titles))
+	failed := make([]error, len(titles))
+	err := parallel(ctx, conf.concurrency, len(titles), func(ctx context.Context, i int) error {
+		defer bar.Increment()
+		results[i], failed[i] = fc.ListRecordings(ctx, titles[i].TID)
+		return ctx.Err()
+	})
 	if err != nil {
-		return []animeFileInfo{}, err
+		return []animeFileInfo{}, map[int]bool{}, err
 	}
-	bar := pb.ProgressBarTemplate(barTemp).Start(len(data))
-	for _, d := range data {
-		if d.Active {
-			url := "http://" + conf.fHost + "/recorded/recfiles_tid.php?mode=detail&tid=" + fmt.Sprintf("%d", d.TID)
-			doc, err := goquery.NewDocument(url)
-			if err != nil {
+	var afil []animeFileInfo
+	unknown := map[int]bool{}
+	for i, d := range titles {
+		if failed[i] != nil {
+			target := fmt.Sprintf("%s(%d)", d.Title, d.TID)
+			unknown[d.TID] = true
+			var errs foltia.RecordingErrors
+			if !errors.As(failed[i], &errs) {
+				p.skip(target, failed[i])
 				continue
 			}
-			num := doc.Find("#libraryDetail > li").Length()
-			for i := 0; i < num; i++ {
-				var afi animeFileInfo
-				afi.TID = d.TID
-				afi.Title = d.Title
-				e := strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(2)", i+1)).Text(), "話数：")
-				if e == "[話数]" {
-					e = "-1"
-				}
-				afi.EpNum, err = strconv.Atoi(e)
-				if err != nil {
-					return []animeFileInfo{}, err
-				}
-				afi.EpTitle = strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(3)", i+1)).Text(), "サブタイトル："))
-				t := strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(4)", i+1)).Text(), "録画日時："))
-				afi.Time, err = time.ParseInLocation("2006/01/02 15:04", strings.Split(t, "(")[0]+strings.Split(t, ")")[1], loc)
-				if err != nil {
-					return []animeFileInfo{}, err
-				}
-				afi.Station = strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(5)", i+1)).Text(), "放送局："))
-				status := strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(6)", i+1)).Text(), "ステータス："))
-				if status != "完了" {
-					continue
-				}
-				doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > div > ul.fileType > li", i+1)).Each(func(j int, s *goquery.Selection) {
-					t, _ := s.Attr("class")
-					if t == "mpeg2" {
-						afi.FileTS = strings.TrimSpace(s.Text())
-					} else if t == "mp4HD" {
-						afi.FileMP4HD = strings.TrimSpace(s.Text())
-					} else if t == "mp4SD" {
-						afi.FileMP4SD = strings.TrimSpace(s.Text())
-					}
-				})
-				p, exists := doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > div > a ", i+1)).Attr("href")
-				if !exists {
-					return []animeFileInfo{}, fmt.Errorf("PID not found")
-				}
-				afi.PID, err = strconv.Atoi(strings.TrimPrefix(p, "./selectcaptureimage.php?pid="))
-				if err != nil {
-					return []animeFileInfo{}, err
-				}
-				afil = append(afil, afi)
+			for _, e := range errs {
+				p.skip(target, e)
 			}
 		}
-		bar.Increment()
+		for _, r := range results[i] {
+			var afi animeFileInfo
+			afi.TID = d.TID
+			afi.Title = d.Title
+			afi.EpNum = r.EpNum
+			afi.EpSource = db.EpSourceFoltia
+			if r.EpNum == -1 {
+				afi.EpSource = db.EpSourceUnresolved
+			}
+			afi.PID = r.PID
+			afi.EpTitle = r.EpTitle
+			afi.Time = r.Time
+			afi.Station = r.Station
+			afi.FileTS = r.FileTS
+			afi.FileMP4HD = r.FileMP4HD
+			afi.FileMP4SD = r.FileMP4SD
+			afi.Status = r.Status
+			afi.StatusText = r.StatusText
+			afil = append(afil, afi)
+		}
 	}
-	bar.Finish()
-	return afil, nil
+	return afil, unknown, nil
 }
 
-func checkEpisode(tid int, epnum int) (bool, error) {
-	data, err := db.GetAllEpisode()
+// syoboiWindow is the difference of the start time allowed when matching a recording to a program of Syoboi Calendar
+const syoboiWindow = 10 * time.Minute
+
+// resolveEpisodes fills the episode number of the recordings which foltia shows as [話数] from Syoboi Calendar.
+// The program is matched by TID, station and start time.
+// Specials are numbered by the negated PID of Syoboi Calendar so that each of them is a distinct episode.
+// Recordings without a matching program or a known station are marked as EpSourceNotFound not to be looked up again.
+// Titles whose recordings cannot be looked up are marked as unknown to be retried in the next update.
+func resolveEpisodes(ctx context.Context, r db.Repos, afil []animeFileInfo, unknown map[int]bool) error {
+	data, err := r.VideoFiles.GetAllVideoFile()
 	if err != nil {
-		return false, err
+		return err
 	}
+	resolved := map[int]db.VideoFile{}
 	for _, d := range data {
-		if d.TID == tid && d.EpNum == epnum {
-			return true, nil
+		if d.EpSource == db.EpSourceSyoboi || d.EpSource == db.EpSourceNotFound {
+			resolved[d.PID] = d
 		}
 	}
-	return false, nil
-}
-
-func checkVideoFile(pid int) (bool, error) {
-	data, err := db.GetAllVideoFile()
+	var targets []int
+	for i, a := range afil {
+		if a.EpSource != db.EpSourceUnresolved {
+			continue
+		}
+		if d, ok := resolved[a.PID]; ok {
+			afil[i].EpNum = d.EpNum
+			afil[i].EpSource = d.EpSource
+			afil[i].Special = d.EpNum < -1
+			continue
+		}
+		targets = append(targets, i)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	log.Println("しょぼいカレンダーから話数の取得を開始")
+	results := make([]syoboi.Program, len(targets))
+	found := make([]bool, len(targets))
+	failed := make([]error, len(targets))
+	noStation := make([]error, len(targets))
+	err = parallel(ctx, conf.concurrency, len(targets), func(ctx context.Context, i int) error {
+		a := afil[targets[i]]
+		chid, err := foltia.StationID(a.Station)
+		if err != nil {
+			noStation[i] = err
+			return nil
+		}
+		pl, err := sc.ProgLookup(ctx, a.TID, chid, a.Time.Add(-syoboiWindow), a.Time.Add(syoboiWindow))
+		if err != nil {
+			failed[i] = err
+			return ctx.Err()
+		}
+		results[i], found[i] = syoboi.Nearest(pl, a.Time)
+		return nil
+	})
 	if err != nil {
-		return false, err
+		return err
 	}
-	for _, d := range data {
-		if d.PID == pid {
-			return true, nil
+	for i, t := range targets {
+		a := &afil[t]
+		if failed[i] != nil {
+			log.Printf("しょぼいカレンダーから話数を取得できませんでした : %s (%d) : %s", a.Title, a.PID, failed[i])
+			unknown[a.TID] = true
+			continue
+		}
+		if noStation[i] != nil {
+			log.Printf("放送局が不明なため話数を取得できません : %s (%d) : %s", a.Title, a.PID, noStation[i])
+			a.EpSource = db.EpSourceNotFound
+			continue
+		}
+		if !found[i] {
+			log.Printf("しょぼいカレンダーに番組が見つかりません : %s (%d) %s %s", a.Title, a.PID, a.Station, a.Time.Format("2006/01/02 15:04"))
+			a.EpSource = db.EpSourceNotFound
+			continue
+		}
+		prog := results[i]
+		a.EpSource = db.EpSourceSyoboi
+		if prog.Special() {
+			a.EpNum = -prog.PID
+			a.Special = true
+		} else {
+			a.EpNum = prog.Count
+		}
+		if a.EpTitle == "" {
+			a.EpTitle = prog.SubTitle
 		}
 	}
-	return false, nil
+	return nil
 }
 
-func insertNewEpisode(afil []animeFileInfo) error {
-	bar := pb.ProgressBarTemplate(barTemp).Start(len(afil))
+// tsChanged checks whether the fetched TS packet information differs from the stored one
+func tsChanged(drop int, scramble int, ci foltia.CMInfo, fetched bool) bool {
+	return fetched && (ci.Drop != drop || ci.Scramble != scramble)
+}
+
+// episodeKey identifies an episode by TID and episode number
+type episodeKey struct {
+	TID   int
+	EpNum int
+}
+
+// planEpisodes adds the episodes which are not in the local DB yet to the plan
+func planEpisodes(r db.Repos, p *updatePlan, afil []animeFileInfo) error {
+	data, err := r.Episodes.GetAllEpisode()
+	if err != nil {
+		return err
+	}
+	episodes := map[episodeKey]bool{}
+	for _, d := range data {
+		episodes[episodeKey{d.TID, d.EpNum}] = true
+	}
 	for _, a := range afil {
-		exists, err := checkEpisode(a.TID, a.EpNum)
-		if err != nil {
-			return err
+		key := episodeKey{a.TID, a.EpNum}
+		if !episodes[key] {
+			e := db.AnimeEpisode{TID: a.TID, EpNum: a.EpNum, EpTitle: a.EpTitle, EpSource: a.EpSource, Special: a.Special, CopyStatus: false}
+			p.Episodes = append(p.Episodes, episodeChange{Action: actionAdd, TID: a.TID, Title: a.Title, EpNum: a.EpNum, EpTitle: a.EpTitle, record: e})
+			episodes[key] = true
 		}
-		if !exists {
-			err = db.InsertEpisode(a.TID, a.EpNum, a.EpTitle, false)
-			if err != nil {
-				return err
-			}
-		}
-		bar.Increment()
 	}
-	bar.Finish()
 	return nil
 }
 
-func insertNewVideoFile(afil []animeFileInfo) error {
-	bar := pb.ProgressBarTemplate(barTemp).Start(len(afil))
+// planVideoFiles adds new, changed, removed and restored video files to the plan.
+// Removed files are kept in the DB as removed so that their history and TS packet information are not lost.
+// Files of the TIDs in unfetched are kept since their pages were not fetched in this run.
+func planVideoFiles(r db.Repos, p *updatePlan, afil []animeFileInfo, unfetched map[int]bool, tsInfo map[int]foltia.CMInfo) error {
+	data, err := r.VideoFiles.GetAllVideoFile()
+	if err != nil {
+		return err
+	}
+	removed, err := r.VideoFiles.GetRemovedVideoFile()
+	if err != nil {
+		return err
+	}
+	names, err := getTitleNames(r.Titles)
+	if err != nil {
+		return err
+	}
+	files := map[int]animeFileInfo{}
 	for _, a := range afil {
-		exists, err := checkVideoFile(a.PID)
-		if err != nil {
-			return err
+		if _, ok := files[a.PID]; !ok {
+			files[a.PID] = a
 		}
-		if !exists {
-			dr, sc, err := getTSInfo(a.PID)
-			if err != nil {
-				return err
+	}
+	now := time.Now()
+	pids := map[int]bool{}
+	for _, d := range data {
+		pids[d.PID] = true
+		a, exists := files[d.PID]
+		ci, fetched := tsInfo[d.PID]
+		if exists {
+			filesChanged := d.FileTS != a.FileTS || d.FileMP4HD != a.FileMP4HD || d.FileMP4SD != a.FileMP4SD
+			statusChanged := d.RecStatus != string(a.Status) || d.RecStatusText != a.StatusText
+			if a.Status == foltia.RecCompleted && (filesChanged || d.RecStatus != string(a.Status)) && !fetched {
+				// Left as is to be fetched again in the next update
+				continue
 			}
-			err = db.InsertVideoFile(a.TID, a.EpNum, a.PID, a.FileTS, a.FileMP4HD, a.FileMP4SD, a.Station, a.Time, dr, sc)
-			if err != nil {
-				return err
+			if filesChanged || statusChanged || d.EpNum != a.EpNum || d.EpSource != a.EpSource || tsChanged(d.Drop, d.Scramble, ci, fetched) {
+				prev := d
+				d.EpNum = a.EpNum
+				d.EpSource = a.EpSource
+				d.FileTS = a.FileTS
+				d.FileMP4HD = a.FileMP4HD
+				d.FileMP4SD = a.FileMP4SD
+				if d.RecStatus != string(a.Status) {
+					d.RecStatusAt = now
+				}
+				d.RecStatus = string(a.Status)
+				d.RecStatusText = a.StatusText
+				if fetched {
+					d.Drop = ci.Drop
+					d.Scramble = ci.Scramble
+				}
+				c := newVideoFileChange(actionChange, a.Title, a.EpTitle, d)
+				c.previous = &prev
+				p.VideoFiles = append(p.VideoFiles, c)
 			}
+			if fetched {
+				p.setTSSegments(ci)
+			}
+			continue
+		}
+		if unfetched[d.TID] && fetched {
+			// Only TS packet information is refreshed since the title was not fetched in this run
+			if tsChanged(d.Drop, d.Scramble, ci, fetched) {
+				prev := d
+				d.Drop = ci.Drop
+				d.Scramble = ci.Scramble
+				c := newVideoFileChange(actionChange, names[d.TID], "", d)
+				c.previous = &prev
+				p.VideoFiles = append(p.VideoFiles, c)
+			}
+			p.setTSSegments(ci)
+			continue
+		}
+		if !unfetched[d.TID] {
+			title, ok := names[d.TID]
+			if !ok {
+				p.skip(fmt.Sprintf("PID:%d", d.PID), fmt.Errorf("TIDが未定義 : %d", d.TID))
+				continue
+			}
+			d.Status = db.StatusRemoved
+			d.RemovedAt = &now
+			p.VideoFiles = append(p.VideoFiles, newVideoFileChange(actionRemove, title, "", d))
+		}
+	}
+	// The removed row of the PID is restored if the recording appears again.
+	// PID is unique, so there is at most one removed row for each PID.
+	tombstones := map[int]db.VideoFile{}
+	for _, r := range removed {
+		tombstones[r.PID] = r
+	}
+	for _, a := range afil {
+		ci, ok := tsInfo[a.PID]
+		if pids[a.PID] || a.Status == foltia.RecCompleted && !ok {
+			continue
+		}
+		pids[a.PID] = true
+		v, restored := tombstones[a.PID]
+		v.TID = a.TID
+		v.EpNum = a.EpNum
+		v.EpSource = a.EpSource
+		v.PID = a.PID
+		v.FileTS = a.FileTS
+		v.FileMP4HD = a.FileMP4HD
+		v.FileMP4SD = a.FileMP4SD
+		v.Station = a.Station
+		v.Time = a.Time
+		v.Status = db.StatusPresent
+		v.RemovedAt = nil
+		v.RecStatus = string(a.Status)
+		v.RecStatusText = a.StatusText
+		v.RecStatusAt = now
+		if ok {
+			v.Drop = ci.Drop
+			v.Scramble = ci.Scramble
+			p.setTSSegments(ci)
+		}
+		if restored {
+			p.VideoFiles = append(p.VideoFiles, newVideoFileChange(actionRestore, a.Title, a.EpTitle, v))
+		} else {
+			p.VideoFiles = append(p.VideoFiles, newVideoFileChange(actionAdd, a.Title, a.EpTitle, v))
 		}
-		bar.Increment()
 	}
-	bar.Finish()
 	return nil
 }
 
-func getTSInfo(pid int) (int, int, error) {
-	url := "http://" + conf.fHost + "/recorded/showcminfo.php?pid=" + fmt.Sprintf("%d", pid)
-	doc, err := goquery.NewDocument(url)
-	if err != nil {
-		return 0, 0, err
-	}
-	n := doc.Find("#programInfo").Children().Length()
-	text := strings.Split(doc.Find(fmt.Sprintf("#programInfo > p:nth-child(%d)", n)).Text(), "\n")
-	dr := 0
-	sc := 0
-	for i, t := range text {
-		if i > 0 {
-			e := strings.Split(t, ", ")
-			if len(e) == 4 {
-				d, err := strconv.Atoi(strings.TrimSpace(strings.Split(e[2], "=")[1]))
-				if err != nil {
-					return 0, 0, err
-				}
-				s, err := strconv.Atoi(strings.TrimSpace(strings.Split(e[3], "=")[1]))
-				if err != nil {
-					return 0, 0, err
-				}
-				dr += d
-				sc += s
-			}
+// getTSTargets selects the completed recordings whose TS packet information is fetched:
+// new recordings, recordings whose files or status changed and the recordings to refresh.
+// Recordings which are going to be removed are not refreshed.
+// TS packet information of unfinished recordings is fetched after they are completed.
+func getTSTargets(r db.Repos, afil []animeFileInfo, krfil []keywordRecFileInfo, unfetched map[int]bool, unknownKey map[string]bool, refresh tsRefresh) (map[int]bool, error) {
+	vfl, err := r.VideoFiles.GetAllVideoFile()
+	if err != nil {
+		return map[int]bool{}, err
+	}
+	krfl, err := r.KeywordRecs.GetAllKeywordRecFile()
+	if err != nil {
+		return map[int]bool{}, err
+	}
+	type listedFile struct {
+		files  [3]string
+		status foltia.RecStatus
+	}
+	files := map[int]listedFile{}
+	for _, a := range afil {
+		files[a.PID] = listedFile{[3]string{a.FileTS, a.FileMP4HD, a.FileMP4SD}, a.Status}
+	}
+	for _, k := range krfil {
+		files[k.PID] = listedFile{[3]string{k.FileTS, k.FileMP4HD, k.FileMP4SD}, k.Status}
+	}
+	completed := string(foltia.RecCompleted)
+	targets := map[int]bool{}
+	known := map[int]bool{}
+	check := func(pid int, stored [3]string, status string, kept bool) {
+		known[pid] = true
+		f, listed := files[pid]
+		if listed && f.status != foltia.RecCompleted {
+			return
+		}
+		if listed && (f.files != stored || status != completed) {
+			targets[pid] = true
+		}
+		if (listed || kept && status == completed) && (refresh.all || refresh.pids[pid]) {
+			targets[pid] = true
+		}
+	}
+	for _, v := range vfl {
+		check(v.PID, [3]string{v.FileTS, v.FileMP4HD, v.FileMP4SD}, v.RecStatus, unfetched[v.TID])
+	}
+	for _, k := range krfl {
+		check(k.PID, [3]string{k.FileTS, k.FileMP4HD, k.FileMP4SD}, k.RecStatus, unknownKey[k.Keyword])
+	}
+	for pid, f := range files {
+		if !known[pid] && f.status == foltia.RecCompleted {
+			targets[pid] = true
 		}
 	}
-	return dr, sc, nil
+	for pid := range refresh.pids {
+		if !known[pid] {
+			log.Printf("ローカルDBに録画が見つかりません : %d", pid)
+		}
+	}
+	return targets, nil
 }
 
-func getKeywordRecFile() ([]keywordRecFileInfo, error) {
-	var krfil []keywordRecFileInfo
-	url := "http://" + conf.fHost + "/recorded/recfiles_key.php?mode=detail"
-	doc, err := goquery.NewDocument(url)
+// getTSInfo gets TS packet information of the recordings.
+// Recordings whose information cannot be fetched are not in the result.
+func getTSInfo(ctx context.Context, p *updatePlan, targets map[int]bool, bar *pb.ProgressBar) (map[int]foltia.CMInfo, error) {
+	var pids []int
+	for pid := range targets {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	results := make([]foltia.CMInfo, len(pids))
+	failed := make([]error, len(pids))
+	err := parallel(ctx, conf.concurrency, len(pids), func(ctx context.Context, i int) error {
+		defer bar.Increment()
+		results[i], failed[i] = fc.GetCMInfo(ctx, pids[i])
+		return ctx.Err()
+	})
 	if err != nil {
-		return []keywordRecFileInfo{}, err
+		return map[int]foltia.CMInfo{}, err
 	}
-	var keylist []keyInfo
-	doc.Find("#Librarytable > table > tbody > tr").Each(func(i int, s *goquery.Selection) {
-		key := s.Find("td > a").Text()
-		url, exists := s.Find("td > a").Attr("href")
-		if exists {
-			k := keyInfo{Key: key, URL: url}
-			keylist = append(keylist, k)
+	tsInfo := map[int]foltia.CMInfo{}
+	for i, pid := range pids {
+		if failed[i] != nil {
+			p.skip(fmt.Sprintf("PID:%d", pid), failed[i])
+			continue
 		}
+		tsInfo[pid] = results[i]
+	}
+	return tsInfo, nil
+}
+
+// getKeywordRecFile gets the recordings of the keywords.
+// Keywords whose recordings are not all fetched are returned as unknown.
+func getKeywordRecFile(ctx context.Context, p *updatePlan, kl []foltia.Keyword, bar *pb.ProgressBar) ([]keywordRecFileInfo, map[string]bool, error) {
+	results := make([][]foltia.Recording, len(kl))
+	failed := make([]error, len(kl))
+	err := parallel(ctx, conf.concurrency, len(kl), func(ctx context.Context, i int) error {
+		defer bar.Increment()
+		results[i], failed[i] = fc.ListKeywordRecordings(ctx, kl[i])
+		return ctx.Err()
 	})
-	loc, err := time.LoadLocation("Asia/Tokyo")
 	if err != nil {
-		return []keywordRecFileInfo{}, err
+		return []keywordRecFileInfo{}, map[string]bool{}, err
 	}
-	bar := pb.ProgressBarTemplate(barTemp).Start(len(keylist))
-	for _, k := range keylist {
-		url := "http://" + conf.fHost + "/recorded/" + k.URL
-		doc, err := goquery.NewDocument(url)
-		if err != nil {
-			continue
-		}
-		num := doc.Find("#libraryDetail > li").Length()
-		for i := 0; i < num; i++ {
-			var krfi keywordRecFileInfo
-			krfi.Keyword = k.Key
-			krfi.Title = strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(3)", i+1)).Text(), "サブタイトル："))
-			t := strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(4)", i+1)).Text(), "録画日時："))
-			krfi.Time, err = time.ParseInLocation("2006/01/02 15:04", strings.Split(t, "(")[0]+strings.Split(t, ")")[1], loc)
-			if err != nil {
-				return []keywordRecFileInfo{}, err
-			}
-			krfi.Station = strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(5)", i+1)).Text(), "放送局："))
-			status := strings.TrimSpace(strings.TrimPrefix(doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > li:nth-child(6)", i+1)).Text(), "ステータス："))
-			if status != "完了" {
+	var krfil []keywordRecFileInfo
+	unknown := map[string]bool{}
+	for i, k := range kl {
+		if failed[i] != nil {
+			unknown[k.Name] = true
+			var errs foltia.RecordingErrors
+			if !errors.As(failed[i], &errs) {
+				p.skip(k.Name, failed[i])
 				continue
 			}
-			doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > div > ul.fileType > li", i+1)).Each(func(j int, s *goquery.Selection) {
-				t, _ := s.Attr("class")
-				if t == "mpeg2" {
-					krfi.FileTS = strings.TrimSpace(s.Text())
-				} else if t == "mp4HD" {
-					krfi.FileMP4HD = strings.TrimSpace(s.Text())
-				} else if t == "mp4SD" {
-					krfi.FileMP4SD = strings.TrimSpace(s.Text())
-				}
-			})
-			p, exists := doc.Find(fmt.Sprintf("#libraryDetail > li:nth-child(%d) > div.programInfo > ul > div > a ", i+1)).Attr("href")
-			if !exists {
-				return []keywordRecFileInfo{}, fmt.Errorf("PID not found")
-			}
-			krfi.PID, err = strconv.Atoi(strings.TrimPrefix(p, "./selectcaptureimage.php?pid="))
-			if err != nil {
-				return []keywordRecFileInfo{}, err
+			for _, e := range errs {
+				p.skip(k.Name, e)
 			}
+		}
+		for _, r := range results[i] {
+			var krfi keywordRecFileInfo
+			krfi.Keyword = k.Name
+			krfi.Title = r.EpTitle
+			krfi.PID = r.PID
+			krfi.Time = r.Time
+			krfi.Station = r.Station
+			krfi.FileTS = r.FileTS
+			krfi.FileMP4HD = r.FileMP4HD
+			krfi.FileMP4SD = r.FileMP4SD
+			krfi.Status = r.Status
+			krfi.StatusText = r.StatusText
 			krfil = append(krfil, krfi)
 		}
-		bar.Increment()
 	}
-	bar.Finish()
-
-	return krfil, nil
+	return krfil, unknown, nil
 }
 
-func removeKeywordRecFile(krfil []keywordRecFileInfo) error {
-	data, err := db.GetAllKeywordRecFile()
+// planKeywordRecFiles adds new, changed, removed and restored keyword recordings to the plan.
+// Removed recordings are kept in the DB as removed like video files.
+// Files of the keywords in unknown are kept since their pages could not be fetched.
+func planKeywordRecFiles(r db.Repos, p *updatePlan, krfil []keywordRecFileInfo, unknown map[string]bool, tsInfo map[int]foltia.CMInfo) error {
+	data, err := r.KeywordRecs.GetAllKeywordRecFile()
+	if err != nil {
+		return err
+	}
+	removed, err := r.KeywordRecs.GetRemovedKeywordRecFile()
 	if err != nil {
 		return err
 	}
+	files := map[int]keywordRecFileInfo{}
+	for _, k := range krfil {
+		if _, ok := files[k.PID]; !ok {
+			files[k.PID] = k
+		}
+	}
+	now := time.Now()
+	pids := map[int]bool{}
 	for _, d := range data {
-		exists := false
-		for _, k := range krfil {
-			if d.PID == k.PID {
-				exists = true
-				if d.FileTS != k.FileTS || d.FileMP4HD != k.FileMP4HD || d.FileMP4SD != k.FileMP4SD {
-					log.Printf("動画ファイルの情報を更新 : %s (%d)", k.Title, k.PID)
-					err = db.UpdateKeywordRecFile(d.ID, k.Keyword, k.Title, d.PID, k.FileTS, k.FileMP4HD, k.FileMP4SD, d.Station, d.Time, d.Drop, d.Scramble, d.Copy)
-					if err != nil {
-						return err
-					}
+		pids[d.PID] = true
+		k, exists := files[d.PID]
+		ci, fetched := tsInfo[d.PID]
+		if exists {
+			filesChanged := d.FileTS != k.FileTS || d.FileMP4HD != k.FileMP4HD || d.FileMP4SD != k.FileMP4SD
+			statusChanged := d.RecStatus != string(k.Status) || d.RecStatusText != k.StatusText
+			if k.Status == foltia.RecCompleted && (filesChanged || d.RecStatus != string(k.Status)) && !fetched {
+				continue
+			}
+			if filesChanged || statusChanged || tsChanged(d.Drop, d.Scramble, ci, fetched) {
+				prev := d
+				d.Keyword = k.Keyword
+				d.Title = k.Title
+				d.FileTS = k.FileTS
+				d.FileMP4HD = k.FileMP4HD
+				d.FileMP4SD = k.FileMP4SD
+				if d.RecStatus != string(k.Status) {
+					d.RecStatusAt = now
+				}
+				d.RecStatus = string(k.Status)
+				d.RecStatusText = k.StatusText
+				if fetched {
+					d.Drop = ci.Drop
+					d.Scramble = ci.Scramble
 				}
-				break
+				c := newKeywordRecFileChange(actionChange, d)
+				c.previous = &prev
+				p.KeywordRecFiles = append(p.KeywordRecFiles, c)
 			}
+			if fetched {
+				p.setTSSegments(ci)
+			}
+			continue
 		}
-		if !exists {
-			log.Printf("動画ファイルの情報を削除 : %s (%d)", d.Title, d.PID)
-			err = db.DeleteKeywordRecFile(d.ID)
-			if err != nil {
-				return err
+		if unknown[d.Keyword] && fetched {
+			if tsChanged(d.Drop, d.Scramble, ci, fetched) {
+				prev := d
+				d.Drop = ci.Drop
+				d.Scramble = ci.Scramble
+				c := newKeywordRecFileChange(actionChange, d)
+				c.previous = &prev
+				p.KeywordRecFiles = append(p.KeywordRecFiles, c)
 			}
+			p.setTSSegments(ci)
+			continue
+		}
+		if !unknown[d.Keyword] {
+			d.Status = db.StatusRemoved
+			d.RemovedAt = &now
+			p.KeywordRecFiles = append(p.KeywordRecFiles, newKeywordRecFileChange(actionRemove, d))
 		}
 	}
-	return nil
-}
-
-func insertNewKeywordRecFile(krfil []keywordRecFileInfo) error {
-	bar := pb.ProgressBarTemplate(barTemp).Start(len(krfil))
+	tombstones := map[int]db.KeywordRecFile{}
+	for _, r := range removed {
+		tombstones[r.PID] = r
+	}
 	for _, k := range krfil {
-		exists, err := checkKeywordRecFile(k.PID)
-		if err != nil {
-			return err
+		ci, ok := tsInfo[k.PID]
+		if pids[k.PID] || k.Status == foltia.RecCompleted && !ok {
+			continue
 		}
-		if !exists {
-			dr, sc, err := getTSInfo(k.PID)
-			if err != nil {
-				return err
-			}
-			err = db.InsertKeywordRecFile(k.Keyword, k.Title, k.PID, k.FileTS, k.FileMP4HD, k.FileMP4SD, k.Station, k.Time, dr, sc, false)
-			if err != nil {
-				return err
-			}
+		pids[k.PID] = true
+		// A restored recording keeps Copy so that it is not copied twice
+		r, restored := tombstones[k.PID]
+		r.Keyword = k.Keyword
+		r.Title = k.Title
+		r.PID = k.PID
+		r.FileTS = k.FileTS
+		r.FileMP4HD = k.FileMP4HD
+		r.FileMP4SD = k.FileMP4SD
+		r.Station = k.Station
+		r.Time = k.Time
+		r.Status = db.StatusPresent
+		r.RemovedAt = nil
+		r.RecStatus = string(k.Status)
+		r.RecStatusText = k.StatusText
+		r.RecStatusAt = now
+		if ok {
+			r.Drop = ci.Drop
+			r.Scramble = ci.Scramble
+			p.setTSSegments(ci)
 		}
-		bar.Increment()
-	}
-	bar.Finish()
-	return nil
-}
-
-func checkKeywordRecFile(pid int) (bool, error) {
-	data, err := db.GetAllKeywordRecFile()
-	if err != nil {
-		return false, err
-	}
-	for _, d := range data {
-		if d.PID == pid {
-			return true, nil
+		if restored {
+			p.KeywordRecFiles = append(p.KeywordRecFiles, newKeywordRecFileChange(actionRestore, r))
+		} else {
+			p.KeywordRecFiles = append(p.KeywordRecFiles, newKeywordRecFileChange(actionAdd, r))
 		}
 	}
-	return false, nil
+	return nil
 }
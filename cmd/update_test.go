@@ -0,0 +1,260 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/liebe-magi/falko/db"
+	"github.com/liebe-magi/falko/foltia"
+)
+
+func TestUpdate(t *testing.T) {
+	setTestEnv(t)
+	p := runUpdate(t, false)
+	if p.count() == 0 {
+		t.Fatalf("the first update has no changes")
+	}
+
+	tl, err := store.GetAllTitle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[int]db.AnimeTitle{}
+	for _, d := range tl {
+		titles[d.TID] = d
+	}
+	for tid, active := range map[int]bool{1730: true, 5500: true, 5800: false} {
+		if d, ok := titles[tid]; !ok || d.Active != active {
+			t.Errorf("title %d = %+v, want active %t", tid, d, active)
+		}
+	}
+	if d := titles[1730]; d.Title != "とある科学の超電磁砲T" || d.TitleYomi != "とあるかがくのれーるがんT" || d.Year != 2020 {
+		t.Errorf("title 1730 = %+v", d)
+	}
+
+	el, err := store.GetAllEpisode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type episode struct {
+		epTitle string
+		source  string
+		special bool
+	}
+	wantEpisodes := map[episodeKey]episode{
+		{1730, 1}:     {"超電磁砲", db.EpSourceFoltia, false},
+		{1730, 2}:     {"ふたり", db.EpSourceFoltia, false},
+		{1730, -5001}: {"特別編", db.EpSourceSyoboi, true},
+		{5500, 1}:     {"", db.EpSourceFoltia, false},
+	}
+	if len(el) != len(wantEpisodes) {
+		t.Errorf("%d episodes, want %d", len(el), len(wantEpisodes))
+	}
+	for _, e := range el {
+		got := episode{e.EpTitle, e.EpSource, e.Special}
+		if w := wantEpisodes[episodeKey{e.TID, e.EpNum}]; got != w || e.CopyStatus {
+			t.Errorf("episode %d:%d = %+v (copied %t), want %+v", e.TID, e.EpNum, got, e.CopyStatus, w)
+		}
+	}
+
+	vl, err := store.GetAllVideoFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type video struct {
+		tid       int
+		epNum     int
+		station   string
+		fileTS    string
+		drop      int
+		recStatus foltia.RecStatus
+	}
+	wantVideos := map[int]video{
+		100001: {1730, 1, "BS11イレブン", "1730-1-20200516-2300.m2t", 0, foltia.RecCompleted},
+		100002: {1730, 1, "TOKYO MX", "1730-1-20200517-0130.m2t", 15, foltia.RecCompleted},
+		100003: {1730, -5001, "BS11イレブン", "1730-0-20200530-2300.m2t", 0, foltia.RecCompleted},
+		100004: {1730, 2, "BS11イレブン", "1730-2-20200523-2300.m2t", 0, foltia.RecEncoding},
+		200001: {5500, 1, "テレビ東京", "5500-1-20200531-2100.m2t", 0, foltia.RecCompleted},
+	}
+	if len(vl) != len(wantVideos) {
+		t.Errorf("%d video files, want %d", len(vl), len(wantVideos))
+	}
+	for _, v := range vl {
+		got := video{v.TID, v.EpNum, v.Station, v.FileTS, v.Drop, foltia.RecStatus(v.RecStatus)}
+		if w := wantVideos[v.PID]; got != w {
+			t.Errorf("video file %d = %+v, want %+v", v.PID, got, w)
+		}
+	}
+	sl, err := store.GetTSSegments(100002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 2 || sl[0].Name != "0x0100" || sl[0].Drop != 12 || sl[1].Name != "0x0110" || sl[1].Drop != 3 {
+		t.Errorf("TS segments of 100002 = %+v", sl)
+	}
+
+	kl, err := store.GetAllKeywordRecFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kl) != 1 || kl[0].PID != 300001 || kl[0].Keyword != "声優" || kl[0].Title != "声優特番スペシャル" || kl[0].Scramble != 2 || kl[0].Copy {
+		t.Errorf("keyword recordings = %+v", kl)
+	}
+
+	since, err := store.GetSyncState(db.SyncSyoboiLastUpdate)
+	if err != nil || since != "2020-06-01 12:00:00" {
+		t.Errorf("Syoboi watermark = %q, %v", since, err)
+	}
+	fl, err := store.GetAllFingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fps := map[int]db.TitleFingerprint{}
+	for _, f := range fl {
+		fps[f.TID] = f
+	}
+	if f := fps[1730]; len(fl) != 2 || f.Files != 4 || f.Completed != 3 {
+		t.Errorf("fingerprints = %+v", fl)
+	}
+	hl, err := store.GetHistory(db.HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hl) != 6 {
+		t.Errorf("%d history events, want 6", len(hl))
+	}
+	for _, h := range hl {
+		if h.Event != db.EventAppeared {
+			t.Errorf("history event of %d = %s, want %s", h.PID, h.Event, db.EventAppeared)
+		}
+	}
+
+	p = runUpdate(t, false)
+	if p.count() != 0 {
+		t.Errorf("the second update has %d changes, want none", p.count())
+	}
+}
+
+func TestUpdateRemoveAndRestore(t *testing.T) {
+	s := setTestEnv(t)
+	runUpdate(t, false)
+	before, err := store.GetVideoFileByPID(200001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const page = "/recorded/recfiles_tid.php?mode=detail&tid=5500"
+	s.SetPage(page, `<html><body><ul id="libraryDetail"></ul></body></html>`)
+	runUpdate(t, true)
+	v, err := store.GetVideoFileByPID(200001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != db.StatusRemoved || v.RemovedAt == nil || v.ID != before.ID {
+		t.Errorf("removed video file = %+v", v)
+	}
+	vl, err := store.GetVideoFilesByTID(5500)
+	if err != nil || len(vl) != 0 {
+		t.Errorf("present video files of 5500 = %+v, %v", vl, err)
+	}
+
+	body, err := os.ReadFile("../foltia/foltiatest/fixtures/recfiles_tid_5500.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetPage(page, string(body))
+	runUpdate(t, true)
+	v, err = store.GetVideoFileByPID(200001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != db.StatusPresent || v.RemovedAt != nil || v.ID != before.ID {
+		t.Errorf("restored video file = %+v", v)
+	}
+	hl, err := store.GetHistory(db.HistoryQuery{PID: 200001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, h := range hl {
+		events = append(events, h.Event)
+	}
+	if len(events) != 3 || events[0] != db.EventRestored || events[1] != db.EventRemoved || events[2] != db.EventAppeared {
+		t.Errorf("history events of 200001 = %v", events)
+	}
+}
+
+func TestUpdateEpisodeNotFound(t *testing.T) {
+	setTestEnv(t)
+	f := sc.(*fakeSyoboi)
+	f.programs = nil
+	runUpdate(t, false)
+	v, err := store.GetVideoFileByPID(100003)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.EpNum != -1 || v.EpSource != db.EpSourceNotFound {
+		t.Errorf("video file 100003 = %d (%s), want -1 (%s)", v.EpNum, v.EpSource, db.EpSourceNotFound)
+	}
+	if n := f.lookups.Load(); n != 1 {
+		t.Errorf("%d lookups, want 1", n)
+	}
+
+	f.lookups.Store(0)
+	runUpdate(t, true)
+	if n := f.lookups.Load(); n != 0 {
+		t.Errorf("%d lookups of the recording already looked up, want 0", n)
+	}
+}
+
+func BenchmarkPlanUpdate(b *testing.B) {
+	setBenchEnv(b)
+	runUpdate(b, true)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p, err := planUpdate(ctx, store.Repos(), true, tsRefresh{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if p.count() != 0 {
+			b.Fatalf("%d changes, want none", p.count())
+		}
+	}
+}
+
+func BenchmarkApplyUpdate(b *testing.B) {
+	setBenchEnv(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		openTestDB(b)
+		p, err := planUpdate(ctx, store.Repos(), true, tsRefresh{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		err = applyUpdate(store.Repos(), p)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
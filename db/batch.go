@@ -0,0 +1,167 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Tx is a transaction of the Store
+type Tx struct {
+	db *gorm.DB
+}
+
+// Transaction : Run f in a transaction and commit it if f succeeds
+func (s *Store) Transaction(f func(tx *Tx) error) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := f(&Tx{db: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// ApplyBatch : Apply the writes of f in a transaction
+func (s *Store) ApplyBatch(f func(b Batch) error) error {
+	return s.Transaction(func(tx *Tx) error {
+		return f(tx)
+	})
+}
+
+// UpsertTitles : Insert data to Title DB, or update the title which has the same TID
+func (t *Tx) UpsertTitles(tl []AnimeTitle) error {
+	for i := range tl {
+		if err := t.upsert(&tl[i], []string{"t_id"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTitles : Update data of Title DB
+func (t *Tx) SaveTitles(tl []AnimeTitle) error {
+	for i := range tl {
+		if err := t.db.Save(&tl[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertEpisodes : Insert data to Episode DB, or update the episode which has the same TID and EpNum.
+// The copy status of the existing episode is kept.
+func (t *Tx) UpsertEpisodes(el []AnimeEpisode) error {
+	for i := range el {
+		if err := t.upsert(&el[i], []string{"t_id", "ep_num"}, "copy_status"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertVideoFiles : Insert data to VideoFile DB, or update the recording which has the same PID
+func (t *Tx) UpsertVideoFiles(vl []VideoFile) error {
+	for i := range vl {
+		if err := t.upsert(&vl[i], []string{"p_id"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveVideoFiles : Update data of VideoFile DB
+func (t *Tx) SaveVideoFiles(vl []VideoFile) error {
+	for i := range vl {
+		if err := t.db.Save(&vl[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTSSegments : Replace TS packet information of the recording in VideoFile DB
+func (t *Tx) SetTSSegments(pid int, sl []TSSegment) error {
+	err := t.db.Unscoped().Where("p_id = ?", pid).Delete(&TSSegment{}).Error
+	if err != nil {
+		return err
+	}
+	for i := range sl {
+		sl[i].PID = pid
+		if err := t.db.Create(&sl[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertKeywordRecFiles : Insert data to KeywordRecFile DB, or update the recording which has the same PID.
+// The copy status of the existing recording is kept.
+func (t *Tx) UpsertKeywordRecFiles(kl []KeywordRecFile) error {
+	for i := range kl {
+		if err := t.upsert(&kl[i], []string{"p_id"}, "copy"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveKeywordRecFiles : Update data of KeywordRecFile DB
+func (t *Tx) SaveKeywordRecFiles(kl []KeywordRecFile) error {
+	for i := range kl {
+		if err := t.db.Save(&kl[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertHistoryEvents : Insert data to History DB
+func (t *Tx) InsertHistoryEvents(hl []HistoryEvent) error {
+	for i := range hl {
+		if err := t.db.Create(&hl[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSyncState : Set the value of the key to Sync DB
+func (t *Tx) SetSyncState(key string, value string) error {
+	return t.upsert(&SyncState{Key: key, Value: value}, []string{"key"})
+}
+
+// SetFingerprints : Insert or update the fingerprints of the titles in Sync DB
+func (t *Tx) SetFingerprints(fl []TitleFingerprint) error {
+	for _, f := range fl {
+		e := TitleFingerprint{TID: f.TID, Files: f.Files, Completed: f.Completed, Latest: f.Latest}
+		if err := t.upsert(&e, []string{"t_id"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFingerprints : Delete the fingerprints of the titles from Sync DB
+func (t *Tx) DeleteFingerprints(tids []int) error {
+	if len(tids) == 0 {
+		return nil
+	}
+	return t.db.Unscoped().Where("t_id IN (?)", tids).Delete(&TitleFingerprint{}).Error
+}
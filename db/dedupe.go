@@ -0,0 +1,177 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrDuplicates is returned by the migration which adds the unique indexes when the database has duplicated rows
+var ErrDuplicates = errors.New("重複したデータがあるため一意制約を追加できません。falko db dedupe を実行して下さい")
+
+// Duplicate is a struct of rows which have the same unique key.
+// Keep is the ID of the row left by Dedupe and Remove are the IDs of the others.
+type Duplicate struct {
+	Table  string
+	Key    string
+	Keep   uint
+	Remove []uint
+	merge  string
+}
+
+// uniqueKeys are the unique keys added to the tables which had no constraint.
+// Present is the condition of the row to be preferred and Merge is the flag kept if any of the duplicates has it.
+var uniqueKeys = []struct {
+	Table   string
+	Column  string
+	Label   string
+	Present string
+	Merge   string
+}{
+	{"video_files", "p_id", "PID", `"status" = 'present'`, ""},
+	{"keyword_rec_files", "p_id", "PID", `"status" = 'present'`, "copy"},
+	{"sync_states", "key", "キー", "1", ""},
+	{"title_fingerprints", "t_id", "TID", "1", ""},
+}
+
+// dupRow is a row of the duplicated key
+type dupRow struct {
+	ID        uint
+	Key       string
+	Live      bool
+	Present   bool
+	UpdatedAt time.Time
+	Merge     bool
+}
+
+// FindDuplicates : Get the rows which have the same PID, key or TID, and which one Dedupe keeps.
+// The row kept is the one which is not deleted, is present in foltia ANIME LOCKER and was updated most recently.
+func (s *Store) FindDuplicates() ([]Duplicate, error) {
+	return findDuplicates(&Tx{db: s.db})
+}
+
+func findDuplicates(t *Tx) ([]Duplicate, error) {
+	var dl []Duplicate
+	for _, u := range uniqueKeys {
+		mergeCol := "0"
+		if u.Merge != "" {
+			mergeCol = fmt.Sprintf("%q", u.Merge)
+		}
+		q := fmt.Sprintf(`SELECT id, CAST(%[2]q AS TEXT), deleted_at IS NULL, %[3]s, updated_at, %[4]s FROM %[1]q
+			WHERE %[2]q IN (SELECT %[2]q FROM %[1]q GROUP BY %[2]q HAVING COUNT(*) > 1) ORDER BY %[2]q, id`, u.Table, u.Column, u.Present, mergeCol)
+		rows, err := t.db.Raw(q).Rows()
+		if err != nil {
+			return []Duplicate{}, err
+		}
+		groups := map[string][]dupRow{}
+		var keys []string
+		for rows.Next() {
+			var r dupRow
+			err = rows.Scan(&r.ID, &r.Key, &r.Live, &r.Present, &r.UpdatedAt, &r.Merge)
+			if err != nil {
+				rows.Close()
+				return []Duplicate{}, err
+			}
+			if _, ok := groups[r.Key]; !ok {
+				keys = append(keys, r.Key)
+			}
+			groups[r.Key] = append(groups[r.Key], r)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return []Duplicate{}, err
+		}
+		for _, k := range keys {
+			g := groups[k]
+			sort.SliceStable(g, func(i, j int) bool {
+				if g[i].Live != g[j].Live {
+					return g[i].Live
+				}
+				if g[i].Present != g[j].Present {
+					return g[i].Present
+				}
+				if !g[i].UpdatedAt.Equal(g[j].UpdatedAt) {
+					return g[i].UpdatedAt.After(g[j].UpdatedAt)
+				}
+				return g[i].ID > g[j].ID
+			})
+			d := Duplicate{Table: u.Table, Key: u.Label + ":" + k, Keep: g[0].ID}
+			for _, r := range g {
+				if r.Merge {
+					d.merge = u.Merge
+				}
+				if r.ID != g[0].ID {
+					d.Remove = append(d.Remove, r.ID)
+				}
+			}
+			dl = append(dl, d)
+		}
+	}
+	return dl, nil
+}
+
+// Dedupe : Delete the duplicated rows found by FindDuplicates in a transaction and return them.
+// The copy status of a keyword recording is kept if any of the duplicates has been copied.
+func (s *Store) Dedupe() ([]Duplicate, error) {
+	var dl []Duplicate
+	err := s.Transaction(func(t *Tx) error {
+		var err error
+		dl, err = findDuplicates(t)
+		if err != nil {
+			return err
+		}
+		for _, d := range dl {
+			if d.merge != "" {
+				err = t.db.Exec(fmt.Sprintf("UPDATE %q SET %q = 1 WHERE id = ?", d.Table, d.merge), d.Keep).Error
+				if err != nil {
+					return err
+				}
+			}
+			err = t.db.Exec(fmt.Sprintf("DELETE FROM %q WHERE id IN (?)", d.Table), d.Remove).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return []Duplicate{}, err
+	}
+	return dl, nil
+}
+
+// addUniqueKeys is the migration which replaces the indexes of the unique keys with unique ones
+func addUniqueKeys(t *Tx) error {
+	dl, err := findDuplicates(t)
+	if err != nil {
+		return err
+	}
+	if len(dl) > 0 {
+		return fmt.Errorf("%w (%d件)", ErrDuplicates, len(dl))
+	}
+	return execAll(
+		`DROP INDEX IF EXISTS idx_video_files_p_id`,
+		`CREATE UNIQUE INDEX uix_video_files_p_id ON "video_files"(p_id)`,
+		`DROP INDEX IF EXISTS idx_keyword_rec_files_p_id`,
+		`CREATE UNIQUE INDEX uix_keyword_rec_files_p_id ON "keyword_rec_files"(p_id)`,
+		`CREATE UNIQUE INDEX uix_sync_states_key ON "sync_states"("key")`,
+		`CREATE UNIQUE INDEX uix_title_fingerprints_t_id ON "title_fingerprints"(t_id)`,
+	)(t.db)
+}
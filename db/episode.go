@@ -17,124 +17,101 @@ limitations under the License.
 package db
 
 import (
-	"path/filepath"
+	"fmt"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/mattn/go-sqlite3"
-	homedir "github.com/mitchellh/go-homedir"
 )
 
-// AnimeEpisode is a struct of anime episode
+// AnimeEpisode is a struct of anime episode.
+// EpSource is where EpNum came from and Special is true for a special without episode number.
+// It belongs to the title of TID and is unique by TID and EpNum.
 type AnimeEpisode struct {
 	gorm.Model
-	TID        int
-	EpNum      int
+	TID        int `gorm:"unique_index:uix_anime_episodes_t_id_ep_num"`
+	EpNum      int `gorm:"unique_index:uix_anime_episodes_t_id_ep_num"`
 	EpTitle    string
+	EpSource   string
+	Special    bool
 	CopyStatus bool
 }
 
-// InitEpisodeDB : Initialize Episode DB
-func InitEpisodeDB() error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_episode.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.AutoMigrate(&AnimeEpisode{})
-	return nil
-}
+// Sources of the episode number
+const (
+	// EpSourceFoltia is the number shown in foltia ANIME LOCKER
+	EpSourceFoltia = "foltia"
+	// EpSourceSyoboi is the number resolved from the program in Syoboi Calendar
+	EpSourceSyoboi = "syoboi"
+	// EpSourceUnresolved is -1 which could not be resolved
+	EpSourceUnresolved = "unresolved"
+	// EpSourceNotFound is -1 which has no program in Syoboi Calendar. It is not looked up again.
+	EpSourceNotFound = "notfound"
+)
 
 // InsertEpisode : Insert data to Episode DB
-func InsertEpisode(tid int, epnum int, eptitle string, copyStatus bool) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_episode.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.Create(&AnimeEpisode{TID: tid, EpNum: epnum, EpTitle: eptitle, CopyStatus: copyStatus})
-	return nil
+func (s *Store) InsertEpisode(tid int, epnum int, eptitle string, copyStatus bool) error {
+	return s.db.Create(&AnimeEpisode{TID: tid, EpNum: epnum, EpTitle: eptitle, CopyStatus: copyStatus}).Error
 }
 
 // UpdateEpisode : Update data of Episode DB
-func UpdateEpisode(id uint, tid int, epnum int, eptitle string, copyStatus bool) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_episode.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) UpdateEpisode(id uint, tid int, epnum int, eptitle string, copyStatus bool) error {
+	var ep AnimeEpisode
+	err := first(s.db, &ep, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var ep AnimeEpisode
-	db.First(&ep, id)
 	ep.TID = tid
 	ep.EpNum = epnum
 	ep.EpTitle = eptitle
 	ep.CopyStatus = copyStatus
-	db.Save(&ep)
-	return nil
+	return s.db.Save(&ep).Error
 }
 
 // DeleteEpisode : Delete data of Episode DB
-func DeleteEpisode(id uint) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_episode.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) DeleteEpisode(id uint) error {
+	var ep AnimeEpisode
+	err := first(s.db, &ep, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var ep AnimeEpisode
-	db.First(&ep, id)
-	db.Delete(&ep)
-	return nil
+	return s.db.Delete(&ep).Error
 }
 
 // GetAllEpisode : Get All Data from Episode DB
-func GetAllEpisode() ([]AnimeEpisode, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		return []AnimeEpisode{}, err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_episode.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) GetAllEpisode() ([]AnimeEpisode, error) {
+	var epl []AnimeEpisode
+	err := s.db.Order("created_at desc").Find(&epl).Error
 	if err != nil {
 		return []AnimeEpisode{}, err
 	}
-	defer db.Close()
-	var epl []AnimeEpisode
-	db.Order("created_at desc").Find(&epl)
 	return epl, nil
 }
 
 // GetOneEpisode : Get Data from Episode DB
-func GetOneEpisode(id uint) (AnimeEpisode, error) {
-	home, err := homedir.Dir()
+func (s *Store) GetOneEpisode(id uint) (AnimeEpisode, error) {
+	var ep AnimeEpisode
+	err := first(s.db, &ep, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return AnimeEpisode{}, err
 	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_episode.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+	return ep, nil
+}
+
+// GetEpisodesByTID : Get Data of the TID from Episode DB in order of EpNum
+func (s *Store) GetEpisodesByTID(tid int) ([]AnimeEpisode, error) {
+	var epl []AnimeEpisode
+	err := s.db.Where("t_id = ?", tid).Order("ep_num").Find(&epl).Error
 	if err != nil {
-		return AnimeEpisode{}, err
+		return []AnimeEpisode{}, err
 	}
-	defer db.Close()
+	return epl, nil
+}
+
+// GetEpisodeByTIDEpNum : Get Data of the TID and EpNum from Episode DB
+func (s *Store) GetEpisodeByTIDEpNum(tid int, epnum int) (AnimeEpisode, error) {
 	var ep AnimeEpisode
-	db.First(&ep, id)
+	err := first(s.db.Where("t_id = ? AND ep_num = ?", tid, epnum), &ep, fmt.Sprintf("TID:%d, EpNum:%d", tid, epnum))
+	if err != nil {
+		return AnimeEpisode{}, err
+	}
 	return ep, nil
 }
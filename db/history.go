@@ -0,0 +1,83 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// HistoryEvent is a struct of a change of a recording found by update.
+// TID and EpNum are 0 for keyword recordings.
+type HistoryEvent struct {
+	gorm.Model
+	Kind    string
+	Event   string
+	PID     int `gorm:"index"`
+	TID     int `gorm:"index"`
+	EpNum   int
+	Keyword string
+	Title   string
+	Station string
+	Detail  string
+}
+
+// Kinds of HistoryEvent
+const (
+	KindVideoFile      = "video"
+	KindKeywordRecFile = "keyword"
+)
+
+// Events of HistoryEvent
+const (
+	EventAppeared = "appeared"
+	EventChanged  = "changed"
+	EventRemoved  = "removed"
+	EventRestored = "restored"
+)
+
+// HistoryQuery is a struct of conditions of GetHistory. Zero values match all events.
+type HistoryQuery struct {
+	PID   int
+	TID   int
+	Since time.Time
+	Limit int
+}
+
+// GetHistory : Get the events matching the query from History DB, newest first
+func (s *Store) GetHistory(q HistoryQuery) ([]HistoryEvent, error) {
+	db := s.db
+	if q.PID != 0 {
+		db = db.Where("p_id = ?", q.PID)
+	}
+	if q.TID != 0 {
+		db = db.Where("t_id = ?", q.TID)
+	}
+	if !q.Since.IsZero() {
+		db = db.Where("created_at >= ?", q.Since)
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+	var hl []HistoryEvent
+	err := db.Order("created_at desc, id desc").Find(&hl).Error
+	if err != nil {
+		return []HistoryEvent{}, err
+	}
+	return hl, nil
+}
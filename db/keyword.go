@@ -18,80 +18,50 @@ package db
 
 import (
 	"fmt"
-	"path/filepath"
 	"time"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/mattn/go-sqlite3"
-	homedir "github.com/mitchellh/go-homedir"
 )
 
-// KeywordRecFile is a struct of video file
+// KeywordRecFile is a struct of video file.
+// A recording removed from foltia ANIME LOCKER is kept with StatusRemoved and RemovedAt.
+// RecStatus is the status of the recording in foltia ANIME LOCKER and RecStatusAt is when the status was first seen.
 type KeywordRecFile struct {
 	gorm.Model
-	Keyword   string
-	Title     string
-	PID       int
-	FileTS    string
-	FileMP4HD string
-	FileMP4SD string
-	Station   string
-	Time      time.Time
-	Drop      int
-	Scramble  int
-	Copy      bool
+	Keyword       string
+	Title         string
+	PID           int `gorm:"unique_index"`
+	FileTS        string
+	FileMP4HD     string
+	FileMP4SD     string
+	Station       string
+	Time          time.Time
+	Drop          int
+	Scramble      int
+	Copy          bool
+	Status        string `gorm:"index;default:'present'"`
+	RemovedAt     *time.Time
+	RecStatus     string `gorm:"default:'completed'"`
+	RecStatusText string
+	RecStatusAt   time.Time
 }
 
 func (v KeywordRecFile) String() string {
 	return fmt.Sprintf("Keyword: %s, Title: %s, PID : %d, Time : %s, Staiton : %s, FileTS : %s, FileMP4HD : %s, FileMP4SD : %s, Drop : %d, Scramble : %d, Copy : %t\n", v.Keyword, v.Title, v.PID, v.Time, v.Station, v.FileTS, v.FileMP4HD, v.FileMP4SD, v.Drop, v.Scramble, v.Copy)
 }
 
-// InitKeywordRecFileDB : Initialize KeywordRecFile DB
-func InitKeywordRecFileDB() error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_keyword.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.AutoMigrate(&KeywordRecFile{})
-	return nil
-}
-
 // InsertKeywordRecFile : Insert Data to KeywordRecFile DB
-func InsertKeywordRecFile(keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_keyword.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.Create(&KeywordRecFile{Keyword: keyword, Title: title, PID: pid, FileTS: filets, FileMP4HD: filemp4hd, FileMP4SD: filemp4sd, Station: station, Time: time, Drop: drop, Scramble: scramble, Copy: cp})
-	return nil
+func (s *Store) InsertKeywordRecFile(keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error {
+	return s.db.Create(&KeywordRecFile{Keyword: keyword, Title: title, PID: pid, FileTS: filets, FileMP4HD: filemp4hd, FileMP4SD: filemp4sd, Station: station, Time: time, Drop: drop, Scramble: scramble, Copy: cp}).Error
 }
 
 // UpdateKeywordRecFile : Update Data of KeywordRecFile DB
-func UpdateKeywordRecFile(id uint, keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_keyword.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) UpdateKeywordRecFile(id uint, keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error {
+	var krf KeywordRecFile
+	err := first(s.db, &krf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var krf KeywordRecFile
-	db.First(&krf, id)
 	krf.Keyword = keyword
 	krf.Title = title
 	krf.PID = pid
@@ -103,58 +73,55 @@ func UpdateKeywordRecFile(id uint, keyword string, title string, pid int, filets
 	krf.Drop = drop
 	krf.Scramble = scramble
 	krf.Copy = cp
-	db.Save(&krf)
-	return nil
+	return s.db.Save(&krf).Error
 }
 
 // DeleteKeywordRecFile : Delete Data of KeywordRecFile DB
-func DeleteKeywordRecFile(id uint) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_keyword.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) DeleteKeywordRecFile(id uint) error {
+	var krf KeywordRecFile
+	err := first(s.db, &krf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var krf KeywordRecFile
-	db.First(&krf, id)
-	db.Delete(&krf)
-	return nil
+	return s.db.Delete(&krf).Error
 }
 
-// GetAllKeywordRecFile : Get All Data from KeywordRecFile DB
-func GetAllKeywordRecFile() ([]KeywordRecFile, error) {
-	home, err := homedir.Dir()
+// GetAllKeywordRecFile : Get All Data of the present recordings from KeywordRecFile DB
+func (s *Store) GetAllKeywordRecFile() ([]KeywordRecFile, error) {
+	var krfl []KeywordRecFile
+	err := s.db.Where("status = ?", StatusPresent).Order("created_at desc").Find(&krfl).Error
 	if err != nil {
 		return []KeywordRecFile{}, err
 	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_keyword.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+	return krfl, nil
+}
+
+// GetRemovedKeywordRecFile : Get All Data of the recordings removed from foltia ANIME LOCKER from KeywordRecFile DB
+func (s *Store) GetRemovedKeywordRecFile() ([]KeywordRecFile, error) {
+	var krfl []KeywordRecFile
+	err := s.db.Where("status = ?", StatusRemoved).Order("removed_at desc").Find(&krfl).Error
 	if err != nil {
 		return []KeywordRecFile{}, err
 	}
-	defer db.Close()
-	var krfl []KeywordRecFile
-	db.Order("created_at desc").Find(&krfl)
 	return krfl, nil
 }
 
 // GetOneKeywordRecFile : Get Data from KeywordRecFile DB
-func GetOneKeywordRecFile(id uint) (KeywordRecFile, error) {
-	home, err := homedir.Dir()
+func (s *Store) GetOneKeywordRecFile(id uint) (KeywordRecFile, error) {
+	var krf KeywordRecFile
+	err := first(s.db, &krf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return KeywordRecFile{}, err
 	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_keyword.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+	return krf, nil
+}
+
+// GetKeywordRecFileByPID : Get Data of the PID from KeywordRecFile DB including the removed one
+func (s *Store) GetKeywordRecFileByPID(pid int) (KeywordRecFile, error) {
+	var krf KeywordRecFile
+	err := first(s.db.Where("p_id = ?", pid), &krf, fmt.Sprintf("PID:%d", pid))
 	if err != nil {
 		return KeywordRecFile{}, err
 	}
-	defer db.Close()
-	var krf KeywordRecFile
-	db.First(&krf, id)
 	return krf, nil
 }
@@ -0,0 +1,318 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Names of the DB files used by the older versions, which had one file per table group
+const (
+	legacyTitleFile     = "foltia_title.sqlite3"
+	legacyEpisodeFile   = "foltia_episode.sqlite3"
+	legacyVideoFileFile = "foltia_videofile.sqlite3"
+	legacyKeywordFile   = "foltia_keyword.sqlite3"
+	legacyNewAnimeFile  = "foltia_newanime.sqlite3"
+	legacySyncFile      = "foltia_sync.sqlite3"
+	legacyHistoryFile   = "foltia_history.sqlite3"
+)
+
+var legacyFiles = []string{legacyTitleFile, legacyEpisodeFile, legacyVideoFileFile, legacyKeywordFile, legacyNewAnimeFile, legacySyncFile, legacyHistoryFile}
+
+func hasLegacyFiles(dir string) bool {
+	for _, f := range legacyFiles {
+		if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readLegacy reads all rows of the table of out from the legacy DB file.
+// It does nothing if the file or the table does not exist.
+func readLegacy(dir string, file string, out interface{}) error {
+	path := filepath.Join(dir, file)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := gorm.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if !db.HasTable(out) {
+		return nil
+	}
+	return db.Unscoped().Order("id").Find(out).Error
+}
+
+// legacyData is a struct of all rows read from the legacy DB files
+type legacyData struct {
+	titles       []AnimeTitle
+	episodes     []AnimeEpisode
+	videoFiles   []VideoFile
+	segments     []TSSegment
+	keywordFiles []KeywordRecFile
+	newAnimes    []NewAnime
+	syncStates   []SyncState
+	fingerprints []TitleFingerprint
+	history      []HistoryEvent
+}
+
+func readLegacyFiles(dir string) (legacyData, error) {
+	var d legacyData
+	reads := []struct {
+		file string
+		out  interface{}
+	}{
+		{legacyTitleFile, &d.titles},
+		{legacyEpisodeFile, &d.episodes},
+		{legacyVideoFileFile, &d.videoFiles},
+		{legacyVideoFileFile, &d.segments},
+		{legacyKeywordFile, &d.keywordFiles},
+		{legacyNewAnimeFile, &d.newAnimes},
+		{legacySyncFile, &d.syncStates},
+		{legacySyncFile, &d.fingerprints},
+		{legacyHistoryFile, &d.history},
+	}
+	for _, r := range reads {
+		if err := readLegacy(dir, r.file, r.out); err != nil {
+			return legacyData{}, fmt.Errorf("%s : %w", r.file, err)
+		}
+	}
+	return d, nil
+}
+
+// importLegacyFiles creates the database at path from the legacy DB files in the same directory.
+// It is built in a temporary file and renamed at the end so that a failed import leaves nothing behind.
+// The legacy files are kept as they are.
+func importLegacyFiles(path string) error {
+	dir := filepath.Dir(path)
+	d, err := readLegacyFiles(dir)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".import"
+	os.Remove(tmp)
+	s, err := openFile(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = s.Migrate(0)
+	if err != nil {
+		s.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = s.Transaction(func(tx *Tx) error {
+		return d.insert(tx)
+	})
+	s.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	log.Printf("旧形式のDBファイルを%sに取り込みました (旧ファイルは削除して構いません)", path)
+	return nil
+}
+
+// insert inserts the legacy rows keeping their IDs.
+// Soft-deleted titles and episodes are dropped, and duplicated rows are merged so that they fit the unique indexes.
+// Rows whose title or episode does not exist are not imported since the foreign keys do not allow them.
+// Columns which the older files do not have get their default values.
+func (d legacyData) insert(tx *Tx) error {
+	titles := map[int]AnimeTitle{}
+	var tids []int
+	for _, t := range d.titles {
+		if t.DeletedAt != nil {
+			continue
+		}
+		e, ok := titles[t.TID]
+		if !ok {
+			tids = append(tids, t.TID)
+		}
+		if !ok || t.UpdatedAt.After(e.UpdatedAt) {
+			titles[t.TID] = t
+		}
+	}
+	for _, tid := range tids {
+		t := titles[tid]
+		if err := tx.db.Create(&t).Error; err != nil {
+			return err
+		}
+	}
+
+	episodes := map[episodeKey]*AnimeEpisode{}
+	var el []*AnimeEpisode
+	for i := range d.episodes {
+		e := d.episodes[i]
+		if e.DeletedAt != nil {
+			continue
+		}
+		if _, ok := titles[e.TID]; !ok {
+			log.Printf("タイトルが無いエピソードは取り込みません : TID:%d 話数:%d", e.TID, e.EpNum)
+			continue
+		}
+		key := episodeKey{e.TID, e.EpNum}
+		if p, ok := episodes[key]; ok {
+			p.CopyStatus = p.CopyStatus || e.CopyStatus
+			continue
+		}
+		episodes[key] = &e
+		el = append(el, &e)
+	}
+	for _, v := range d.videoFiles {
+		key := episodeKey{v.TID, v.EpNum}
+		if _, ok := episodes[key]; ok {
+			continue
+		}
+		if _, ok := titles[v.TID]; !ok {
+			continue
+		}
+		e := &AnimeEpisode{TID: v.TID, EpNum: v.EpNum, EpSource: v.EpSource}
+		episodes[key] = e
+		el = append(el, e)
+	}
+	for _, e := range el {
+		if err := tx.db.Create(e).Error; err != nil {
+			return err
+		}
+	}
+
+	videoFiles := map[int]int{}
+	var vl []int
+	for i := range d.videoFiles {
+		v := &d.videoFiles[i]
+		if _, ok := episodes[episodeKey{v.TID, v.EpNum}]; !ok {
+			log.Printf("タイトルが無い動画ファイルは取り込みません : PID:%d", v.PID)
+			continue
+		}
+		v.Status, v.RemovedAt, v.DeletedAt = legacyStatus(v.Status, v.RemovedAt, v.DeletedAt)
+		j, ok := videoFiles[v.PID]
+		if !ok {
+			vl = append(vl, i)
+		}
+		if !ok || preferLegacy(v.Status, v.UpdatedAt, d.videoFiles[j].Status, d.videoFiles[j].UpdatedAt) {
+			videoFiles[v.PID] = i
+		}
+	}
+	for _, i := range vl {
+		v := d.videoFiles[videoFiles[d.videoFiles[i].PID]]
+		if err := tx.db.Create(&v).Error; err != nil {
+			return err
+		}
+	}
+
+	keywordFiles := map[int]int{}
+	copied := map[int]bool{}
+	var kl []int
+	for i := range d.keywordFiles {
+		k := &d.keywordFiles[i]
+		k.Status, k.RemovedAt, k.DeletedAt = legacyStatus(k.Status, k.RemovedAt, k.DeletedAt)
+		copied[k.PID] = copied[k.PID] || k.Copy
+		j, ok := keywordFiles[k.PID]
+		if !ok {
+			kl = append(kl, i)
+		}
+		if !ok || preferLegacy(k.Status, k.UpdatedAt, d.keywordFiles[j].Status, d.keywordFiles[j].UpdatedAt) {
+			keywordFiles[k.PID] = i
+		}
+	}
+	for _, i := range kl {
+		k := d.keywordFiles[keywordFiles[d.keywordFiles[i].PID]]
+		k.Copy = copied[k.PID]
+		if err := tx.db.Create(&k).Error; err != nil {
+			return err
+		}
+	}
+
+	syncStates := map[string]SyncState{}
+	for _, st := range d.syncStates {
+		if e, ok := syncStates[st.Key]; !ok || st.UpdatedAt.After(e.UpdatedAt) {
+			syncStates[st.Key] = st
+		}
+	}
+	fingerprints := map[int]TitleFingerprint{}
+	for _, f := range d.fingerprints {
+		if e, ok := fingerprints[f.TID]; !ok || f.UpdatedAt.After(e.UpdatedAt) {
+			fingerprints[f.TID] = f
+		}
+	}
+
+	var rows []interface{}
+	for i := range d.segments {
+		rows = append(rows, &d.segments[i])
+	}
+	for i := range d.newAnimes {
+		rows = append(rows, &d.newAnimes[i])
+	}
+	for _, st := range syncStates {
+		st := st
+		rows = append(rows, &st)
+	}
+	for _, f := range fingerprints {
+		f := f
+		rows = append(rows, &f)
+	}
+	for i := range d.history {
+		rows = append(rows, &d.history[i])
+	}
+	for _, r := range rows {
+		if err := tx.db.Create(r).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preferLegacy reports whether the legacy recording a is kept rather than b which has the same PID.
+// The present one is preferred, and then the one updated most recently.
+func preferLegacy(aStatus string, aUpdated time.Time, bStatus string, bUpdated time.Time) bool {
+	if aStatus != bStatus {
+		return aStatus == StatusPresent
+	}
+	return aUpdated.After(bUpdated)
+}
+
+// legacyStatus converts the status of a recording in the legacy DB files.
+// The oldest versions soft-deleted the removed recordings instead of keeping them with StatusRemoved.
+func legacyStatus(status string, removedAt *time.Time, deletedAt *time.Time) (string, *time.Time, *time.Time) {
+	if deletedAt != nil {
+		return StatusRemoved, deletedAt, nil
+	}
+	if status == "" {
+		status = StatusPresent
+	}
+	return status, removedAt, nil
+}
+
+// episodeKey identifies an episode by TID and episode number
+type episodeKey struct {
+	TID   int
+	EpNum int
+}
@@ -0,0 +1,586 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a set of the repositories kept in memory instead of the database.
+// It keeps the unique keys of the tables like the database but does not check the foreign keys.
+type Memory struct {
+	mu        sync.Mutex
+	lastID    uint
+	titles    []AnimeTitle
+	episodes  []AnimeEpisode
+	videos    []VideoFile
+	keywords  []KeywordRecFile
+	newAnimes []NewAnime
+	states    []SyncState
+	fps       []TitleFingerprint
+	segments  []TSSegment
+	history   []HistoryEvent
+}
+
+// NewMemory : Create the empty repositories in memory
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Repos : Get the repositories backed by the memory
+func (m *Memory) Repos() Repos {
+	return Repos{Titles: m, Episodes: m, VideoFiles: m, KeywordRecs: m, NewAnimes: m, Sync: m, TSSegments: m, History: m, Updater: m}
+}
+
+// nextID returns the ID and the time of a new row
+func (m *Memory) nextID() (uint, time.Time) {
+	m.lastID++
+	return m.lastID, time.Now()
+}
+
+func errDuplicate(key string) error {
+	return fmt.Errorf("同じキーのデータが既にあります (%s)", key)
+}
+
+// newestFirst sorts the rows in the order of created_at desc like the database.
+// id is the ID of the i-th row, which increases in the order of creation.
+func newestFirst(rows interface{}, id func(i int) uint) {
+	sort.SliceStable(rows, func(i, j int) bool { return id(i) > id(j) })
+}
+
+// InsertTitle : Insert Data to Title repository
+func (m *Memory) InsertTitle(tid int, title string, yomi string, year int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.titleIndex(func(t AnimeTitle) bool { return t.TID == tid }) != -1 {
+		return errDuplicate(fmt.Sprintf("TID:%d", tid))
+	}
+	t := AnimeTitle{TID: tid, Title: title, TitleYomi: yomi, Year: year, Active: active}
+	t.ID, t.CreatedAt = m.nextID()
+	t.UpdatedAt = t.CreatedAt
+	m.titles = append(m.titles, t)
+	return nil
+}
+
+// UpdateTitle : Update Data of Title repository
+func (m *Memory) UpdateTitle(id uint, tid int, title string, yomi string, year int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.titleIndex(func(t AnimeTitle) bool { return t.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	if j := m.titleIndex(func(t AnimeTitle) bool { return t.TID == tid }); j != -1 && j != i {
+		return errDuplicate(fmt.Sprintf("TID:%d", tid))
+	}
+	t := &m.titles[i]
+	t.TID = tid
+	t.Title = title
+	t.TitleYomi = yomi
+	t.Year = year
+	t.Active = active
+	t.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeleteTitle : Delete Data of Title repository
+func (m *Memory) DeleteTitle(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.titleIndex(func(t AnimeTitle) bool { return t.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	m.titles = append(m.titles[:i], m.titles[i+1:]...)
+	return nil
+}
+
+// GetAllTitle : Get All Data from Title repository
+func (m *Memory) GetAllTitle() (AnimeTitleList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tl := append(AnimeTitleList{}, m.titles...)
+	newestFirst(tl, func(i int) uint { return tl[i].ID })
+	return tl, nil
+}
+
+// GetOneTitle : Get Data from Title repository
+func (m *Memory) GetOneTitle(id uint) (AnimeTitle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.titleIndex(func(t AnimeTitle) bool { return t.ID == id })
+	if i == -1 {
+		return AnimeTitle{}, notFound(fmt.Sprintf("ID:%d", id))
+	}
+	return m.titles[i], nil
+}
+
+// GetTitleByTID : Get Data of the TID from Title repository
+func (m *Memory) GetTitleByTID(tid int) (AnimeTitle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.titleIndex(func(t AnimeTitle) bool { return t.TID == tid })
+	if i == -1 {
+		return AnimeTitle{}, notFound(fmt.Sprintf("TID:%d", tid))
+	}
+	return m.titles[i], nil
+}
+
+func (m *Memory) titleIndex(match func(t AnimeTitle) bool) int {
+	for i, t := range m.titles {
+		if match(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertEpisode : Insert data to Episode repository
+func (m *Memory) InsertEpisode(tid int, epnum int, eptitle string, copyStatus bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.episodeIndex(func(e AnimeEpisode) bool { return e.TID == tid && e.EpNum == epnum }) != -1 {
+		return errDuplicate(fmt.Sprintf("TID:%d, EpNum:%d", tid, epnum))
+	}
+	ep := AnimeEpisode{TID: tid, EpNum: epnum, EpTitle: eptitle, CopyStatus: copyStatus}
+	ep.ID, ep.CreatedAt = m.nextID()
+	ep.UpdatedAt = ep.CreatedAt
+	m.episodes = append(m.episodes, ep)
+	return nil
+}
+
+// UpdateEpisode : Update data of Episode repository
+func (m *Memory) UpdateEpisode(id uint, tid int, epnum int, eptitle string, copyStatus bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.episodeIndex(func(e AnimeEpisode) bool { return e.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	if j := m.episodeIndex(func(e AnimeEpisode) bool { return e.TID == tid && e.EpNum == epnum }); j != -1 && j != i {
+		return errDuplicate(fmt.Sprintf("TID:%d, EpNum:%d", tid, epnum))
+	}
+	ep := &m.episodes[i]
+	ep.TID = tid
+	ep.EpNum = epnum
+	ep.EpTitle = eptitle
+	ep.CopyStatus = copyStatus
+	ep.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeleteEpisode : Delete data of Episode repository
+func (m *Memory) DeleteEpisode(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.episodeIndex(func(e AnimeEpisode) bool { return e.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	m.episodes = append(m.episodes[:i], m.episodes[i+1:]...)
+	return nil
+}
+
+// GetAllEpisode : Get All Data from Episode repository
+func (m *Memory) GetAllEpisode() ([]AnimeEpisode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	epl := m.findEpisodes(func(e AnimeEpisode) bool { return true })
+	newestFirst(epl, func(i int) uint { return epl[i].ID })
+	return epl, nil
+}
+
+// GetOneEpisode : Get Data from Episode repository
+func (m *Memory) GetOneEpisode(id uint) (AnimeEpisode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.episodeIndex(func(e AnimeEpisode) bool { return e.ID == id })
+	if i == -1 {
+		return AnimeEpisode{}, notFound(fmt.Sprintf("ID:%d", id))
+	}
+	return m.episodes[i], nil
+}
+
+// GetEpisodesByTID : Get Data of the TID from Episode repository in order of EpNum
+func (m *Memory) GetEpisodesByTID(tid int) ([]AnimeEpisode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	epl := m.findEpisodes(func(e AnimeEpisode) bool { return e.TID == tid })
+	sort.SliceStable(epl, func(i, j int) bool { return epl[i].EpNum < epl[j].EpNum })
+	return epl, nil
+}
+
+// GetEpisodeByTIDEpNum : Get Data of the TID and EpNum from Episode repository
+func (m *Memory) GetEpisodeByTIDEpNum(tid int, epnum int) (AnimeEpisode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.episodeIndex(func(e AnimeEpisode) bool { return e.TID == tid && e.EpNum == epnum })
+	if i == -1 {
+		return AnimeEpisode{}, notFound(fmt.Sprintf("TID:%d, EpNum:%d", tid, epnum))
+	}
+	return m.episodes[i], nil
+}
+
+func (m *Memory) episodeIndex(match func(e AnimeEpisode) bool) int {
+	for i, e := range m.episodes {
+		if match(e) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) findEpisodes(match func(e AnimeEpisode) bool) []AnimeEpisode {
+	epl := []AnimeEpisode{}
+	for _, e := range m.episodes {
+		if match(e) {
+			epl = append(epl, e)
+		}
+	}
+	return epl
+}
+
+// InsertVideoFile : Insert Data to VideoFile repository
+func (m *Memory) InsertVideoFile(tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.videoIndex(func(v VideoFile) bool { return v.PID == pid }) != -1 {
+		return errDuplicate(fmt.Sprintf("PID:%d", pid))
+	}
+	vf := VideoFile{TID: tid, EpNum: epnum, PID: pid, FileTS: filets, FileMP4HD: filemp4hd, FileMP4SD: filemp4sd, Station: station, Time: time, Drop: drop, Scramble: scramble, Status: StatusPresent, RecStatus: "completed"}
+	vf.ID, vf.CreatedAt = m.nextID()
+	vf.UpdatedAt = vf.CreatedAt
+	m.videos = append(m.videos, vf)
+	return nil
+}
+
+// UpdateVideoFile : Update Data of VideoFile repository
+func (m *Memory) UpdateVideoFile(id uint, tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.videoIndex(func(v VideoFile) bool { return v.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	if j := m.videoIndex(func(v VideoFile) bool { return v.PID == pid }); j != -1 && j != i {
+		return errDuplicate(fmt.Sprintf("PID:%d", pid))
+	}
+	vf := &m.videos[i]
+	vf.TID = tid
+	vf.EpNum = epnum
+	vf.PID = pid
+	vf.FileTS = filets
+	vf.FileMP4HD = filemp4hd
+	vf.FileMP4SD = filemp4sd
+	vf.Station = station
+	vf.Time = time
+	vf.Drop = drop
+	vf.Scramble = scramble
+	vf.UpdatedAt = now()
+	return nil
+}
+
+// DeleteVideoFile : Delete Data of VideoFile repository
+func (m *Memory) DeleteVideoFile(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.videoIndex(func(v VideoFile) bool { return v.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	m.videos = append(m.videos[:i], m.videos[i+1:]...)
+	return nil
+}
+
+// GetAllVideoFile : Get All Data of the present recordings from VideoFile repository
+func (m *Memory) GetAllVideoFile() ([]VideoFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vfl := m.findVideos(func(v VideoFile) bool { return v.Status == StatusPresent })
+	newestFirst(vfl, func(i int) uint { return vfl[i].ID })
+	return vfl, nil
+}
+
+// GetRemovedVideoFile : Get All Data of the recordings removed from foltia ANIME LOCKER from VideoFile repository
+func (m *Memory) GetRemovedVideoFile() ([]VideoFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vfl := m.findVideos(func(v VideoFile) bool { return v.Status == StatusRemoved })
+	sort.SliceStable(vfl, func(i, j int) bool { return removedAfter(vfl[i].RemovedAt, vfl[j].RemovedAt) })
+	return vfl, nil
+}
+
+// GetOneVideoFile : Get Data from VideoFile repository
+func (m *Memory) GetOneVideoFile(id uint) (VideoFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.videoIndex(func(v VideoFile) bool { return v.ID == id })
+	if i == -1 {
+		return VideoFile{}, notFound(fmt.Sprintf("ID:%d", id))
+	}
+	return m.videos[i], nil
+}
+
+// GetVideoFileByPID : Get Data of the PID from VideoFile repository including the removed one
+func (m *Memory) GetVideoFileByPID(pid int) (VideoFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.videoIndex(func(v VideoFile) bool { return v.PID == pid })
+	if i == -1 {
+		return VideoFile{}, notFound(fmt.Sprintf("PID:%d", pid))
+	}
+	return m.videos[i], nil
+}
+
+// GetVideoFilesByTID : Get Data of the TID present in foltia ANIME LOCKER from VideoFile repository
+func (m *Memory) GetVideoFilesByTID(tid int) ([]VideoFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vfl := m.findVideos(func(v VideoFile) bool { return v.TID == tid && v.Status == StatusPresent })
+	sort.SliceStable(vfl, func(i, j int) bool {
+		if vfl[i].EpNum != vfl[j].EpNum {
+			return vfl[i].EpNum < vfl[j].EpNum
+		}
+		return vfl[i].Time.Before(vfl[j].Time)
+	})
+	return vfl, nil
+}
+
+// GetVideoFilesByTIDEpNum : Get Data of the TID and EpNum present in foltia ANIME LOCKER from VideoFile repository
+func (m *Memory) GetVideoFilesByTIDEpNum(tid int, epnum int) ([]VideoFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vfl := m.findVideos(func(v VideoFile) bool { return v.TID == tid && v.EpNum == epnum && v.Status == StatusPresent })
+	sort.SliceStable(vfl, func(i, j int) bool { return vfl[i].Time.Before(vfl[j].Time) })
+	return vfl, nil
+}
+
+func (m *Memory) videoIndex(match func(v VideoFile) bool) int {
+	for i, v := range m.videos {
+		if match(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) findVideos(match func(v VideoFile) bool) []VideoFile {
+	vfl := []VideoFile{}
+	for _, v := range m.videos {
+		if match(v) {
+			vfl = append(vfl, v)
+		}
+	}
+	return vfl
+}
+
+// InsertKeywordRecFile : Insert Data to KeywordRecFile repository
+func (m *Memory) InsertKeywordRecFile(keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keywordIndex(func(k KeywordRecFile) bool { return k.PID == pid }) != -1 {
+		return errDuplicate(fmt.Sprintf("PID:%d", pid))
+	}
+	krf := KeywordRecFile{Keyword: keyword, Title: title, PID: pid, FileTS: filets, FileMP4HD: filemp4hd, FileMP4SD: filemp4sd, Station: station, Time: time, Drop: drop, Scramble: scramble, Copy: cp, Status: StatusPresent, RecStatus: "completed"}
+	krf.ID, krf.CreatedAt = m.nextID()
+	krf.UpdatedAt = krf.CreatedAt
+	m.keywords = append(m.keywords, krf)
+	return nil
+}
+
+// UpdateKeywordRecFile : Update Data of KeywordRecFile repository
+func (m *Memory) UpdateKeywordRecFile(id uint, keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.keywordIndex(func(k KeywordRecFile) bool { return k.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	if j := m.keywordIndex(func(k KeywordRecFile) bool { return k.PID == pid }); j != -1 && j != i {
+		return errDuplicate(fmt.Sprintf("PID:%d", pid))
+	}
+	krf := &m.keywords[i]
+	krf.Keyword = keyword
+	krf.Title = title
+	krf.PID = pid
+	krf.FileTS = filets
+	krf.FileMP4HD = filemp4hd
+	krf.FileMP4SD = filemp4sd
+	krf.Station = station
+	krf.Time = time
+	krf.Drop = drop
+	krf.Scramble = scramble
+	krf.Copy = cp
+	krf.UpdatedAt = now()
+	return nil
+}
+
+// DeleteKeywordRecFile : Delete Data of KeywordRecFile repository
+func (m *Memory) DeleteKeywordRecFile(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.keywordIndex(func(k KeywordRecFile) bool { return k.ID == id })
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	m.keywords = append(m.keywords[:i], m.keywords[i+1:]...)
+	return nil
+}
+
+// GetAllKeywordRecFile : Get All Data of the present recordings from KeywordRecFile repository
+func (m *Memory) GetAllKeywordRecFile() ([]KeywordRecFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	krfl := m.findKeywords(func(k KeywordRecFile) bool { return k.Status == StatusPresent })
+	newestFirst(krfl, func(i int) uint { return krfl[i].ID })
+	return krfl, nil
+}
+
+// GetRemovedKeywordRecFile : Get All Data of the recordings removed from foltia ANIME LOCKER from KeywordRecFile repository
+func (m *Memory) GetRemovedKeywordRecFile() ([]KeywordRecFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	krfl := m.findKeywords(func(k KeywordRecFile) bool { return k.Status == StatusRemoved })
+	sort.SliceStable(krfl, func(i, j int) bool { return removedAfter(krfl[i].RemovedAt, krfl[j].RemovedAt) })
+	return krfl, nil
+}
+
+// GetOneKeywordRecFile : Get Data from KeywordRecFile repository
+func (m *Memory) GetOneKeywordRecFile(id uint) (KeywordRecFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.keywordIndex(func(k KeywordRecFile) bool { return k.ID == id })
+	if i == -1 {
+		return KeywordRecFile{}, notFound(fmt.Sprintf("ID:%d", id))
+	}
+	return m.keywords[i], nil
+}
+
+// GetKeywordRecFileByPID : Get Data of the PID from KeywordRecFile repository including the removed one
+func (m *Memory) GetKeywordRecFileByPID(pid int) (KeywordRecFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.keywordIndex(func(k KeywordRecFile) bool { return k.PID == pid })
+	if i == -1 {
+		return KeywordRecFile{}, notFound(fmt.Sprintf("PID:%d", pid))
+	}
+	return m.keywords[i], nil
+}
+
+func (m *Memory) keywordIndex(match func(k KeywordRecFile) bool) int {
+	for i, k := range m.keywords {
+		if match(k) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) findKeywords(match func(k KeywordRecFile) bool) []KeywordRecFile {
+	krfl := []KeywordRecFile{}
+	for _, k := range m.keywords {
+		if match(k) {
+			krfl = append(krfl, k)
+		}
+	}
+	return krfl
+}
+
+// InsertNewAnime : Insert Data to NewAnime repository
+func (m *Memory) InsertNewAnime(tid int, title string, station string, time time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	na := NewAnime{TID: tid, Title: title, Station: station, Time: time}
+	na.ID, na.CreatedAt = m.nextID()
+	na.UpdatedAt = na.CreatedAt
+	m.newAnimes = append(m.newAnimes, na)
+	return nil
+}
+
+// UpdateNewAnime : Update Data of NewAnime repository
+func (m *Memory) UpdateNewAnime(id uint, tid int, title string, station string, time time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.newAnimeIndex(id)
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	na := &m.newAnimes[i]
+	na.TID = tid
+	na.Title = title
+	na.Station = station
+	na.Time = time
+	na.UpdatedAt = now()
+	return nil
+}
+
+// DeleteNewAnime : Delete Data of NewAnime repository
+func (m *Memory) DeleteNewAnime(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.newAnimeIndex(id)
+	if i == -1 {
+		return notFound(fmt.Sprintf("ID:%d", id))
+	}
+	m.newAnimes = append(m.newAnimes[:i], m.newAnimes[i+1:]...)
+	return nil
+}
+
+// GetAllNewAnime : Get All Data from NewAnime repository
+func (m *Memory) GetAllNewAnime() ([]NewAnime, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nal := append([]NewAnime{}, m.newAnimes...)
+	newestFirst(nal, func(i int) uint { return nal[i].ID })
+	return nal, nil
+}
+
+// GetOneNewAnime : Get Data from NewAnime repository
+func (m *Memory) GetOneNewAnime(id uint) (NewAnime, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.newAnimeIndex(id)
+	if i == -1 {
+		return NewAnime{}, notFound(fmt.Sprintf("ID:%d", id))
+	}
+	return m.newAnimes[i], nil
+}
+
+func (m *Memory) newAnimeIndex(id uint) int {
+	for i, na := range m.newAnimes {
+		if na.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// removedAfter reports whether a was removed after b for the order of removed_at desc
+func removedAfter(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a != nil
+	}
+	return a.After(*b)
+}
+
+// now is for the methods whose time parameter shadows the package
+func now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,369 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GetSyncState : Get the value of the key from Sync repository. It returns "" if the key is not set.
+func (m *Memory) GetSyncState(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, st := range m.states {
+		if st.Key == key {
+			return st.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// GetAllFingerprint : Get All Data of title fingerprints from Sync repository
+func (m *Memory) GetAllFingerprint() ([]TitleFingerprint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]TitleFingerprint{}, m.fps...), nil
+}
+
+// GetTSSegments : Get TS packet information of the recording from TSSegment repository
+func (m *Memory) GetTSSegments(pid int) ([]TSSegment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sl := []TSSegment{}
+	for _, s := range m.segments {
+		if s.PID == pid {
+			sl = append(sl, s)
+		}
+	}
+	return sl, nil
+}
+
+// GetHistory : Get the events matching the query from History repository, newest first
+func (m *Memory) GetHistory(q HistoryQuery) ([]HistoryEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hl := []HistoryEvent{}
+	for _, h := range m.history {
+		if q.PID != 0 && h.PID != q.PID || q.TID != 0 && h.TID != q.TID || !q.Since.IsZero() && h.CreatedAt.Before(q.Since) {
+			continue
+		}
+		hl = append(hl, h)
+	}
+	newestFirst(hl, func(i int) uint { return hl[i].ID })
+	if q.Limit > 0 && len(hl) > q.Limit {
+		hl = hl[:q.Limit]
+	}
+	return hl, nil
+}
+
+// newRow sets the ID and the time of a row saved when there is no row of its ID
+func (m *Memory) newRow(r *gorm.Model) {
+	if r.ID == 0 {
+		r.ID, r.CreatedAt = m.nextID()
+	} else {
+		if r.ID > m.lastID {
+			m.lastID = r.ID
+		}
+		r.CreatedAt = now()
+	}
+	r.UpdatedAt = r.CreatedAt
+}
+
+// videoDefaults sets the default values of the database to the empty columns of the recording
+func videoDefaults(v VideoFile) VideoFile {
+	if v.Status == "" {
+		v.Status = StatusPresent
+	}
+	if v.RecStatus == "" {
+		v.RecStatus = "completed"
+	}
+	return v
+}
+
+// keywordDefaults sets the default values of the database to the empty columns of the recording
+func keywordDefaults(k KeywordRecFile) KeywordRecFile {
+	if k.Status == "" {
+		k.Status = StatusPresent
+	}
+	if k.RecStatus == "" {
+		k.RecStatus = "completed"
+	}
+	return k
+}
+
+// memoryBatch is a batch writing the rows of Memory directly while ApplyBatch holds the lock
+type memoryBatch struct {
+	m *Memory
+}
+
+// ApplyBatch : Apply the writes of f, or restore the rows if f fails.
+// f must not use the repositories of m other than b.
+func (m *Memory) ApplyBatch(f func(b Batch) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lastID := m.lastID
+	titles := append([]AnimeTitle{}, m.titles...)
+	episodes := append([]AnimeEpisode{}, m.episodes...)
+	videos := append([]VideoFile{}, m.videos...)
+	keywords := append([]KeywordRecFile{}, m.keywords...)
+	states := append([]SyncState{}, m.states...)
+	fps := append([]TitleFingerprint{}, m.fps...)
+	segments := append([]TSSegment{}, m.segments...)
+	history := append([]HistoryEvent{}, m.history...)
+	err := f(memoryBatch{m: m})
+	if err != nil {
+		m.lastID = lastID
+		m.titles = titles
+		m.episodes = episodes
+		m.videos = videos
+		m.keywords = keywords
+		m.states = states
+		m.fps = fps
+		m.segments = segments
+		m.history = history
+	}
+	return err
+}
+
+// UpsertTitles : Insert data to Title repository, or update the title which has the same TID
+func (b memoryBatch) UpsertTitles(tl []AnimeTitle) error {
+	m := b.m
+	for i := range tl {
+		t := &tl[i]
+		j := m.titleIndex(func(d AnimeTitle) bool { return d.TID == t.TID })
+		if j == -1 {
+			t.ID, t.CreatedAt = m.nextID()
+			t.UpdatedAt = t.CreatedAt
+			m.titles = append(m.titles, *t)
+			continue
+		}
+		t.ID, t.CreatedAt, t.UpdatedAt = m.titles[j].ID, m.titles[j].CreatedAt, now()
+		m.titles[j] = *t
+	}
+	return nil
+}
+
+// SaveTitles : Update data of Title repository
+func (b memoryBatch) SaveTitles(tl []AnimeTitle) error {
+	m := b.m
+	for i := range tl {
+		t := &tl[i]
+		j := m.titleIndex(func(d AnimeTitle) bool { return d.ID == t.ID })
+		if k := m.titleIndex(func(d AnimeTitle) bool { return d.TID == t.TID }); k != -1 && k != j {
+			return errDuplicate(fmt.Sprintf("TID:%d", t.TID))
+		}
+		if j == -1 {
+			m.newRow(&t.Model)
+			m.titles = append(m.titles, *t)
+			continue
+		}
+		t.CreatedAt, t.UpdatedAt = m.titles[j].CreatedAt, now()
+		m.titles[j] = *t
+	}
+	return nil
+}
+
+// UpsertEpisodes : Insert data to Episode repository, or update the episode which has the same TID and EpNum.
+// The copy status of the existing episode is kept.
+func (b memoryBatch) UpsertEpisodes(el []AnimeEpisode) error {
+	m := b.m
+	for i := range el {
+		e := &el[i]
+		j := m.episodeIndex(func(d AnimeEpisode) bool { return d.TID == e.TID && d.EpNum == e.EpNum })
+		if j == -1 {
+			e.ID, e.CreatedAt = m.nextID()
+			e.UpdatedAt = e.CreatedAt
+			m.episodes = append(m.episodes, *e)
+			continue
+		}
+		e.ID = m.episodes[j].ID
+		row := *e
+		row.CreatedAt, row.UpdatedAt, row.CopyStatus = m.episodes[j].CreatedAt, now(), m.episodes[j].CopyStatus
+		m.episodes[j] = row
+	}
+	return nil
+}
+
+// UpsertVideoFiles : Insert data to VideoFile repository, or update the recording which has the same PID
+func (b memoryBatch) UpsertVideoFiles(vl []VideoFile) error {
+	m := b.m
+	for i := range vl {
+		v := &vl[i]
+		row := videoDefaults(*v)
+		j := m.videoIndex(func(d VideoFile) bool { return d.PID == v.PID })
+		if j == -1 {
+			row.ID, row.CreatedAt = m.nextID()
+			row.UpdatedAt = row.CreatedAt
+			m.videos = append(m.videos, row)
+		} else {
+			row.ID, row.CreatedAt, row.UpdatedAt = m.videos[j].ID, m.videos[j].CreatedAt, now()
+			m.videos[j] = row
+		}
+		v.ID = row.ID
+	}
+	return nil
+}
+
+// SaveVideoFiles : Update data of VideoFile repository
+func (b memoryBatch) SaveVideoFiles(vl []VideoFile) error {
+	m := b.m
+	for i := range vl {
+		v := &vl[i]
+		j := m.videoIndex(func(d VideoFile) bool { return d.ID == v.ID })
+		if k := m.videoIndex(func(d VideoFile) bool { return d.PID == v.PID }); k != -1 && k != j {
+			return errDuplicate(fmt.Sprintf("PID:%d", v.PID))
+		}
+		if j == -1 {
+			m.newRow(&v.Model)
+			m.videos = append(m.videos, videoDefaults(*v))
+			continue
+		}
+		v.CreatedAt, v.UpdatedAt = m.videos[j].CreatedAt, now()
+		m.videos[j] = *v
+	}
+	return nil
+}
+
+// SetTSSegments : Replace TS packet information of the recording in TSSegment repository
+func (b memoryBatch) SetTSSegments(pid int, sl []TSSegment) error {
+	m := b.m
+	kept := m.segments[:0:0]
+	for _, s := range m.segments {
+		if s.PID != pid {
+			kept = append(kept, s)
+		}
+	}
+	m.segments = kept
+	for i := range sl {
+		sl[i].PID = pid
+		sl[i].ID, sl[i].CreatedAt = m.nextID()
+		sl[i].UpdatedAt = sl[i].CreatedAt
+		m.segments = append(m.segments, sl[i])
+	}
+	return nil
+}
+
+// UpsertKeywordRecFiles : Insert data to KeywordRecFile repository, or update the recording which has the same PID.
+// The copy status of the existing recording is kept.
+func (b memoryBatch) UpsertKeywordRecFiles(kl []KeywordRecFile) error {
+	m := b.m
+	for i := range kl {
+		k := &kl[i]
+		row := keywordDefaults(*k)
+		j := m.keywordIndex(func(d KeywordRecFile) bool { return d.PID == k.PID })
+		if j == -1 {
+			row.ID, row.CreatedAt = m.nextID()
+			row.UpdatedAt = row.CreatedAt
+			m.keywords = append(m.keywords, row)
+		} else {
+			row.ID, row.CreatedAt, row.UpdatedAt, row.Copy = m.keywords[j].ID, m.keywords[j].CreatedAt, now(), m.keywords[j].Copy
+			m.keywords[j] = row
+		}
+		k.ID = row.ID
+	}
+	return nil
+}
+
+// SaveKeywordRecFiles : Update data of KeywordRecFile repository
+func (b memoryBatch) SaveKeywordRecFiles(kl []KeywordRecFile) error {
+	m := b.m
+	for i := range kl {
+		k := &kl[i]
+		j := m.keywordIndex(func(d KeywordRecFile) bool { return d.ID == k.ID })
+		if l := m.keywordIndex(func(d KeywordRecFile) bool { return d.PID == k.PID }); l != -1 && l != j {
+			return errDuplicate(fmt.Sprintf("PID:%d", k.PID))
+		}
+		if j == -1 {
+			m.newRow(&k.Model)
+			m.keywords = append(m.keywords, keywordDefaults(*k))
+			continue
+		}
+		k.CreatedAt, k.UpdatedAt = m.keywords[j].CreatedAt, now()
+		m.keywords[j] = *k
+	}
+	return nil
+}
+
+// InsertHistoryEvents : Insert data to History repository
+func (b memoryBatch) InsertHistoryEvents(hl []HistoryEvent) error {
+	m := b.m
+	for i := range hl {
+		hl[i].ID, hl[i].CreatedAt = m.nextID()
+		hl[i].UpdatedAt = hl[i].CreatedAt
+		m.history = append(m.history, hl[i])
+	}
+	return nil
+}
+
+// SetSyncState : Set the value of the key to Sync repository
+func (b memoryBatch) SetSyncState(key string, value string) error {
+	m := b.m
+	for i := range m.states {
+		if m.states[i].Key == key {
+			m.states[i].Value = value
+			m.states[i].UpdatedAt = now()
+			return nil
+		}
+	}
+	st := SyncState{Key: key, Value: value}
+	st.ID, st.CreatedAt = m.nextID()
+	st.UpdatedAt = st.CreatedAt
+	m.states = append(m.states, st)
+	return nil
+}
+
+// SetFingerprints : Insert or update the fingerprints of the titles in Sync repository
+func (b memoryBatch) SetFingerprints(fl []TitleFingerprint) error {
+	m := b.m
+	for _, f := range fl {
+		e := TitleFingerprint{TID: f.TID, Files: f.Files, Completed: f.Completed, Latest: f.Latest}
+		j := -1
+		for i := range m.fps {
+			if m.fps[i].TID == f.TID {
+				j = i
+			}
+		}
+		if j == -1 {
+			e.ID, e.CreatedAt = m.nextID()
+			e.UpdatedAt = e.CreatedAt
+			m.fps = append(m.fps, e)
+			continue
+		}
+		e.ID, e.CreatedAt, e.UpdatedAt = m.fps[j].ID, m.fps[j].CreatedAt, now()
+		m.fps[j] = e
+	}
+	return nil
+}
+
+// DeleteFingerprints : Delete the fingerprints of the titles from Sync repository
+func (b memoryBatch) DeleteFingerprints(tids []int) error {
+	m := b.m
+	deleted := map[int]bool{}
+	for _, tid := range tids {
+		deleted[tid] = true
+	}
+	kept := m.fps[:0:0]
+	for _, f := range m.fps {
+		if !deleted[f.TID] {
+			kept = append(kept, f)
+		}
+	}
+	m.fps = kept
+	return nil
+}
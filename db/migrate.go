@@ -0,0 +1,199 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SchemaVersion is a struct of a migration applied to the database
+type SchemaVersion struct {
+	Version   int `gorm:"primary_key"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// migration is a step of the schema.
+// Down is nil for a migration which cannot be rolled back.
+type migration struct {
+	version int
+	name    string
+	up      func(db *gorm.DB) error
+	down    func(db *gorm.DB) error
+}
+
+// MigrationStatus is a struct of a migration and whether it is applied to the database
+type MigrationStatus struct {
+	Version    int
+	Name       string
+	Applied    bool
+	AppliedAt  time.Time
+	Reversible bool
+}
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version of falko
+var ErrSchemaTooNew = errors.New("ローカルDBがこのバージョンのfalkoより新しいスキーマのため使用できません")
+
+// ErrMigrationsPending is returned when the database has to be migrated but it is opened read-only
+var ErrMigrationsPending = errors.New("ローカルDBのスキーマが最新ではありません (先にfalko db migrateを実行して下さい)")
+
+const createSchemaVersions = `CREATE TABLE IF NOT EXISTS "schema_versions" ("version" integer primary key,"name" varchar(255),"applied_at" datetime)`
+
+// execAll returns a migration step which executes the statements in order
+func execAll(stmts ...string) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		for _, q := range stmts {
+			if err := db.Exec(q).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// LatestVersion : Get the version of the schema which this version of falko uses
+func LatestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Version : Get the version of the schema of the database. It returns 0 for an empty database.
+func (s *Store) Version() (int, error) {
+	var v struct{ Version int }
+	err := s.db.Raw(`SELECT COALESCE(MAX(version), 0) AS version FROM schema_versions`).Scan(&v).Error
+	if err != nil {
+		return 0, err
+	}
+	return v.Version, nil
+}
+
+// MigrationStatus : Get all migrations known to this version of falko with their state in the database.
+// Migrations applied by a newer version of falko are included at the end.
+func (s *Store) MigrationStatus() ([]MigrationStatus, error) {
+	var vl []SchemaVersion
+	err := s.db.Order("version").Find(&vl).Error
+	if err != nil {
+		return []MigrationStatus{}, err
+	}
+	applied := map[int]SchemaVersion{}
+	for _, v := range vl {
+		applied[v.Version] = v
+	}
+	var ml []MigrationStatus
+	for _, m := range migrations {
+		a, ok := applied[m.version]
+		ml = append(ml, MigrationStatus{Version: m.version, Name: m.name, Applied: ok, AppliedAt: a.AppliedAt, Reversible: m.down != nil})
+	}
+	for _, v := range vl {
+		if v.Version > LatestVersion() {
+			ml = append(ml, MigrationStatus{Version: v.Version, Name: v.Name, Applied: true, AppliedAt: v.AppliedAt})
+		}
+	}
+	return ml, nil
+}
+
+// Migrate : Apply the migrations up to the version (the latest if to is 0) and return the applied ones.
+// Each migration runs in its own transaction.
+func (s *Store) Migrate(to int) ([]MigrationStatus, error) {
+	if to <= 0 {
+		to = LatestVersion()
+	}
+	if to > LatestVersion() {
+		return []MigrationStatus{}, fmt.Errorf("スキーマのバージョンが不正 : %d (最新 : %d)", to, LatestVersion())
+	}
+	cur, err := s.Version()
+	if err != nil {
+		return []MigrationStatus{}, err
+	}
+	if cur > LatestVersion() {
+		return []MigrationStatus{}, fmt.Errorf("%w (DB : %d, falko : %d)", ErrSchemaTooNew, cur, LatestVersion())
+	}
+	var done []MigrationStatus
+	for _, m := range migrations {
+		if m.version <= cur || m.version > to {
+			continue
+		}
+		m := m
+		now := time.Now()
+		err = s.Transaction(func(tx *Tx) error {
+			if err := m.up(tx.db); err != nil {
+				return err
+			}
+			return tx.db.Create(&SchemaVersion{Version: m.version, Name: m.name, AppliedAt: now}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("スキーマの更新に失敗 : %d %s : %w", m.version, m.name, err)
+		}
+		done = append(done, MigrationStatus{Version: m.version, Name: m.name, Applied: true, AppliedAt: now, Reversible: m.down != nil})
+	}
+	return done, nil
+}
+
+// Rollback : Roll back the migrations after the version and return the rolled back ones, newest first.
+// It stops with an error at a migration which cannot be rolled back.
+func (s *Store) Rollback(to int) ([]MigrationStatus, error) {
+	cur, err := s.Version()
+	if err != nil {
+		return []MigrationStatus{}, err
+	}
+	if cur > LatestVersion() {
+		return []MigrationStatus{}, fmt.Errorf("%w (DB : %d, falko : %d)", ErrSchemaTooNew, cur, LatestVersion())
+	}
+	if to < 0 || to >= cur {
+		return []MigrationStatus{}, fmt.Errorf("戻し先のスキーマのバージョンが不正 : %d (現在 : %d)", to, cur)
+	}
+	var done []MigrationStatus
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > cur || m.version <= to {
+			continue
+		}
+		if m.down == nil {
+			return done, fmt.Errorf("このスキーマの変更は元に戻せません : %d %s", m.version, m.name)
+		}
+		err = s.Transaction(func(tx *Tx) error {
+			if err := m.down(tx.db); err != nil {
+				return err
+			}
+			return tx.db.Where("version = ?", m.version).Delete(&SchemaVersion{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("スキーマの巻き戻しに失敗 : %d %s : %w", m.version, m.name, err)
+		}
+		done = append(done, MigrationStatus{Version: m.version, Name: m.name, Reversible: true})
+	}
+	return done, nil
+}
+
+// migrateToLatest applies the pending migrations when the database is opened
+func (s *Store) migrateToLatest() error {
+	cur, err := s.Version()
+	if err != nil {
+		return err
+	}
+	done, err := s.Migrate(0)
+	for _, m := range done {
+		if cur > 0 {
+			log.Printf("ローカルDBのスキーマを更新 : %d %s", m.Version, m.Name)
+		}
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// migrations are the steps of the schema in order of the version.
+// A released migration must not be changed; add a new one instead, and keep the models in sync with the schema.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "初期スキーマ",
+		up: execAll(
+			`CREATE TABLE IF NOT EXISTS "anime_titles" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"t_id" integer,"title" varchar(255),"title_yomi" varchar(255),"year" integer,"active" bool)`,
+			`CREATE INDEX IF NOT EXISTS idx_anime_titles_deleted_at ON "anime_titles"(deleted_at)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS uix_anime_titles_t_id ON "anime_titles"(t_id)`,
+			`CREATE TABLE IF NOT EXISTS "anime_episodes" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"t_id" integer,"ep_num" integer,"ep_title" varchar(255),"ep_source" varchar(255),"special" bool,"copy_status" bool,
+				FOREIGN KEY ("t_id") REFERENCES "anime_titles" ("t_id") DEFERRABLE INITIALLY DEFERRED)`,
+			`CREATE INDEX IF NOT EXISTS idx_anime_episodes_deleted_at ON "anime_episodes"(deleted_at)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS uix_anime_episodes_t_id_ep_num ON "anime_episodes"(t_id, ep_num)`,
+			`CREATE TABLE IF NOT EXISTS "video_files" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"t_id" integer,"ep_num" integer,"ep_source" varchar(255),"p_id" integer,"file_ts" varchar(255),"file_mp4_hd" varchar(255),"file_mp4_sd" varchar(255),"station" varchar(255),"time" datetime,"drop" integer,"scramble" integer,"status" varchar(255) DEFAULT 'present',"removed_at" datetime,"rec_status" varchar(255) DEFAULT 'completed',"rec_status_text" varchar(255),"rec_status_at" datetime,
+				FOREIGN KEY ("t_id", "ep_num") REFERENCES "anime_episodes" ("t_id", "ep_num") DEFERRABLE INITIALLY DEFERRED)`,
+			`CREATE INDEX IF NOT EXISTS idx_video_files_deleted_at ON "video_files"(deleted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_video_files_t_id_ep_num ON "video_files"(t_id, ep_num)`,
+			`CREATE INDEX IF NOT EXISTS idx_video_files_p_id ON "video_files"(p_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_video_files_status ON "video_files"("status")`,
+			`CREATE TABLE IF NOT EXISTS "ts_segments" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"p_id" integer,"name" varchar(255),"total" integer,"drop" integer,"scramble" integer)`,
+			`CREATE INDEX IF NOT EXISTS idx_ts_segments_deleted_at ON "ts_segments"(deleted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_ts_segments_p_id ON "ts_segments"(p_id)`,
+			`CREATE TABLE IF NOT EXISTS "keyword_rec_files" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"keyword" varchar(255),"title" varchar(255),"p_id" integer,"file_ts" varchar(255),"file_mp4_hd" varchar(255),"file_mp4_sd" varchar(255),"station" varchar(255),"time" datetime,"drop" integer,"scramble" integer,"copy" bool,"status" varchar(255) DEFAULT 'present',"removed_at" datetime,"rec_status" varchar(255) DEFAULT 'completed',"rec_status_text" varchar(255),"rec_status_at" datetime)`,
+			`CREATE INDEX IF NOT EXISTS idx_keyword_rec_files_deleted_at ON "keyword_rec_files"(deleted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_keyword_rec_files_p_id ON "keyword_rec_files"(p_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_keyword_rec_files_status ON "keyword_rec_files"("status")`,
+			`CREATE TABLE IF NOT EXISTS "new_animes" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"t_id" integer,"title" varchar(255),"station" varchar(255),"time" datetime)`,
+			`CREATE INDEX IF NOT EXISTS idx_new_animes_deleted_at ON "new_animes"(deleted_at)`,
+			`CREATE TABLE IF NOT EXISTS "sync_states" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"key" varchar(255),"value" varchar(255))`,
+			`CREATE INDEX IF NOT EXISTS idx_sync_states_deleted_at ON "sync_states"(deleted_at)`,
+			`CREATE TABLE IF NOT EXISTS "title_fingerprints" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"t_id" integer,"files" integer,"completed" integer,"latest" datetime)`,
+			`CREATE INDEX IF NOT EXISTS idx_title_fingerprints_deleted_at ON "title_fingerprints"(deleted_at)`,
+			`CREATE TABLE IF NOT EXISTS "history_events" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"kind" varchar(255),"event" varchar(255),"p_id" integer,"t_id" integer,"ep_num" integer,"keyword" varchar(255),"title" varchar(255),"station" varchar(255),"detail" varchar(255))`,
+			`CREATE INDEX IF NOT EXISTS idx_history_events_deleted_at ON "history_events"(deleted_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_history_events_p_id ON "history_events"(p_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_history_events_t_id ON "history_events"(t_id)`,
+		),
+	},
+	{
+		version: 2,
+		name:    "録画のPIDなどの一意制約",
+		up: func(db *gorm.DB) error {
+			return addUniqueKeys(&Tx{db: db})
+		},
+		down: execAll(
+			`DROP INDEX IF EXISTS uix_video_files_p_id`,
+			`CREATE INDEX IF NOT EXISTS idx_video_files_p_id ON "video_files"(p_id)`,
+			`DROP INDEX IF EXISTS uix_keyword_rec_files_p_id`,
+			`CREATE INDEX IF NOT EXISTS idx_keyword_rec_files_p_id ON "keyword_rec_files"(p_id)`,
+			`DROP INDEX IF EXISTS uix_sync_states_key`,
+			`DROP INDEX IF EXISTS uix_title_fingerprints_t_id`,
+		),
+	},
+}
@@ -17,12 +17,10 @@ limitations under the License.
 package db
 
 import (
-	"path/filepath"
+	"fmt"
 	"time"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/mattn/go-sqlite3"
-	homedir "github.com/mitchellh/go-homedir"
 )
 
 // NewAnime is a struct of video file
@@ -34,108 +32,51 @@ type NewAnime struct {
 	Time    time.Time
 }
 
-// InitNewAnimeDB : Initialize NewAnime DB
-func InitNewAnimeDB() error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_newanime.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.AutoMigrate(&NewAnime{})
-	return nil
-}
-
 // InsertNewAnime : Insert Data to NewAnime DB
-func InsertNewAnime(tid int, title string, station string, time time.Time) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_newanime.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.Create(&NewAnime{TID: tid, Title: title, Station: station, Time: time})
-	return nil
+func (s *Store) InsertNewAnime(tid int, title string, station string, time time.Time) error {
+	return s.db.Create(&NewAnime{TID: tid, Title: title, Station: station, Time: time}).Error
 }
 
 // UpdateNewAnime : Update Data of NewAnime DB
-func UpdateNewAnime(id uint, tid int, title string, station string, time time.Time) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_newanime.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) UpdateNewAnime(id uint, tid int, title string, station string, time time.Time) error {
+	var na NewAnime
+	err := first(s.db, &na, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var na NewAnime
-	db.First(&na, id)
 	na.TID = tid
 	na.Title = title
 	na.Station = station
 	na.Time = time
-	db.Save(&na)
-	return nil
+	return s.db.Save(&na).Error
 }
 
 // DeleteNewAnime : Delete Data of NewAnime DB
-func DeleteNewAnime(id uint) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_newanime.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) DeleteNewAnime(id uint) error {
+	var vf NewAnime
+	err := first(s.db, &vf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var vf NewAnime
-	db.First(&vf, id)
-	db.Delete(&vf)
-	return nil
+	return s.db.Delete(&vf).Error
 }
 
 // GetAllNewAnime : Get All Data from NewAnime DB
-func GetAllNewAnime() ([]NewAnime, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		return []NewAnime{}, err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_newanime.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) GetAllNewAnime() ([]NewAnime, error) {
+	var nal []NewAnime
+	err := s.db.Order("created_at desc").Find(&nal).Error
 	if err != nil {
 		return []NewAnime{}, err
 	}
-	defer db.Close()
-	var nal []NewAnime
-	db.Order("created_at desc").Find(&nal)
 	return nal, nil
 }
 
 // GetOneNewAnime : Get Data from NewAnime DB
-func GetOneNewAnime(id uint) (NewAnime, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		return NewAnime{}, err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_newanime.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) GetOneNewAnime(id uint) (NewAnime, error) {
+	var na NewAnime
+	err := first(s.db, &na, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return NewAnime{}, err
 	}
-	defer db.Close()
-	var na NewAnime
-	db.First(&na, id)
 	return na, nil
 }
@@ -0,0 +1,130 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import "time"
+
+// TitleRepo is a repository of the anime titles
+type TitleRepo interface {
+	InsertTitle(tid int, title string, yomi string, year int, active bool) error
+	UpdateTitle(id uint, tid int, title string, yomi string, year int, active bool) error
+	DeleteTitle(id uint) error
+	GetAllTitle() (AnimeTitleList, error)
+	GetOneTitle(id uint) (AnimeTitle, error)
+	GetTitleByTID(tid int) (AnimeTitle, error)
+}
+
+// EpisodeRepo is a repository of the episodes of the titles
+type EpisodeRepo interface {
+	InsertEpisode(tid int, epnum int, eptitle string, copyStatus bool) error
+	UpdateEpisode(id uint, tid int, epnum int, eptitle string, copyStatus bool) error
+	DeleteEpisode(id uint) error
+	GetAllEpisode() ([]AnimeEpisode, error)
+	GetOneEpisode(id uint) (AnimeEpisode, error)
+	GetEpisodesByTID(tid int) ([]AnimeEpisode, error)
+	GetEpisodeByTIDEpNum(tid int, epnum int) (AnimeEpisode, error)
+}
+
+// VideoFileRepo is a repository of the recordings of the episodes
+type VideoFileRepo interface {
+	InsertVideoFile(tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error
+	UpdateVideoFile(id uint, tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error
+	DeleteVideoFile(id uint) error
+	GetAllVideoFile() ([]VideoFile, error)
+	GetRemovedVideoFile() ([]VideoFile, error)
+	GetOneVideoFile(id uint) (VideoFile, error)
+	GetVideoFileByPID(pid int) (VideoFile, error)
+	GetVideoFilesByTID(tid int) ([]VideoFile, error)
+	GetVideoFilesByTIDEpNum(tid int, epnum int) ([]VideoFile, error)
+}
+
+// KeywordRecRepo is a repository of the keyword recordings
+type KeywordRecRepo interface {
+	InsertKeywordRecFile(keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error
+	UpdateKeywordRecFile(id uint, keyword string, title string, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int, cp bool) error
+	DeleteKeywordRecFile(id uint) error
+	GetAllKeywordRecFile() ([]KeywordRecFile, error)
+	GetRemovedKeywordRecFile() ([]KeywordRecFile, error)
+	GetOneKeywordRecFile(id uint) (KeywordRecFile, error)
+	GetKeywordRecFileByPID(pid int) (KeywordRecFile, error)
+}
+
+// NewAnimeRepo is a repository of the new anime already notified
+type NewAnimeRepo interface {
+	InsertNewAnime(tid int, title string, station string, time time.Time) error
+	UpdateNewAnime(id uint, tid int, title string, station string, time time.Time) error
+	DeleteNewAnime(id uint) error
+	GetAllNewAnime() ([]NewAnime, error)
+	GetOneNewAnime(id uint) (NewAnime, error)
+}
+
+// SyncRepo is a repository of the state kept between updates
+type SyncRepo interface {
+	GetSyncState(key string) (string, error)
+	GetAllFingerprint() ([]TitleFingerprint, error)
+}
+
+// TSSegmentRepo is a repository of TS packet information of the recordings
+type TSSegmentRepo interface {
+	GetTSSegments(pid int) ([]TSSegment, error)
+}
+
+// HistoryRepo is a repository of the changes of the recordings found by update
+type HistoryRepo interface {
+	GetHistory(q HistoryQuery) ([]HistoryEvent, error)
+}
+
+// Batch is a set of the writes of update applied together
+type Batch interface {
+	UpsertTitles(tl []AnimeTitle) error
+	SaveTitles(tl []AnimeTitle) error
+	UpsertEpisodes(el []AnimeEpisode) error
+	UpsertVideoFiles(vl []VideoFile) error
+	SaveVideoFiles(vl []VideoFile) error
+	SetTSSegments(pid int, sl []TSSegment) error
+	UpsertKeywordRecFiles(kl []KeywordRecFile) error
+	SaveKeywordRecFiles(kl []KeywordRecFile) error
+	InsertHistoryEvents(hl []HistoryEvent) error
+	SetSyncState(key string, value string) error
+	SetFingerprints(fl []TitleFingerprint) error
+	DeleteFingerprints(tids []int) error
+}
+
+// Updater applies a batch to the repositories.
+// None of the writes are applied if f returns an error.
+type Updater interface {
+	ApplyBatch(f func(b Batch) error) error
+}
+
+// Repos is a set of the repositories used by the commands.
+// Use Store.Repos for the database and Memory.Repos for the one in memory.
+type Repos struct {
+	Titles      TitleRepo
+	Episodes    EpisodeRepo
+	VideoFiles  VideoFileRepo
+	KeywordRecs KeywordRecRepo
+	NewAnimes   NewAnimeRepo
+	Sync        SyncRepo
+	TSSegments  TSSegmentRepo
+	History     HistoryRepo
+	Updater     Updater
+}
+
+// Repos : Get the repositories backed by the database
+func (s *Store) Repos() Repos {
+	return Repos{Titles: s, Episodes: s, VideoFiles: s, KeywordRecs: s, NewAnimes: s, Sync: s, TSSegments: s, History: s, Updater: s}
+}
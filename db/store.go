@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// FileName is the name of the database file in the config directory
+const FileName = "falko.sqlite3"
+
+// ErrNotFound is returned when the requested row does not exist in the database.
+// It is wrapped with the key of the row, so use errors.Is to check it.
+var ErrNotFound = errors.New("ローカルDBにデータが見つかりません")
+
+// Store is a handle of the database which holds all tables of falko.
+// It is opened once by Open and shared by the commands.
+type Store struct {
+	db *gorm.DB
+}
+
+// Open : Open the database at path and apply the pending migrations.
+// If it does not exist yet, it is created and the DB files used by the older versions in the same directory are imported.
+// It returns ErrSchemaTooNew if the database was migrated by a newer version of falko.
+func Open(path string) (*Store, error) {
+	s, err := OpenUnmigrated(path)
+	if err != nil {
+		return nil, err
+	}
+	err = s.migrateToLatest()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenUnmigrated : Open the database at path without applying the pending migrations nor checking the version of the schema.
+// It is for the maintenance of the schema such as migrate and rollback.
+func OpenUnmigrated(path string) (*Store, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) && hasLegacyFiles(filepath.Dir(path)) {
+		err = importLegacyFiles(path)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return openFile(path)
+}
+
+// OpenReadOnly : Open the existing database at path without writing anything.
+// Neither the legacy DB files are imported nor the migrations are applied,
+// so it returns ErrMigrationsPending if the database does not exist or its schema is not the latest.
+func OpenReadOnly(path string) (*Store, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w (DB : なし, falko : %d)", ErrMigrationsPending, LatestVersion())
+	} else if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open("sqlite3", "file:"+path+"?mode=ro&_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db}
+	cur := 0
+	if db.HasTable(&SchemaVersion{}) {
+		cur, err = s.Version()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if cur != LatestVersion() {
+		s.Close()
+		if cur > LatestVersion() {
+			return nil, fmt.Errorf("%w (DB : %d, falko : %d)", ErrSchemaTooNew, cur, LatestVersion())
+		}
+		return nil, fmt.Errorf("%w (DB : %d, falko : %d)", ErrMigrationsPending, cur, LatestVersion())
+	}
+	return s, nil
+}
+
+func openFile(path string) (*Store, error) {
+	db, err := gorm.Open("sqlite3", "file:"+path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	err = db.Exec(createSchemaVersions).Error
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close : Close the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// first finds the first row matching where into out and returns ErrNotFound wrapped with key if there is none
+func first(db *gorm.DB, out interface{}, key string, where ...interface{}) error {
+	err := db.First(out, where...).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return notFound(key)
+	}
+	return err
+}
+
+// notFound returns ErrNotFound wrapped with the key of the row
+func notFound(key string) error {
+	return fmt.Errorf("%w (%s)", ErrNotFound, key)
+}
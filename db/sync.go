@@ -0,0 +1,93 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SyncState is a struct of value kept between updates such as the Syoboi LastUpdate watermark
+type SyncState struct {
+	gorm.Model
+	Key   string `gorm:"unique_index"`
+	Value string
+}
+
+// TitleFingerprint is a struct of library summary of the title at the last update.
+// Completed is the number of recordings which had finished processing.
+type TitleFingerprint struct {
+	gorm.Model
+	TID       int `gorm:"unique_index"`
+	Files     int
+	Completed int
+	Latest    time.Time
+}
+
+// Keys of SyncState
+const (
+	SyncSyoboiLastUpdate = "syoboi_last_update"
+)
+
+// GetSyncState : Get the value of the key from Sync DB. It returns "" if the key is not set.
+func (s *Store) GetSyncState(key string) (string, error) {
+	var st SyncState
+	err := s.db.Where("key = ?", key).First(&st).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return st.Value, nil
+}
+
+// SetSyncState : Set the value of the key to Sync DB
+func (s *Store) SetSyncState(key string, value string) error {
+	t := &Tx{db: s.db}
+	return t.SetSyncState(key, value)
+}
+
+// ClearSyncState : Delete all values and fingerprints from Sync DB
+func (s *Store) ClearSyncState() error {
+	err := s.db.Unscoped().Delete(&SyncState{}).Error
+	if err != nil {
+		return err
+	}
+	return s.db.Unscoped().Delete(&TitleFingerprint{}).Error
+}
+
+// GetAllFingerprint : Get All Data of title fingerprints from Sync DB
+func (s *Store) GetAllFingerprint() ([]TitleFingerprint, error) {
+	var fl []TitleFingerprint
+	err := s.db.Find(&fl).Error
+	if err != nil {
+		return []TitleFingerprint{}, err
+	}
+	return fl, nil
+}
+
+// SetFingerprint : Insert or update the fingerprint of the title in Sync DB
+func (s *Store) SetFingerprint(tid int, files int, completed int, latest time.Time) error {
+	t := &Tx{db: s.db}
+	return t.SetFingerprints([]TitleFingerprint{{TID: tid, Files: files, Completed: completed, Latest: latest}})
+}
+
+// DeleteFingerprint : Delete the fingerprint of the title from Sync DB
+func (s *Store) DeleteFingerprint(tid int) error {
+	return s.db.Unscoped().Where("t_id = ?", tid).Delete(&TitleFingerprint{}).Error
+}
@@ -18,17 +18,14 @@ package db
 
 import (
 	"fmt"
-	"path/filepath"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/mattn/go-sqlite3"
-	homedir "github.com/mitchellh/go-homedir"
 )
 
-// AnimeTitle is a struct of anime title
+// AnimeTitle is a struct of anime title. TID is unique.
 type AnimeTitle struct {
 	gorm.Model
-	TID       int
+	TID       int `gorm:"unique_index"`
 	Title     string
 	TitleYomi string
 	Year      int
@@ -52,109 +49,62 @@ func (a AnimeTitle) String() string {
 	return fmt.Sprintf("%d : %s (%d)", a.TID, a.Title, a.Year)
 }
 
-// InitTitleDB : Initialize Title DB
-func InitTitleDB() error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_title.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.AutoMigrate(&AnimeTitle{})
-	return nil
-}
-
 // InsertTitle : Insert Data to Title DB
-func InsertTitle(tid int, title string, yomi string, year int, active bool) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_title.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.Create(&AnimeTitle{TID: tid, Title: title, TitleYomi: yomi, Year: year, Active: active})
-	return nil
+func (s *Store) InsertTitle(tid int, title string, yomi string, year int, active bool) error {
+	return s.db.Create(&AnimeTitle{TID: tid, Title: title, TitleYomi: yomi, Year: year, Active: active}).Error
 }
 
 // UpdateTitle : Update Data of Title DB
-func UpdateTitle(id uint, tid int, title string, yomi string, year int, active bool) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_title.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) UpdateTitle(id uint, tid int, title string, yomi string, year int, active bool) error {
+	var t AnimeTitle
+	err := first(s.db, &t, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var t AnimeTitle
-	db.First(&t, id)
 	t.TID = tid
 	t.Title = title
 	t.TitleYomi = yomi
 	t.Year = year
 	t.Active = active
-	db.Save(&t)
-	return nil
+	return s.db.Save(&t).Error
 }
 
 // DeleteTitle : Delete Data of Title DB
-func DeleteTitle(id uint) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_title.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) DeleteTitle(id uint) error {
+	var t AnimeTitle
+	err := first(s.db, &t, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var t AnimeTitle
-	db.First(&t, id)
-	db.Delete(&t)
-	return nil
+	return s.db.Delete(&t).Error
 }
 
 // GetAllTitle : Get All Data from Title DB
-func GetAllTitle() (AnimeTitleList, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		return AnimeTitleList{}, err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_title.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) GetAllTitle() (AnimeTitleList, error) {
+	var tl AnimeTitleList
+	err := s.db.Order("created_at desc").Find(&tl).Error
 	if err != nil {
 		return AnimeTitleList{}, err
 	}
-	defer db.Close()
-	var tl AnimeTitleList
-	db.Order("created_at desc").Find(&tl)
 	return tl, nil
 }
 
 // GetOneTitle : Get Data from Title DB
-func GetOneTitle(id uint) (AnimeTitle, error) {
-	home, err := homedir.Dir()
+func (s *Store) GetOneTitle(id uint) (AnimeTitle, error) {
+	var t AnimeTitle
+	err := first(s.db, &t, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return AnimeTitle{}, err
 	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_title.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+	return t, nil
+}
+
+// GetTitleByTID : Get Data of the TID from Title DB
+func (s *Store) GetTitleByTID(tid int) (AnimeTitle, error) {
+	var t AnimeTitle
+	err := first(s.db.Where("t_id = ?", tid), &t, fmt.Sprintf("TID:%d", tid))
 	if err != nil {
 		return AnimeTitle{}, err
 	}
-	defer db.Close()
-	var t AnimeTitle
-	db.First(&t, id)
 	return t, nil
 }
@@ -0,0 +1,42 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// TSSegment is a struct of TS packet information of a stream in the recording.
+// It is kept in one table for both anime and keyword recordings.
+type TSSegment struct {
+	gorm.Model
+	PID      int `gorm:"index"`
+	Name     string
+	Total    int
+	Drop     int
+	Scramble int
+}
+
+// GetTSSegments : Get TS packet information of the recording from VideoFile DB
+func (s *Store) GetTSSegments(pid int) ([]TSSegment, error) {
+	var sl []TSSegment
+	err := s.db.Where("p_id = ?", pid).Order("id").Find(&sl).Error
+	if err != nil {
+		return []TSSegment{}, err
+	}
+	return sl, nil
+}
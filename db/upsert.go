@@ -0,0 +1,66 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// upsert inserts the row, or updates the existing row which has the same values of the unique key columns.
+// The columns in keep are left as they are on the existing row.
+// The ID of v is set to the ID of the inserted or updated row.
+func (t *Tx) upsert(v interface{}, key []string, keep ...string) error {
+	scope := t.db.NewScope(v)
+	skip := map[string]bool{"id": true, "created_at": true}
+	for _, k := range append(key, keep...) {
+		skip[k] = true
+	}
+	var set []string
+	var cols []string
+	var where []string
+	var args []interface{}
+	for _, f := range scope.Fields() {
+		if f.IsIgnored || !f.IsNormal {
+			continue
+		}
+		if !skip[f.DBName] {
+			set = append(set, fmt.Sprintf("%s = excluded.%s", scope.Quote(f.DBName), scope.Quote(f.DBName)))
+		}
+	}
+	for _, k := range key {
+		f, ok := scope.FieldByName(k)
+		if !ok {
+			return fmt.Errorf("一意キーのカラムが見つかりません : %s", k)
+		}
+		cols = append(cols, scope.Quote(k))
+		where = append(where, scope.Quote(k)+" = ?")
+		args = append(args, f.Field.Interface())
+	}
+	option := fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(cols, ", "), strings.Join(set, ", "))
+	err := t.db.Set("gorm:insert_option", option).Create(v).Error
+	if err != nil {
+		return err
+	}
+	// last_insert_rowid is not changed when the existing row is updated
+	var id struct{ ID uint }
+	err = t.db.Raw(fmt.Sprintf("SELECT id FROM %s WHERE %s", scope.QuotedTableName(), strings.Join(where, " AND ")), args...).Scan(&id).Error
+	if err != nil {
+		return err
+	}
+	return scope.SetColumn("ID", id.ID)
+}
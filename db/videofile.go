@@ -18,79 +18,57 @@ package db
 
 import (
 	"fmt"
-	"path/filepath"
 	"time"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/mattn/go-sqlite3"
-	homedir "github.com/mitchellh/go-homedir"
 )
 
-// VideoFile is a struct of video file
+// Status of the recordings in VideoFile DB and KeywordRecFile DB
+const (
+	StatusPresent = "present"
+	StatusRemoved = "removed"
+)
+
+// VideoFile is a struct of video file.
+// It belongs to the episode of TID and EpNum.
+// A recording removed from foltia ANIME LOCKER is kept with StatusRemoved and RemovedAt.
+// RecStatus is the status of the recording in foltia ANIME LOCKER and RecStatusAt is when the status was first seen.
 type VideoFile struct {
 	gorm.Model
-	TID       int
-	EpNum     int
-	PID       int
-	FileTS    string
-	FileMP4HD string
-	FileMP4SD string
-	Station   string
-	Time      time.Time
-	Drop      int
-	Scramble  int
+	TID           int `gorm:"index:idx_video_files_t_id_ep_num"`
+	EpNum         int `gorm:"index:idx_video_files_t_id_ep_num"`
+	EpSource      string
+	PID           int `gorm:"unique_index"`
+	FileTS        string
+	FileMP4HD     string
+	FileMP4SD     string
+	Station       string
+	Time          time.Time
+	Drop          int
+	Scramble      int
+	Status        string `gorm:"index;default:'present'"`
+	RemovedAt     *time.Time
+	RecStatus     string `gorm:"default:'completed'"`
+	RecStatusText string
+	RecStatusAt   time.Time
 }
 
 func (v VideoFile) String() string {
 	return fmt.Sprintf("PID : %d, Time : %s, Staiton : %s, FileTS : %s, FileMP4HD : %s, FileMP4SD : %s, Drop : %d, Scramble : %d\n", v.PID, v.Time, v.Station, v.FileTS, v.FileMP4HD, v.FileMP4SD, v.Drop, v.Scramble)
 }
 
-// InitVideoFileDB : Initialize VideoFile DB
-func InitVideoFileDB() error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_videofile.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.AutoMigrate(&VideoFile{})
-	return nil
-}
-
 // InsertVideoFile : Insert Data to VideoFile DB
-func InsertVideoFile(tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_videofile.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	db.Create(&VideoFile{TID: tid, EpNum: epnum, PID: pid, FileTS: filets, FileMP4HD: filemp4hd, FileMP4SD: filemp4sd, Station: station, Time: time, Drop: drop, Scramble: scramble})
-	return nil
+func (s *Store) InsertVideoFile(tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error {
+	return s.db.Create(&VideoFile{TID: tid, EpNum: epnum, PID: pid, FileTS: filets, FileMP4HD: filemp4hd, FileMP4SD: filemp4sd, Station: station, Time: time, Drop: drop, Scramble: scramble}).Error
 }
 
 // UpdateVideoFile : Update Data of VideoFile DB
-func UpdateVideoFile(id uint, tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_videofile.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) UpdateVideoFile(id uint, tid int, epnum int, pid int, filets string, filemp4hd string, filemp4sd string, station string, time time.Time, drop int, scramble int) error {
+	var vf VideoFile
+	err := first(s.db, &vf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var vf VideoFile
-	db.First(&vf, id)
 	vf.TID = tid
 	vf.EpNum = epnum
 	vf.PID = pid
@@ -101,58 +79,75 @@ func UpdateVideoFile(id uint, tid int, epnum int, pid int, filets string, filemp
 	vf.Time = time
 	vf.Drop = drop
 	vf.Scramble = scramble
-	db.Save(&vf)
-	return nil
+	return s.db.Save(&vf).Error
 }
 
 // DeleteVideoFile : Delete Data of VideoFile DB
-func DeleteVideoFile(id uint) error {
-	home, err := homedir.Dir()
-	if err != nil {
-		return err
-	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_videofile.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+func (s *Store) DeleteVideoFile(id uint) error {
+	var vf VideoFile
+	err := first(s.db, &vf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	var vf VideoFile
-	db.First(&vf, id)
-	db.Delete(&vf)
-	return nil
+	return s.db.Delete(&vf).Error
 }
 
-// GetAllVideoFile : Get All Data from VideoFile DB
-func GetAllVideoFile() ([]VideoFile, error) {
-	home, err := homedir.Dir()
+// GetAllVideoFile : Get All Data of the present recordings from VideoFile DB
+func (s *Store) GetAllVideoFile() ([]VideoFile, error) {
+	var vfl []VideoFile
+	err := s.db.Where("status = ?", StatusPresent).Order("created_at desc").Find(&vfl).Error
 	if err != nil {
 		return []VideoFile{}, err
 	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_videofile.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+	return vfl, nil
+}
+
+// GetRemovedVideoFile : Get All Data of the recordings removed from foltia ANIME LOCKER from VideoFile DB
+func (s *Store) GetRemovedVideoFile() ([]VideoFile, error) {
+	var vfl []VideoFile
+	err := s.db.Where("status = ?", StatusRemoved).Order("removed_at desc").Find(&vfl).Error
 	if err != nil {
 		return []VideoFile{}, err
 	}
-	defer db.Close()
-	var vfl []VideoFile
-	db.Order("created_at desc").Find(&vfl)
 	return vfl, nil
 }
 
 // GetOneVideoFile : Get Data from VideoFile DB
-func GetOneVideoFile(id uint) (VideoFile, error) {
-	home, err := homedir.Dir()
+func (s *Store) GetOneVideoFile(id uint) (VideoFile, error) {
+	var vf VideoFile
+	err := first(s.db, &vf, fmt.Sprintf("ID:%d", id), id)
 	if err != nil {
 		return VideoFile{}, err
 	}
-	dbPath := filepath.Join(home, ".config", "falko", "foltia_videofile.sqlite3")
-	db, err := gorm.Open("sqlite3", dbPath)
+	return vf, nil
+}
+
+// GetVideoFileByPID : Get Data of the PID from VideoFile DB including the removed one
+func (s *Store) GetVideoFileByPID(pid int) (VideoFile, error) {
+	var vf VideoFile
+	err := first(s.db.Where("p_id = ?", pid), &vf, fmt.Sprintf("PID:%d", pid))
 	if err != nil {
 		return VideoFile{}, err
 	}
-	defer db.Close()
-	var vf VideoFile
-	db.First(&vf, id)
 	return vf, nil
 }
+
+// GetVideoFilesByTID : Get Data of the TID present in foltia ANIME LOCKER from VideoFile DB
+func (s *Store) GetVideoFilesByTID(tid int) ([]VideoFile, error) {
+	var vfl []VideoFile
+	err := s.db.Where("t_id = ? AND status = ?", tid, StatusPresent).Order("ep_num, time").Find(&vfl).Error
+	if err != nil {
+		return []VideoFile{}, err
+	}
+	return vfl, nil
+}
+
+// GetVideoFilesByTIDEpNum : Get Data of the TID and EpNum present in foltia ANIME LOCKER from VideoFile DB
+func (s *Store) GetVideoFilesByTIDEpNum(tid int, epnum int) ([]VideoFile, error) {
+	var vfl []VideoFile
+	err := s.db.Where("t_id = ? AND ep_num = ? AND status = ?", tid, epnum, StatusPresent).Order("time").Find(&vfl).Error
+	if err != nil {
+		return []VideoFile{}, err
+	}
+	return vfl, nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fetch provides the HTTP layer shared by the web scrapers
+package fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DefaultTimeout is the per-request timeout used when none is configured
+const DefaultTimeout = 30 * time.Second
+
+// Retry is a retry policy with exponential backoff
+type Retry struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetry is the retry policy used when none is configured
+var DefaultRetry = Retry{Attempts: 3, BaseDelay: 1 * time.Second, MaxDelay: 10 * time.Second}
+
+// Client is a HTTP client which fetches HTML/XML documents
+type Client struct {
+	HTTP     *http.Client
+	Timeout  time.Duration
+	Retry    Retry
+	Limiter  *Limiter
+	Username string
+	Password string
+}
+
+// Limiter spaces requests evenly to keep them under a rate.
+// A nil Limiter does not limit.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter : Create a limiter allowing rps requests per second.
+// It returns nil if rps is not positive.
+func NewLimiter(rps float64) *Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// Wait : Wait until the next request is allowed
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	t := l.next
+	if t.Before(now) {
+		t = now
+	}
+	l.next = t.Add(l.interval)
+	l.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(t)):
+		return nil
+	}
+}
+
+// StatusError is returned when the server responds with non-200 status
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTPエラー %d : %s", e.Code, e.URL)
+}
+
+// IsTransient : Check whether the error may be resolved by retrying
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusTooManyRequests
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	// Other network errors such as TLS, DNS and unsupported scheme are not resolved by retrying
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// New : Create a client with the per-request timeout
+func New(timeout time.Duration) *Client {
+	return &Client{HTTP: &http.Client{}, Timeout: timeout, Retry: DefaultRetry}
+}
+
+// NewTLS : Create a client trusting the CA bundle in caFile in addition to the system roots.
+// If insecure is true, the server certificate is not verified.
+func NewTLS(timeout time.Duration, caFile string, insecure bool) (*Client, error) {
+	c := New(timeout)
+	if caFile == "" && !insecure {
+		return c, nil
+	}
+	tc := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA証明書を読み込めません : %s", caFile)
+		}
+		tc.RootCAs = pool
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tc
+	c.HTTP = &http.Client{Transport: tr}
+	return c, nil
+}
+
+// Response is a response of Fetch
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Document : Get the document at the url, retrying on transient errors
+func (c *Client) Document(ctx context.Context, url string) (*goquery.Document, error) {
+	var doc *goquery.Document
+	err := c.retry(ctx, func() error {
+		var err error
+		doc, err = c.document(ctx, url)
+		return err
+	})
+	return doc, err
+}
+
+// Fetch : Get the url with the request header, retrying on transient errors.
+// 304 Not Modified is returned as a Response, not as an error.
+func (c *Client) Fetch(ctx context.Context, url string, header http.Header) (*Response, error) {
+	var res *Response
+	err := c.retry(ctx, func() error {
+		var err error
+		res, err = c.fetch(ctx, url, header)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) retry(ctx context.Context, f func() error) error {
+	delay := c.Retry.BaseDelay
+	for i := 1; ; i++ {
+		err := f()
+		if err == nil || ctx.Err() != nil || i >= c.Retry.Attempts || !IsTransient(err) {
+			return err
+		}
+		log.Printf("リクエスト失敗 (%d/%d) : %s", i, c.Retry.Attempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if c.Retry.MaxDelay > 0 && delay > c.Retry.MaxDelay {
+			delay = c.Retry.MaxDelay
+		}
+	}
+}
+
+func (c *Client) document(ctx context.Context, url string) (*goquery.Document, error) {
+	var doc *goquery.Document
+	err := c.do(ctx, url, nil, func(res *http.Response) error {
+		if res.StatusCode != http.StatusOK {
+			return &StatusError{URL: url, Code: res.StatusCode}
+		}
+		var err error
+		doc, err = goquery.NewDocumentFromReader(res.Body)
+		return err
+	})
+	return doc, err
+}
+
+func (c *Client) fetch(ctx context.Context, url string, header http.Header) (*Response, error) {
+	var r *Response
+	err := c.do(ctx, url, header, func(res *http.Response) error {
+		if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotModified {
+			return &StatusError{URL: url, Code: res.StatusCode}
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		r = &Response{Status: res.StatusCode, Header: res.Header, Body: body}
+		return nil
+	})
+	return r, err
+}
+
+// do sends a request and passes the response to f within the per-request timeout.
+// The time waiting for the limiter is not included in the timeout.
+func (c *Client) do(ctx context.Context, url string, header http.Header, f func(res *http.Response) error) error {
+	err := c.Limiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return f(res)
+}
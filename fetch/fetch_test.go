@@ -0,0 +1,89 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetch
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://foltia.local/", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"server error", &StatusError{URL: "u", Code: http.StatusServiceUnavailable}, true},
+		{"too many requests", &StatusError{URL: "u", Code: http.StatusTooManyRequests}, true},
+		{"not found", &StatusError{URL: "u", Code: http.StatusNotFound}, false},
+		{"timeout", urlErr(context.DeadlineExceeded), true},
+		{"unexpected EOF", urlErr(io.ErrUnexpectedEOF), true},
+		{"certificate", urlErr(x509.UnknownAuthorityError{}), false},
+		{"NXDOMAIN", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "foltia.local", IsNotFound: true}}), false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: IsTransient(%v) = %t, want %t", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsTransientDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	c := New(time.Second)
+	_, err = c.HTTP.Get("http://" + addr + "/")
+	if !IsTransient(err) {
+		t.Errorf("connection refused should be transient : %v", err)
+	}
+	_, err = c.HTTP.Get("ftp://" + addr + "/")
+	if err == nil || IsTransient(err) {
+		t.Errorf("unsupported scheme should not be transient : %v", err)
+	}
+}
+
+func TestLimiterWaitNotInTimeout(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	}))
+	defer s.Close()
+	c := New(100 * time.Millisecond)
+	c.Retry.Attempts = 1
+	c.Limiter = NewLimiter(4)
+	for i := 0; i < 3; i++ {
+		_, err := c.Fetch(context.Background(), s.URL, nil)
+		if err != nil {
+			t.Fatalf("request %d : %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/liebe-magi/falko/fetch"
+)
+
+// Client is a client of foltia ANIME LOCKER web UI
+type Client interface {
+	// ListLibraryTitles : Get titles which have recorded files
+	ListLibraryTitles(ctx context.Context) ([]LibraryTitle, error)
+	// ListRecordings : Get recordings of the title in every status.
+	// If some recordings cannot be parsed, the others are returned with RecordingErrors.
+	ListRecordings(ctx context.Context, tid int) ([]Recording, error)
+	// ListKeywords : Get keywords which have recorded files
+	ListKeywords(ctx context.Context) ([]Keyword, error)
+	// ListKeywordRecordings : Get recordings of the keyword in every status.
+	// If some recordings cannot be parsed, the others are returned with RecordingErrors.
+	ListKeywordRecordings(ctx context.Context, k Keyword) ([]Recording, error)
+	// GetCMInfo : Get TS packet information of the recording
+	GetCMInfo(ctx context.Context, pid int) (CMInfo, error)
+	// ListReservations : Get reserved titles.
+	// If some rows cannot be parsed, the others are returned with RowErrors.
+	ListReservations(ctx context.Context) ([]Reservation, error)
+	// ListPrograms : Get upcoming reserved programs.
+	// If some rows cannot be parsed, the others are returned with RowErrors.
+	ListPrograms(ctx context.Context) ([]Program, error)
+	// ListBroadcasts : Get upcoming programs whether reserved or not.
+	// If some rows cannot be parsed, the others are returned with RowErrors.
+	ListBroadcasts(ctx context.Context) ([]Program, error)
+	// ListNewAnime : Get new anime programs
+	ListNewAnime(ctx context.Context) ([]NewAnime, error)
+	// Reserve : Reserve the title and check that foltia ANIME LOCKER has accepted it
+	Reserve(ctx context.Context, tid int, opt ReserveOptions) error
+	// ReserveProgram : Reserve the title of the program on its station and check that the program is in the reserved programs
+	ReserveProgram(ctx context.Context, p Program, opt ReserveOptions) error
+	// Dereserve : Cancel the reservation of the title and check that it has been removed
+	Dereserve(ctx context.Context, tid int, station int) error
+	// ListKeywordRules : Get keyword recording rules.
+	// If some rows cannot be parsed, the others are returned with RowErrors.
+	ListKeywordRules(ctx context.Context) ([]KeywordRule, error)
+	// AddKeywordRule : Register the keyword recording rule and return it with the assigned ID
+	AddKeywordRule(ctx context.Context, k KeywordRule) (KeywordRule, error)
+	// EditKeywordRule : Update the keyword recording rule of k.ID
+	EditKeywordRule(ctx context.Context, k KeywordRule) error
+	// RemoveKeywordRule : Delete the keyword recording rule
+	RemoveKeywordRule(ctx context.Context, id int) error
+	// SystemStatus : Get system information
+	SystemStatus(ctx context.Context) (Status, error)
+}
+
+type client struct {
+	base     string
+	fetch    *fetch.Client
+	profName string
+
+	mu      sync.Mutex
+	prof    *Profile
+	version string
+}
+
+// NewClient : Create a client for foltia ANIME LOCKER at the host.
+// The host is an IP address, host:port or base URL such as https://example.com:8443/foltia
+// If profile is empty, the profile is chosen by the firmware version.
+func NewClient(host string, f *fetch.Client, profile string) Client {
+	if f == nil {
+		f = fetch.New(fetch.DefaultTimeout)
+	}
+	return &client{base: BaseURL(host), fetch: f, profName: profile}
+}
+
+// BaseURL : Get the base URL of foltia ANIME LOCKER from foltia_host
+func BaseURL(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/")
+}
+
+func (c *client) url(path string) string {
+	return c.base + path
+}
+
+func (c *client) get(ctx context.Context, path string) (*goquery.Document, error) {
+	return c.fetch.Document(ctx, c.url(path))
+}
+
+func location() (*time.Location, error) {
+	return time.LoadLocation("Asia/Tokyo")
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CMInfo is a struct of TS packet information.
+// Drop and Scramble are the sums of the segments.
+type CMInfo struct {
+	PID      int
+	Drop     int
+	Scramble int
+	Segments []TSSegment
+}
+
+// TSSegment is a struct of TS packet information of a stream in the recording
+type TSSegment struct {
+	Name     string
+	Total    int
+	Drop     int
+	Scramble int
+}
+
+// GetCMInfo : Get TS packet information of the recording
+func (c *client) GetCMInfo(ctx context.Context, pid int) (CMInfo, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return CMInfo{}, err
+	}
+	path := fmt.Sprintf("/recorded/showcminfo.php?pid=%d", pid)
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return CMInfo{}, err
+	}
+	ci := CMInfo{PID: pid}
+	ps := doc.Find(prof.CMInfoParagraphs)
+	if ps.Length() == 0 {
+		return CMInfo{}, &ParseError{Page: path, Err: fmt.Errorf("要素が見つかりません : %s", prof.CMInfoParagraphs)}
+	}
+	text := strings.Split(ps.Last().Text(), "\n")
+	for i, t := range text {
+		if i > 0 {
+			e := strings.Split(t, ", ")
+			if len(e) == 4 {
+				seg, err := parseTSSegment(e)
+				if err != nil {
+					return CMInfo{}, &ParseError{Page: path, Err: err}
+				}
+				ci.Drop += seg.Drop
+				ci.Scramble += seg.Scramble
+				ci.Segments = append(ci.Segments, seg)
+			}
+		}
+	}
+	return ci, nil
+}
+
+// parseTSSegment parses the fields of a line such as "pid=0x0100, total=120000, drop=0, scrambling=0"
+func parseTSSegment(e []string) (TSSegment, error) {
+	var v [4]string
+	for i, f := range e {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return TSSegment{}, fmt.Errorf("TSパケット情報の形式が不正 : %s", strings.Join(e, ", "))
+		}
+		v[i] = strings.TrimSpace(kv[1])
+	}
+	seg := TSSegment{Name: v[0]}
+	var err error
+	seg.Total, err = strconv.Atoi(v[1])
+	if err != nil {
+		return TSSegment{}, err
+	}
+	seg.Drop, err = strconv.Atoi(v[2])
+	if err != nil {
+		return TSSegment{}, err
+	}
+	seg.Scramble, err = strconv.Atoi(v[3])
+	if err != nil {
+		return TSSegment{}, err
+	}
+	return seg, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseError is returned when a page cannot be parsed.
+// It is never resolved by retrying the request.
+type ParseError struct {
+	Page string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("ページの解析に失敗 (%s) : %v", e.Page, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// RecordingError is returned when a recording in the library detail cannot be parsed.
+// TID is -1 for the keyword library and PID is 0 if it is not known yet.
+type RecordingError struct {
+	TID   int
+	PID   int
+	Field string
+	Err   error
+}
+
+func (e *RecordingError) Error() string {
+	return fmt.Sprintf("録画情報の解析に失敗 (TID:%d PID:%d %s) : %v", e.TID, e.PID, e.Field, e.Err)
+}
+
+func (e *RecordingError) Unwrap() error {
+	return e.Err
+}
+
+// RecordingErrors is returned with the other recordings when some recordings in the library detail cannot be parsed
+type RecordingErrors []*RecordingError
+
+func (e RecordingErrors) Error() string {
+	s := make([]string, len(e))
+	for i, r := range e {
+		s[i] = r.Error()
+	}
+	return fmt.Sprintf("%d件の録画情報の解析に失敗 : %s", len(e), strings.Join(s, ", "))
+}
+
+// RowError is returned when a row of the list page such as reservations cannot be parsed.
+// Row is the 1-based index of the row in the page.
+type RowError struct {
+	Page  string
+	Row   int
+	Field string
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("行の解析に失敗 (%s %d行目 %s) : %v", e.Page, e.Row, e.Field, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// RowErrors is returned with the other rows when some rows of the list page cannot be parsed
+type RowErrors []*RowError
+
+func (e RowErrors) Error() string {
+	s := make([]string, len(e))
+	for i, r := range e {
+		s[i] = r.Error()
+	}
+	return fmt.Sprintf("%d行の解析に失敗 : %s", len(e), strings.Join(s, ", "))
+}
+
+var errNoField = errors.New("項目が見つかりません")
+
+// Errors of reservation wrapped in ReserveError
+var (
+	ErrAlreadyReserved    = errors.New("既に予約されています")
+	ErrNotReserved        = errors.New("予約されていません")
+	ErrUnknownTID         = errors.New("TIDが見つかりません")
+	ErrUnknownProgram     = errors.New("放送予定の番組が見つかりません")
+	ErrStationNotReceived = errors.New("受信できない放送局です")
+	ErrNotApplied         = errors.New("予約一覧に反映されていません")
+)
+
+// ReserveError is returned when foltia ANIME LOCKER does not accept the reservation.
+// Message is the text of the completion page if it is not recognized.
+type ReserveError struct {
+	TID     int
+	Station int
+	Message string
+	Err     error
+}
+
+func (e *ReserveError) Error() string {
+	s, err := StationName(e.Station)
+	if err != nil {
+		s = strconv.Itoa(e.Station)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("%v (TID:%d %s) : %s", e.Err, e.TID, s, e.Message)
+	}
+	return fmt.Sprintf("%v (TID:%d %s)", e.Err, e.TID, s)
+}
+
+func (e *ReserveError) Unwrap() error {
+	return e.Err
+}
+
+// ProgramError is returned when the program is not found or not reserved
+type ProgramError struct {
+	TID     int
+	Station string
+	Time    time.Time
+	Err     error
+}
+
+func (e *ProgramError) Error() string {
+	return fmt.Sprintf("%v (TID:%d %s %s)", e.Err, e.TID, e.Station, e.Time.Format("2006/01/02 15:04"))
+}
+
+func (e *ProgramError) Unwrap() error {
+	return e.Err
+}
+
+// Errors of keyword recording rules wrapped in KeywordError
+var (
+	ErrKeywordExists  = errors.New("既に登録されているキーワードです")
+	ErrUnknownKeyword = errors.New("キーワードが見つかりません")
+)
+
+// KeywordError is returned when foltia ANIME LOCKER does not accept the keyword recording rule
+type KeywordError struct {
+	ID      int
+	Keyword string
+	Message string
+	Err     error
+}
+
+func (e *KeywordError) Error() string {
+	var id []string
+	if e.ID != 0 {
+		id = append(id, fmt.Sprintf("ID:%d", e.ID))
+	}
+	if e.Keyword != "" {
+		id = append(id, e.Keyword)
+	}
+	t := fmt.Sprintf("%v (%s)", e.Err, strings.Join(id, " "))
+	if e.Message != "" {
+		t += " : " + e.Message
+	}
+	return t
+}
+
+func (e *KeywordError) Unwrap() error {
+	return e.Err
+}
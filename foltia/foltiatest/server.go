@@ -0,0 +1,590 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package foltiatest provides a fake foltia ANIME LOCKER web UI for testing
+package foltiatest
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures/*.html
+var fixtures embed.FS
+
+var pages = template.Must(template.ParseFS(fixtures, "fixtures/*.html"))
+
+// Call is a struct of request recorded by the fake server
+type Call struct {
+	Path  string
+	Query url.Values
+}
+
+// Reservation is a struct of reserved title served by the fake server
+type Reservation struct {
+	TID      int
+	Station  string
+	Title    string
+	Quality  string
+	MP2CM    string
+	MP4CM    string
+	Programs int
+}
+
+// KeywordRule is a struct of keyword recording rule served by the fake server
+type KeywordRule struct {
+	ID      int
+	Keyword string
+	Station string
+	Quality string
+	MP2CM   string
+	MP4CM   string
+}
+
+// Program is a struct of upcoming program served by the fake server.
+// Time is the start time shown in the program guide and Minutes is the length shown in the reserved programs.
+type Program struct {
+	TID     int
+	Station string
+	Title   string
+	EpNum   int
+	EpTitle string
+	Time    string
+	Minutes int
+}
+
+// Segment is a struct of TS packet information served by the fake server
+type Segment struct {
+	Name     string
+	Total    int
+	Drop     int
+	Scramble int
+}
+
+// Server is a fake foltia ANIME LOCKER
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	version      string
+	titles       map[int]string
+	stations     map[int]string
+	reservations []Reservation
+	keywords     []KeywordRule
+	programs     []Program
+	broadcasts   []Program
+	segments     map[int][]Segment
+	pages        map[string]string
+	calls        []Call
+	user         string
+	password     string
+	basePath     string
+}
+
+// NewServer : Start a fake foltia ANIME LOCKER serving the fixture pages
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewTLSServer : Start a fake foltia ANIME LOCKER serving the fixture pages over HTTPS
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s.handler())
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		version: "6.1.5",
+		titles: map[int]string{
+			1730: "とある科学の超電磁砲T",
+			5500: "劇場版テスト",
+			5800: "新作テストアニメ",
+		},
+		stations: map[int]string{
+			0:   "[全局]",
+			7:   "テレビ東京",
+			19:  "TOKYO MX",
+			128: "BS11イレブン",
+		},
+		reservations: []Reservation{
+			{TID: 1730, Station: "[全局]", Title: "とある科学の超電磁砲T", Quality: "HDのみ", MP2CM: "編集しない", MP4CM: "編集しない", Programs: 1},
+		},
+		keywords: []KeywordRule{
+			{ID: 1, Keyword: "声優", Station: "[全局]", Quality: "HDのみ", MP2CM: "編集しない", MP4CM: "編集しない"},
+		},
+		programs: []Program{
+			{TID: 1730, Station: "BS11イレブン", Title: "とある科学の超電磁砲T", EpNum: 3, EpTitle: "姉妹", Time: "2020/06/06(土) 23:00", Minutes: 30},
+		},
+		broadcasts: []Program{
+			{TID: 1730, Station: "BS11イレブン", Title: "とある科学の超電磁砲T", EpNum: 3, EpTitle: "姉妹", Time: "2020/06/06(土) 23:00", Minutes: 30},
+			{TID: 5500, Station: "テレビ東京", Title: "劇場版テスト", EpNum: 1, Time: "2020/06/07(日) 21:00", Minutes: 120},
+			{TID: 5500, Station: "フジテレビ", Title: "劇場版テスト", EpNum: 1, Time: "2020/06/14(日) 21:00", Minutes: 120},
+		},
+		segments: map[int][]Segment{
+			100001: {{Name: "0x0100", Total: 120000, Drop: 0, Scramble: 0}, {Name: "0x0110", Total: 4000, Drop: 0, Scramble: 0}},
+			100002: {{Name: "0x0100", Total: 120000, Drop: 12, Scramble: 0}, {Name: "0x0110", Total: 4000, Drop: 3, Scramble: 0}},
+			100003: {{Name: "0x0100", Total: 120000, Drop: 0, Scramble: 0}},
+			200001: {{Name: "0x0100", Total: 480000, Drop: 0, Scramble: 0}},
+			300001: {{Name: "0x0100", Total: 90000, Drop: 0, Scramble: 2}},
+		},
+		pages: map[string]string{},
+	}
+}
+
+// Host : Get the address to be set as foltia_host
+func (s *Server) Host() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.basePath != "" || strings.HasPrefix(s.URL, "https://") {
+		return s.URL + s.basePath
+	}
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// SetBasicAuth : Require Basic authentication with the user and password
+func (s *Server) SetBasicAuth(user string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+	s.password = password
+}
+
+// SetBasePath : Serve the pages under the path as behind a reverse proxy
+func (s *Server) SetBasePath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.basePath = "/" + strings.Trim(path, "/")
+}
+
+// Calls : Get the requests sent to the reservation and keyword endpoints
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// Reservations : Get the current reservations
+func (s *Server) Reservations() []Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Reservation{}, s.reservations...)
+}
+
+// AddReservation : Add the reservation as it is, even if its labels are unknown to foltia
+func (s *Server) AddReservation(r Reservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reservations = append(s.reservations, r)
+}
+
+// KeywordRules : Get the current keyword recording rules
+func (s *Server) KeywordRules() []KeywordRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]KeywordRule{}, s.keywords...)
+}
+
+// AddKeywordRule : Add the keyword recording rule as it is, even if its labels are unknown to foltia
+func (s *Server) AddKeywordRule(k KeywordRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keywords = append(s.keywords, k)
+}
+
+// SetVersion : Set the firmware version shown in about.php
+func (s *Server) SetVersion(v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = v
+}
+
+// SetSegments : Set the TS packet information of the recording
+func (s *Server) SetSegments(pid int, seg []Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segments[pid] = seg
+}
+
+// SetPage : Replace the page served for the path (with query) by body
+func (s *Server) SetPage(path string, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[path] = body
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/recorded/recfiles_tid.php", s.recfilesTID)
+	mux.HandleFunc("/recorded/recfiles_key.php", s.recfilesKey)
+	mux.HandleFunc("/recorded/showcminfo.php", s.showCMInfo)
+	mux.HandleFunc("/setup/about.php", s.about)
+	mux.HandleFunc("/setup/listreserve.php", s.listReserve)
+	mux.HandleFunc("/reservation/index.php", s.reservation)
+	mux.HandleFunc("/reservation/reservecomp.php", s.reserveComp)
+	mux.HandleFunc("/reservation/delreserve.php", s.delReserve)
+	mux.HandleFunc("/keyword/index.php", s.keywordList)
+	mux.HandleFunc("/keyword/addkeyword.php", s.keywordAdd)
+	mux.HandleFunc("/keyword/editkeyword.php", s.keywordEdit)
+	mux.HandleFunc("/keyword/delkeyword.php", s.keywordDelete)
+	mux.HandleFunc("/animeprogram/index.php", s.animeProgram)
+	return s.gate(s.override(mux))
+}
+
+// gate checks Basic authentication and strips the base path
+func (s *Server) gate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		user, password, base := s.user, s.password, s.basePath
+		s.mu.Unlock()
+		if user != "" {
+			u, p, ok := r.BasicAuth()
+			if !ok || u != user || p != password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="foltia"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if base != "" {
+			if !strings.HasPrefix(r.URL.Path, base+"/") {
+				http.NotFound(w, r)
+				return
+			}
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, base)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) override(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		body, ok := s.pages[r.URL.RequestURI()]
+		if !ok {
+			body, ok = s.pages[r.URL.Path]
+		}
+		s.mu.Unlock()
+		if ok {
+			w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+			fmt.Fprint(w, body)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) recfilesTID(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("mode") != "detail" {
+		s.render(w, "recfiles_tid.html", nil)
+		return
+	}
+	tid := q.Get("tid")
+	if tid == "" {
+		s.render(w, "recfiles_tid_detail.html", nil)
+		return
+	}
+	name := "recfiles_tid_" + tid + ".html"
+	if pages.Lookup(name) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, name, nil)
+}
+
+func (s *Server) recfilesKey(w http.ResponseWriter, r *http.Request) {
+	kid := r.URL.Query().Get("kid")
+	if kid == "" {
+		s.render(w, "recfiles_key_detail.html", nil)
+		return
+	}
+	name := "recfiles_key_" + kid + ".html"
+	if pages.Lookup(name) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, name, nil)
+}
+
+func (s *Server) showCMInfo(w http.ResponseWriter, r *http.Request) {
+	pid, err := strconv.Atoi(r.URL.Query().Get("pid"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	seg, ok := s.segments[pid]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "showcminfo.html", struct {
+		PID      int
+		Segments []Segment
+	}{pid, seg})
+}
+
+func (s *Server) about(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	v := s.version
+	s.mu.Unlock()
+	s.render(w, "about.html", struct{ Version string }{v})
+}
+
+func (s *Server) listReserve(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		Reservation
+		No int
+	}
+	s.mu.Lock()
+	var rows []row
+	for i, rv := range s.reservations {
+		rows = append(rows, row{Reservation: rv, No: i + 1})
+	}
+	s.mu.Unlock()
+	s.render(w, "listreserve.html", rows)
+}
+
+func (s *Server) reservation(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		Program
+		No int
+	}
+	s.mu.Lock()
+	var rows []row
+	for i, p := range s.programs {
+		rows = append(rows, row{Program: p, No: i + 1})
+	}
+	s.mu.Unlock()
+	if p := r.URL.Query().Get("p"); p != "" && p != "1" {
+		rows = nil
+	}
+	s.render(w, "reservation.html", rows)
+}
+
+func (s *Server) animeProgram(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("view") == "np" {
+		s.render(w, "animeprogram.html", nil)
+		return
+	}
+	s.mu.Lock()
+	pl := append([]Program{}, s.broadcasts...)
+	s.mu.Unlock()
+	s.render(w, "programguide.html", pl)
+}
+
+func (s *Server) record(r *http.Request) {
+	s.calls = append(s.calls, Call{Path: r.URL.Path, Query: r.URL.Query()})
+}
+
+func (s *Server) reserveComp(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tid, _ := strconv.Atoi(q.Get("tid"))
+	sid, _ := strconv.Atoi(q.Get("station"))
+	s.mu.Lock()
+	s.record(r)
+	msg := s.reserve(tid, sid, q)
+	s.mu.Unlock()
+	s.render(w, "reservecomp.html", msg)
+}
+
+func (s *Server) reserve(tid int, sid int, q url.Values) string {
+	title, ok := s.titles[tid]
+	if !ok {
+		return "該当するタイトルがありません"
+	}
+	st, ok := s.stations[sid]
+	if !ok {
+		return "受信できない放送局です"
+	}
+	for _, rv := range s.reservations {
+		if rv.TID == tid && rv.Station == st {
+			return "既に予約されています"
+		}
+	}
+	s.reservations = append(s.reservations, Reservation{
+		TID:     tid,
+		Station: st,
+		Title:   title,
+		Quality: qualityLabel(q.Get("transcodequality")),
+		MP2CM:   cmEditLabel(q.Get("cmeditrulempeg2")),
+		MP4CM:   cmEditLabel(q.Get("cmeditrulemp4")),
+	})
+	for _, b := range s.broadcasts {
+		if b.TID == tid && (sid == 0 || b.Station == st) && !s.programReserved(b) {
+			s.programs = append(s.programs, b)
+		}
+	}
+	return "予約しました"
+}
+
+func (s *Server) programReserved(p Program) bool {
+	for _, r := range s.programs {
+		if r.TID == p.TID && r.Station == p.Station && r.Time == p.Time {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) delReserve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tid, _ := strconv.Atoi(q.Get("tid"))
+	sid, _ := strconv.Atoi(q.Get("sid"))
+	s.mu.Lock()
+	s.record(r)
+	var rl []Reservation
+	for _, rv := range s.reservations {
+		if rv.TID == tid && rv.Station == s.stations[sid] {
+			continue
+		}
+		rl = append(rl, rv)
+	}
+	removed := len(rl) < len(s.reservations)
+	s.reservations = rl
+	var pl []Program
+	for _, p := range s.programs {
+		if p.TID == tid && (sid == 0 || p.Station == s.stations[sid]) {
+			continue
+		}
+		pl = append(pl, p)
+	}
+	if removed {
+		s.programs = pl
+	}
+	s.mu.Unlock()
+	if !removed {
+		s.render(w, "reservecomp.html", "予約されていません")
+		return
+	}
+	s.render(w, "reservecomp.html", "予約を削除しました")
+}
+
+func (s *Server) keywordList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	kl := append([]KeywordRule{}, s.keywords...)
+	s.mu.Unlock()
+	s.render(w, "keyword.html", kl)
+}
+
+func (s *Server) keywordAdd(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	s.record(r)
+	msg := s.keywordRule(0, q)
+	s.mu.Unlock()
+	s.render(w, "reservecomp.html", msg)
+}
+
+func (s *Server) keywordEdit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	kid, _ := strconv.Atoi(q.Get("kid"))
+	s.mu.Lock()
+	s.record(r)
+	msg := "該当するキーワードがありません"
+	if kid > 0 {
+		msg = s.keywordRule(kid, q)
+	}
+	s.mu.Unlock()
+	s.render(w, "reservecomp.html", msg)
+}
+
+// keywordRule adds the rule if kid is 0, or replaces the rule of kid
+func (s *Server) keywordRule(kid int, q url.Values) string {
+	sid, _ := strconv.Atoi(q.Get("station"))
+	st, ok := s.stations[sid]
+	if !ok {
+		return "受信できない放送局です"
+	}
+	k := KeywordRule{
+		ID:      kid,
+		Keyword: q.Get("keyword"),
+		Station: st,
+		Quality: qualityLabel(q.Get("transcodequality")),
+		MP2CM:   cmEditLabel(q.Get("cmeditrulempeg2")),
+		MP4CM:   cmEditLabel(q.Get("cmeditrulemp4")),
+	}
+	idx := -1
+	next := 1
+	for i, e := range s.keywords {
+		if e.Keyword == k.Keyword && e.ID != kid {
+			return "既に登録されています"
+		}
+		if e.ID == kid {
+			idx = i
+		}
+		if e.ID >= next {
+			next = e.ID + 1
+		}
+	}
+	if kid == 0 {
+		k.ID = next
+		s.keywords = append(s.keywords, k)
+		return "登録しました"
+	}
+	if idx < 0 {
+		return "該当するキーワードがありません"
+	}
+	s.keywords[idx] = k
+	return "変更しました"
+}
+
+func (s *Server) keywordDelete(w http.ResponseWriter, r *http.Request) {
+	kid, _ := strconv.Atoi(r.URL.Query().Get("kid"))
+	s.mu.Lock()
+	s.record(r)
+	var kl []KeywordRule
+	for _, k := range s.keywords {
+		if k.ID != kid {
+			kl = append(kl, k)
+		}
+	}
+	removed := len(kl) < len(s.keywords)
+	s.keywords = kl
+	s.mu.Unlock()
+	if !removed {
+		s.render(w, "reservecomp.html", "該当するキーワードがありません")
+		return
+	}
+	s.render(w, "reservecomp.html", "削除しました")
+}
+
+func qualityLabel(v string) string {
+	l := map[string]string{"0": "変換しない", "1": "SDのみ", "2": "HDのみ", "3": "SD+HD"}
+	return l[v]
+}
+
+func cmEditLabel(v string) string {
+	l := map[string]string{"0": "編集しない", "1": "本編のみ(CMカット)", "2": "CMのみ(本編カット)", "3": "本編+CM(同尺並び替え)", "4": "チャプタ追加"}
+	return l[v]
+}
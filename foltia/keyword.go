@@ -0,0 +1,260 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// KeywordRule is a struct of keyword recording rule
+type KeywordRule struct {
+	ID      int
+	Keyword string
+	Station int
+	Quality int
+	MP2CM   int
+	MP4CM   int
+}
+
+// Pages of the keyword recording rules
+const (
+	keywordListPage   = "/keyword/index.php"
+	keywordAddPage    = "/keyword/addkeyword.php"
+	keywordEditPage   = "/keyword/editkeyword.php"
+	keywordDeletePage = "/keyword/delkeyword.php"
+)
+
+// Messages shown in the completion pages of keyword recording rules
+const (
+	msgKeywordExists  = "既に登録されています"
+	msgKeywordUnknown = "該当するキーワードがありません"
+)
+
+// ListKeywordRules : Get keyword recording rules.
+// If some rows cannot be parsed, the others are returned with RowErrors.
+func (c *client) ListKeywordRules(ctx context.Context) ([]KeywordRule, error) {
+	rows, err := c.keywordRows(ctx)
+	if err != nil {
+		return []KeywordRule{}, err
+	}
+	var kl []KeywordRule
+	var errs RowErrors
+	for _, r := range rows {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		kl = append(kl, r.rule)
+	}
+	if len(errs) > 0 {
+		return kl, errs
+	}
+	return kl, nil
+}
+
+// keywordRow is a row of the keyword recording rules.
+// err is not nil if the row cannot be parsed, and then rule has only the columns parsed before it.
+type keywordRow struct {
+	rule KeywordRule
+	err  *RowError
+}
+
+// keywordRows reads every row of the keyword recording rules including the ones which cannot be parsed.
+// The rows are used to check whether a rule exists by its ID or keyword without depending on the other columns.
+func (c *client) keywordRows(ctx context.Context) ([]keywordRow, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []keywordRow{}, err
+	}
+	doc, err := c.get(ctx, keywordListPage)
+	if err != nil {
+		return []keywordRow{}, err
+	}
+	var rows []keywordRow
+	sel := doc.Find(prof.KeywordRows)
+	for i := 0; i < sel.Length(); i++ {
+		k, err := parseKeywordRule(sel.Eq(i), prof)
+		if err != nil {
+			err.Row = i + 1
+		}
+		rows = append(rows, keywordRow{rule: k, err: err})
+	}
+	return rows, nil
+}
+
+// parseKeywordRule parses a row of the keyword recording rules.
+// The keyword is always set, and the ID is set unless it is the column which cannot be parsed.
+func parseKeywordRule(s *goquery.Selection, prof Profile) (KeywordRule, *RowError) {
+	rowErr := func(field string, err error) *RowError {
+		return &RowError{Page: keywordListPage, Field: field, Err: err}
+	}
+	var k KeywordRule
+	var err error
+	k.Keyword = strings.TrimSpace(s.Find(prof.KeywordName).Text())
+	k.ID, err = strconv.Atoi(strings.TrimSpace(s.Find(prof.KeywordID).Text()))
+	if err != nil {
+		return KeywordRule{Keyword: k.Keyword}, rowErr("ID", err)
+	}
+	k.Station, err = StationID(strings.TrimSpace(s.Find(prof.KeywordStation).Text()))
+	if err != nil {
+		return k, rowErr("放送局", err)
+	}
+	k.Quality, err = QualityNum(strings.TrimSpace(s.Find(prof.KeywordQuality).Text()))
+	if err != nil {
+		return k, rowErr("画質", err)
+	}
+	k.MP2CM, err = CMEditNum(strings.TrimSpace(s.Find(prof.KeywordMP2CM).Text()))
+	if err != nil {
+		return k, rowErr("MPEG2編集", err)
+	}
+	k.MP4CM, err = CMEditNum(strings.TrimSpace(s.Find(prof.KeywordMP4CM).Text()))
+	if err != nil {
+		return k, rowErr("MP4編集", err)
+	}
+	return k, nil
+}
+
+// AddKeywordRule : Register the keyword recording rule and return it with the assigned ID
+func (c *client) AddKeywordRule(ctx context.Context, k KeywordRule) (KeywordRule, error) {
+	rows, err := c.keywordRows(ctx)
+	if err != nil {
+		return KeywordRule{}, err
+	}
+	if _, ok := findKeywordRow(rows, k.Keyword); ok {
+		return KeywordRule{}, &KeywordError{Keyword: k.Keyword, Err: ErrKeywordExists}
+	}
+	msg, err := c.submitKeywordRule(ctx, keywordAddPage, k, url.Values{})
+	if err != nil {
+		return KeywordRule{}, err
+	}
+	rows, err = c.keywordRows(ctx)
+	if err != nil {
+		return KeywordRule{}, err
+	}
+	added, ok := findKeywordRow(rows, k.Keyword)
+	if !ok {
+		return KeywordRule{}, &KeywordError{Keyword: k.Keyword, Message: msg, Err: ErrNotApplied}
+	}
+	if added.err != nil {
+		// The rule has been added, but the columns other than the ID cannot be read back
+		k.ID = added.rule.ID
+		return k, nil
+	}
+	return added.rule, nil
+}
+
+// EditKeywordRule : Update the keyword recording rule of k.ID
+func (c *client) EditKeywordRule(ctx context.Context, k KeywordRule) error {
+	msg, err := c.submitKeywordRule(ctx, keywordEditPage, k, url.Values{"kid": {strconv.Itoa(k.ID)}})
+	if err != nil {
+		return err
+	}
+	rows, err := c.keywordRows(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if r.rule.ID != k.ID {
+			continue
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if r.rule != k {
+			return &KeywordError{ID: k.ID, Keyword: k.Keyword, Message: msg, Err: ErrNotApplied}
+		}
+		return nil
+	}
+	return &KeywordError{ID: k.ID, Keyword: k.Keyword, Err: ErrUnknownKeyword}
+}
+
+// RemoveKeywordRule : Delete the keyword recording rule
+func (c *client) RemoveKeywordRule(ctx context.Context, id int) error {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return err
+	}
+	path := keywordDeletePage + "?" + url.Values{"kid": {strconv.Itoa(id)}, "delflag": {"1"}}.Encode()
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(doc.Find(prof.CompletionMessage).Text())
+	if strings.Contains(msg, msgKeywordUnknown) {
+		return &KeywordError{ID: id, Err: ErrUnknownKeyword}
+	}
+	rows, err := c.keywordRows(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if r.rule.ID == id {
+			return &KeywordError{ID: id, Keyword: r.rule.Keyword, Message: msg, Err: ErrNotApplied}
+		}
+	}
+	return nil
+}
+
+// submitKeywordRule sends the rule to the page and checks the completion message
+func (c *client) submitKeywordRule(ctx context.Context, page string, k KeywordRule, q url.Values) (string, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return "", err
+	}
+	q.Set("keyword", k.Keyword)
+	q.Set("station", strconv.Itoa(k.Station))
+	q.Set("transcodequality", strconv.Itoa(k.Quality))
+	q.Set("cmeditrulempeg2", strconv.Itoa(k.MP2CM))
+	q.Set("cmeditrulemp4", strconv.Itoa(k.MP4CM))
+	doc, err := c.get(ctx, page+"?"+q.Encode())
+	if err != nil {
+		return "", err
+	}
+	msg := strings.TrimSpace(doc.Find(prof.CompletionMessage).Text())
+	switch {
+	case strings.Contains(msg, msgKeywordExists):
+		return msg, &KeywordError{ID: k.ID, Keyword: k.Keyword, Err: ErrKeywordExists}
+	case strings.Contains(msg, msgKeywordUnknown):
+		return msg, &KeywordError{ID: k.ID, Keyword: k.Keyword, Err: ErrUnknownKeyword}
+	case strings.Contains(msg, msgNotReceived):
+		return msg, &KeywordError{ID: k.ID, Keyword: k.Keyword, Err: ErrStationNotReceived}
+	}
+	return msg, nil
+}
+
+func findKeywordRow(rows []keywordRow, keyword string) (keywordRow, bool) {
+	for _, r := range rows {
+		if r.rule.Keyword == keyword {
+			return r, true
+		}
+	}
+	return keywordRow{}, false
+}
+
+func (k KeywordRule) String() string {
+	s, err := StationName(k.Station)
+	if err != nil {
+		s = strconv.Itoa(k.Station)
+	}
+	return fmt.Sprintf("%d : %s %s [%d, %d, %d]", k.ID, k.Keyword, s, k.Quality, k.MP2CM, k.MP4CM)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/liebe-magi/falko/foltia"
+	"github.com/liebe-magi/falko/foltia/foltiatest"
+)
+
+func TestKeywordRulesWithUnparsableRow(t *testing.T) {
+	s := foltiatest.NewServer()
+	defer s.Close()
+	s.AddKeywordRule(foltiatest.KeywordRule{ID: 2, Keyword: "ラジオ", Station: "未知の局", Quality: "HDのみ", MP2CM: "編集しない", MP4CM: "編集しない"})
+	c := foltia.NewClient(s.Host(), nil, "")
+	ctx := context.Background()
+
+	kl, err := c.ListKeywordRules(ctx)
+	var errs foltia.RowErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Row != 2 || errs[0].Field != "放送局" {
+		t.Fatalf("ListKeywordRules error = %v, want RowError of 放送局 in row 2", err)
+	}
+	want := foltia.KeywordRule{ID: 1, Keyword: "声優", Station: 0, Quality: 2}
+	if len(kl) != 1 || kl[0] != want {
+		t.Errorf("ListKeywordRules = %+v, want [%+v]", kl, want)
+	}
+
+	_, err = c.AddKeywordRule(ctx, foltia.KeywordRule{Keyword: "ラジオ", Quality: 1})
+	if !errors.Is(err, foltia.ErrKeywordExists) {
+		t.Errorf("AddKeywordRule of the unparsable keyword = %v, want ErrKeywordExists", err)
+	}
+	added, err := c.AddKeywordRule(ctx, foltia.KeywordRule{Keyword: "アニメ", Station: 19, Quality: 1, MP4CM: 1})
+	if err != nil {
+		t.Fatalf("AddKeywordRule : %v", err)
+	}
+	want = foltia.KeywordRule{ID: 3, Keyword: "アニメ", Station: 19, Quality: 1, MP4CM: 1}
+	if added != want {
+		t.Errorf("AddKeywordRule = %+v, want %+v", added, want)
+	}
+
+	err = c.EditKeywordRule(ctx, foltia.KeywordRule{ID: 2, Keyword: "ラジオ", Station: 7})
+	if err != nil {
+		t.Fatalf("EditKeywordRule of the unparsable rule : %v", err)
+	}
+	err = c.RemoveKeywordRule(ctx, 1)
+	if err != nil {
+		t.Fatalf("RemoveKeywordRule : %v", err)
+	}
+	kl, err = c.ListKeywordRules(ctx)
+	if err != nil {
+		t.Fatalf("ListKeywordRules after the changes : %v", err)
+	}
+	if len(kl) != 2 || kl[0].ID != 2 || kl[0].Station != 7 || kl[1].ID != 3 {
+		t.Errorf("ListKeywordRules after the changes = %+v", kl)
+	}
+}
@@ -0,0 +1,338 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LibraryTitle is a struct of title in the library.
+// Files and Latest are the number of recorded files and the time of the latest recording.
+type LibraryTitle struct {
+	TID    int
+	Files  int
+	Latest time.Time
+}
+
+// Recording is a struct of recorded file.
+// StatusText is the status shown by foltia ANIME LOCKER.
+type Recording struct {
+	TID        int
+	PID        int
+	EpNum      int
+	EpTitle    string
+	Time       time.Time
+	Station    string
+	FileTS     string
+	FileMP4HD  string
+	FileMP4SD  string
+	Status     RecStatus
+	StatusText string
+}
+
+// RecStatus is a status of the recording in the library
+type RecStatus string
+
+// Statuses of the recording
+const (
+	RecCompleted RecStatus = "completed"
+	RecRecording RecStatus = "recording"
+	RecEncoding  RecStatus = "encoding"
+	RecFailed    RecStatus = "failed"
+	RecUnknown   RecStatus = "unknown"
+)
+
+// ParseRecStatus : Get the status of the recording from the status shown by foltia ANIME LOCKER
+func ParseRecStatus(s string) RecStatus {
+	switch {
+	case s == "完了":
+		return RecCompleted
+	case strings.Contains(s, "失敗") || strings.Contains(s, "エラー"):
+		return RecFailed
+	case strings.Contains(s, "録画"):
+		return RecRecording
+	case strings.Contains(s, "変換") || strings.Contains(s, "エンコード"):
+		return RecEncoding
+	}
+	return RecUnknown
+}
+
+// Label : Get the label of the status in Japanese
+func (s RecStatus) Label() string {
+	switch s {
+	case RecCompleted:
+		return "完了"
+	case RecRecording:
+		return "録画中"
+	case RecEncoding:
+		return "MP4変換中"
+	case RecFailed:
+		return "失敗"
+	}
+	return "不明"
+}
+
+// Keyword is a struct of keyword in the library
+type Keyword struct {
+	Name string
+	Path string
+}
+
+// ListLibraryTitles : Get titles which have recorded files
+func (c *client) ListLibraryTitles(ctx context.Context) ([]LibraryTitle, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []LibraryTitle{}, err
+	}
+	path := "/recorded/recfiles_tid.php?mode=detail"
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return []LibraryTitle{}, err
+	}
+	loc, err := location()
+	if err != nil {
+		return []LibraryTitle{}, err
+	}
+	var ltl []LibraryTitle
+	rows := doc.Find(prof.LibraryRows)
+	for i := 0; i < rows.Length(); i++ {
+		lt, err := parseLibraryTitle(rows.Eq(i), prof, loc)
+		if err != nil {
+			return []LibraryTitle{}, &ParseError{Page: path, Err: err}
+		}
+		ltl = append(ltl, lt)
+	}
+	return ltl, nil
+}
+
+func parseLibraryTitle(s *goquery.Selection, prof Profile, loc *time.Location) (LibraryTitle, error) {
+	var lt LibraryTitle
+	t, err := find(s, prof.LibraryTID)
+	if err != nil {
+		return LibraryTitle{}, err
+	}
+	lt.TID, err = strconv.Atoi(t)
+	if err != nil {
+		return LibraryTitle{}, err
+	}
+	f, err := find(s, prof.LibraryFiles)
+	if err != nil {
+		return LibraryTitle{}, err
+	}
+	lt.Files, err = strconv.Atoi(strings.TrimSpace(f))
+	if err != nil {
+		return LibraryTitle{}, err
+	}
+	l, err := find(s, prof.LibraryLatest)
+	if err != nil {
+		return LibraryTitle{}, err
+	}
+	lt.Latest, err = parseRecTime(strings.TrimSpace(l), loc)
+	if err != nil {
+		return LibraryTitle{}, err
+	}
+	return lt, nil
+}
+
+// ListRecordings : Get recordings of the title in every status.
+// If some recordings cannot be parsed, the others are returned with RecordingErrors.
+func (c *client) ListRecordings(ctx context.Context, tid int) ([]Recording, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []Recording{}, err
+	}
+	path := fmt.Sprintf("/recorded/recfiles_tid.php?mode=detail&tid=%d", tid)
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return []Recording{}, err
+	}
+	rl, err := parseLibraryDetail(doc, prof, tid)
+	if errs, ok := err.(RecordingErrors); ok {
+		return rl, errs
+	}
+	if err != nil {
+		return []Recording{}, &ParseError{Page: path, Err: err}
+	}
+	return rl, nil
+}
+
+// ListKeywords : Get keywords which have recorded files
+func (c *client) ListKeywords(ctx context.Context) ([]Keyword, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []Keyword{}, err
+	}
+	doc, err := c.get(ctx, "/recorded/recfiles_key.php?mode=detail")
+	if err != nil {
+		return []Keyword{}, err
+	}
+	var kl []Keyword
+	doc.Find(prof.LibraryRows).Each(func(i int, s *goquery.Selection) {
+		name := s.Find(prof.LibraryLink).Text()
+		path, exists := s.Find(prof.LibraryLink).Attr("href")
+		if exists {
+			kl = append(kl, Keyword{Name: name, Path: path})
+		}
+	})
+	return kl, nil
+}
+
+// ListKeywordRecordings : Get recordings of the keyword in every status.
+// If some recordings cannot be parsed, the others are returned with RecordingErrors.
+func (c *client) ListKeywordRecordings(ctx context.Context, k Keyword) ([]Recording, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []Recording{}, err
+	}
+	path := "/recorded/" + k.Path
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return []Recording{}, err
+	}
+	rl, err := parseLibraryDetail(doc, prof, -1)
+	if errs, ok := err.(RecordingErrors); ok {
+		return rl, errs
+	}
+	if err != nil {
+		return []Recording{}, &ParseError{Page: path, Err: err}
+	}
+	return rl, nil
+}
+
+// Labels of the fields in the programInfo of the library detail
+const (
+	labelEpNum   = "話数"
+	labelEpTitle = "サブタイトル"
+	labelTime    = "録画日時"
+	labelStation = "放送局"
+	labelStatus  = "ステータス"
+)
+
+// parseLibraryDetail parses the recordings in the library detail of a title or keyword.
+// For the keyword library, tid is -1 and EpNum is always -1.
+// Recordings which cannot be parsed are skipped and returned as RecordingErrors.
+func parseLibraryDetail(doc *goquery.Document, prof Profile, tid int) ([]Recording, error) {
+	loc, err := location()
+	if err != nil {
+		return []Recording{}, err
+	}
+	var rl []Recording
+	var errs RecordingErrors
+	items := doc.Find(prof.DetailItems)
+	for i := 0; i < items.Length(); i++ {
+		r, ok, err := parseRecording(items.Eq(i), prof, tid, loc)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			rl = append(rl, r)
+		}
+	}
+	if len(errs) > 0 {
+		return rl, errs
+	}
+	return rl, nil
+}
+
+// parseRecording parses a recording in the library detail.
+// It returns false if the recording is not completed and has no PID yet.
+func parseRecording(s *goquery.Selection, prof Profile, tid int, loc *time.Location) (Recording, bool, *RecordingError) {
+	var err error
+	r := Recording{TID: tid, EpNum: -1}
+	p, exists := s.Find(prof.DetailPID).Attr("href")
+	if exists {
+		r.PID, err = strconv.Atoi(strings.TrimPrefix(p, "./selectcaptureimage.php?pid="))
+		if err != nil {
+			return Recording{}, false, &RecordingError{TID: tid, Field: "PID", Err: err}
+		}
+	}
+	f := programInfo(s, prof)
+	status, ok := f[labelStatus]
+	if !ok {
+		return Recording{}, false, &RecordingError{TID: tid, PID: r.PID, Field: labelStatus, Err: errNoField}
+	}
+	r.Status = ParseRecStatus(status)
+	r.StatusText = status
+	if !exists && r.Status != RecCompleted {
+		return Recording{}, false, nil
+	}
+	if !exists {
+		return Recording{}, false, &RecordingError{TID: tid, Field: "PID", Err: errNoField}
+	}
+	if e, ok := f[labelEpNum]; ok && tid != -1 && e != "[話数]" {
+		r.EpNum, err = strconv.Atoi(e)
+		if err != nil {
+			return Recording{}, false, &RecordingError{TID: tid, PID: r.PID, Field: labelEpNum, Err: err}
+		}
+	}
+	r.EpTitle = f[labelEpTitle]
+	r.Station = f[labelStation]
+	t, ok := f[labelTime]
+	if !ok {
+		return Recording{}, false, &RecordingError{TID: tid, PID: r.PID, Field: labelTime, Err: errNoField}
+	}
+	r.Time, err = parseRecTime(t, loc)
+	if err != nil {
+		return Recording{}, false, &RecordingError{TID: tid, PID: r.PID, Field: labelTime, Err: err}
+	}
+	s.Find(prof.DetailFiles).Each(func(j int, f *goquery.Selection) {
+		t, _ := f.Attr("class")
+		if t == "mpeg2" {
+			r.FileTS = strings.TrimSpace(f.Text())
+		} else if t == "mp4HD" {
+			r.FileMP4HD = strings.TrimSpace(f.Text())
+		} else if t == "mp4SD" {
+			r.FileMP4SD = strings.TrimSpace(f.Text())
+		}
+	})
+	return r, true, nil
+}
+
+// programInfo reads the "label：value" fields of the recording into a map
+func programInfo(s *goquery.Selection, prof Profile) map[string]string {
+	f := map[string]string{}
+	s.Find(prof.DetailFields).Each(func(i int, li *goquery.Selection) {
+		t := strings.TrimSpace(li.Text())
+		sep := "："
+		if !strings.Contains(t, sep) {
+			sep = ":"
+		}
+		kv := strings.SplitN(t, sep, 2)
+		if len(kv) != 2 {
+			return
+		}
+		f[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	})
+	return f
+}
+
+// parseRecTime parses "2006/01/02(月) 15:04" style time
+func parseRecTime(t string, loc *time.Location) (time.Time, error) {
+	d := strings.Split(t, "(")
+	h := strings.Split(t, ")")
+	if len(d) < 2 || len(h) < 2 {
+		return time.Time{}, fmt.Errorf("録画日時の形式が不正 : %s", t)
+	}
+	return time.ParseInLocation("2006/01/02 15:04", d[0]+h[1], loc)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewAnime is a struct of new anime program
+type NewAnime struct {
+	TID     int
+	Station string
+	Title   string
+	Time    time.Time
+}
+
+// ListNewAnime : Get new anime programs
+func (c *client) ListNewAnime(ctx context.Context) ([]NewAnime, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []NewAnime{}, err
+	}
+	doc, err := c.get(ctx, "/animeprogram/index.php?filter=crp&view=np")
+	if err != nil {
+		return []NewAnime{}, err
+	}
+	loc, err := location()
+	if err != nil {
+		return []NewAnime{}, err
+	}
+	var nal []NewAnime
+	rows := doc.Find(prof.NewAnimeRows)
+	for i := 1; i < rows.Length(); i++ {
+		s := rows.Eq(i)
+		var n NewAnime
+		n.TID, _ = strconv.Atoi(s.Find(prof.NewAnimeTID).Text())
+		n.Station = s.Find(prof.NewAnimeStation).Text()
+		n.Title = s.Find(prof.NewAnimeTitle).Text()
+		d := strings.Split(s.Find(prof.NewAnimeDate).Text(), "(")
+		if len(d) < 2 {
+			continue
+		}
+		h := strings.Split(d[1], ")")
+		if len(h) < 2 {
+			continue
+		}
+		n.Time, _ = time.ParseInLocation("2006/01/02 15:04", strings.TrimSpace(d[0])+" "+strings.TrimSpace(h[1]), loc)
+		nal = append(nal, n)
+	}
+	return nal, nil
+}
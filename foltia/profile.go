@@ -0,0 +1,258 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Profile is a set of selectors for a web UI layout of foltia ANIME LOCKER
+type Profile struct {
+	Name string
+	// Versions are the firmware version prefixes the layout is used by
+	Versions []string
+
+	LibraryRows   string
+	LibraryTID    string
+	LibraryFiles  string
+	LibraryLatest string
+	LibraryLink   string
+
+	DetailItems  string
+	DetailFields string
+	DetailFiles  string
+	DetailPID    string
+
+	CMInfoParagraphs string
+
+	AboutRows         string
+	AboutSerialLabel  string
+	AboutRunningLabel string
+	StorageSpent      string
+	StorageTotal      string
+	StorageRest       string
+
+	ReserveRows       string
+	ReserveTID        string
+	ReserveStation    string
+	ReserveTitle      string
+	ReserveQuality    string
+	ReserveMP2CM      string
+	ReserveMP4CM      string
+	ReservePrograms   string
+	CompletionMessage string
+
+	KeywordRows    string
+	KeywordID      string
+	KeywordName    string
+	KeywordStation string
+	KeywordQuality string
+	KeywordMP2CM   string
+	KeywordMP4CM   string
+
+	ProgramRows    string
+	ProgramTID     string
+	ProgramStation string
+	ProgramTitle   string
+	ProgramEpNum   string
+	ProgramEpTitle string
+	ProgramTime    string
+
+	BroadcastRows    string
+	BroadcastTID     string
+	BroadcastStation string
+	BroadcastTitle   string
+	BroadcastDate    string
+
+	NewAnimeRows    string
+	NewAnimeTID     string
+	NewAnimeStation string
+	NewAnimeTitle   string
+	NewAnimeDate    string
+}
+
+// profiles are the layouts known to falko
+var profiles = []Profile{
+	{
+		Name:     "v6",
+		Versions: []string{"6."},
+
+		LibraryRows:   "#Librarytable > table > tbody > tr",
+		LibraryTID:    "td:nth-child(1) > a",
+		LibraryFiles:  "td:nth-child(3)",
+		LibraryLatest: "td:nth-child(4)",
+		LibraryLink:   "td > a",
+
+		DetailItems:  "#libraryDetail > li",
+		DetailFields: "div.programInfo > ul > li",
+		DetailFiles:  "div.programInfo > div > ul.fileType > li",
+		DetailPID:    "div.programInfo > ul > div > a",
+
+		CMInfoParagraphs: "#programInfo > p",
+
+		AboutRows:         "#setUpTable > table > tbody > tr",
+		AboutSerialLabel:  "シリアル番号",
+		AboutRunningLabel: "稼働日数",
+		StorageSpent:      "#HDDremainder > dl > dd > span.spent",
+		StorageTotal:      "#HDDtotal",
+		StorageRest:       "#HDDrest",
+
+		ReserveRows:       "#setUpTable > table > tbody > tr",
+		ReserveTID:        "td:nth-child(2) > a",
+		ReserveStation:    "td:nth-child(3)",
+		ReserveTitle:      "td:nth-child(4) > a",
+		ReserveQuality:    "td:nth-child(5)",
+		ReserveMP2CM:      "td:nth-child(6)",
+		ReserveMP4CM:      "td:nth-child(7)",
+		ReservePrograms:   "td:nth-child(8)",
+		CompletionMessage: "#contents",
+
+		KeywordRows:    "#setUpTable > table > tbody > tr",
+		KeywordID:      "td:nth-child(1)",
+		KeywordName:    "td:nth-child(2)",
+		KeywordStation: "td:nth-child(3)",
+		KeywordQuality: "td:nth-child(4)",
+		KeywordMP2CM:   "td:nth-child(5)",
+		KeywordMP4CM:   "td:nth-child(6)",
+
+		ProgramRows:    "#contents > table > tbody > tr",
+		ProgramTID:     "td:nth-child(2)",
+		ProgramStation: "td:nth-child(3)",
+		ProgramTitle:   "td:nth-child(4) > a",
+		ProgramEpNum:   "td:nth-child(5)",
+		ProgramEpTitle: "td:nth-child(6)",
+		ProgramTime:    "td:nth-child(7)",
+
+		BroadcastRows:    "#contents > table > tbody > tr",
+		BroadcastTID:     "td[class=TID]",
+		BroadcastStation: "td[class=station]",
+		BroadcastTitle:   "td[class=title] a",
+		BroadcastDate:    "td[class=date]",
+
+		NewAnimeRows:    "#contents > table > tbody > tr",
+		NewAnimeTID:     "td[class=TID]",
+		NewAnimeStation: "td[class=station]",
+		NewAnimeTitle:   "td[class=title] a",
+		NewAnimeDate:    "td[class=date]",
+	},
+}
+
+// versionLabel is the label of the firmware version row in about.php.
+// It is looked up by label so that the version can be read before the layout is known.
+const versionLabel = "バージョン"
+
+// UnsupportedVersionError is returned when no profile matches the firmware version
+type UnsupportedVersionError struct {
+	Version string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported foltia version %s : foltia_profileで解析プロファイルを指定してください (%s)", e.Version, strings.Join(ProfileNames(), ", "))
+}
+
+// ProfileNames : Get names of the known profiles
+func ProfileNames() []string {
+	var nl []string
+	for _, p := range profiles {
+		nl = append(nl, p.Name)
+	}
+	return nl
+}
+
+// ProfileByName : Get the profile of the name
+func ProfileByName(name string) (Profile, error) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("解析プロファイルが定義されていません : %s", name)
+}
+
+// ProfileForVersion : Get the profile matching the firmware version
+func ProfileForVersion(version string) (Profile, error) {
+	for _, p := range profiles {
+		for _, v := range p.Versions {
+			if strings.HasPrefix(version, v) {
+				return p, nil
+			}
+		}
+	}
+	return Profile{}, &UnsupportedVersionError{Version: version}
+}
+
+// profile detects the firmware version once and returns the matching profile.
+// Failed requests are not cached so that a later call can try again.
+func (c *client) profile(ctx context.Context) (Profile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prof != nil {
+		return *c.prof, nil
+	}
+	if c.profName != "" {
+		p, err := ProfileByName(c.profName)
+		if err != nil {
+			return Profile{}, err
+		}
+		c.prof = &p
+		return p, nil
+	}
+	path := "/setup/about.php"
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return Profile{}, err
+	}
+	v, ok := labeledValue(doc.Selection, "tr", versionLabel)
+	if !ok || v == "" {
+		return Profile{}, &ParseError{Page: path, Err: fmt.Errorf("バージョンが見つかりません")}
+	}
+	c.version = v
+	p, err := ProfileForVersion(v)
+	if err != nil {
+		return Profile{}, err
+	}
+	c.prof = &p
+	return p, nil
+}
+
+// labeledValue finds the row whose header is the label and returns the text of its cell
+func labeledValue(s *goquery.Selection, rows string, label string) (string, bool) {
+	var v string
+	var found bool
+	s.Find(rows).EachWithBreak(func(i int, r *goquery.Selection) bool {
+		if strings.TrimSpace(r.Find("th").First().Text()) != label {
+			return true
+		}
+		v = strings.TrimSpace(r.Find("td").First().Text())
+		found = true
+		return false
+	})
+	return v, found
+}
+
+// find returns the text of the required element, or an error if the layout does not have it
+func find(s *goquery.Selection, sel string) (string, error) {
+	e := s.Find(sel)
+	if e.Length() == 0 {
+		return "", fmt.Errorf("要素が見つかりません : %s", sel)
+	}
+	return e.Text(), nil
+}
@@ -0,0 +1,443 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Reservation is a struct of reserved title
+type Reservation struct {
+	TID     int
+	Title   string
+	Station int
+	Quality int
+	MP2CM   int
+	MP4CM   int
+	Now     bool
+}
+
+// Program is a struct of upcoming program.
+// Neither the reserved programs nor the program guide show PID, so a program is identified by TID, station and start time.
+type Program struct {
+	TID     int
+	Station string
+	Title   string
+	EpNum   int
+	EpTitle string
+	Time    time.Time
+}
+
+// ReserveOptions is a struct of reservation settings
+type ReserveOptions struct {
+	Station int
+	Quality int
+	MP2CM   int
+	MP4CM   int
+}
+
+// Page of the reserved titles
+const reserveListPage = "/setup/listreserve.php"
+
+// ListReservations : Get reserved titles.
+// If some rows cannot be parsed, the others are returned with RowErrors.
+func (c *client) ListReservations(ctx context.Context) ([]Reservation, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []Reservation{}, err
+	}
+	doc, err := c.get(ctx, reserveListPage)
+	if err != nil {
+		return []Reservation{}, err
+	}
+	var rl []Reservation
+	var errs RowErrors
+	rows := doc.Find(prof.ReserveRows)
+	for i := 0; i < rows.Length(); i++ {
+		r, err := parseReservation(rows.Eq(i), prof)
+		if err != nil {
+			err.Row = i + 1
+			errs = append(errs, err)
+			continue
+		}
+		rl = append(rl, r)
+	}
+	if len(errs) > 0 {
+		return rl, errs
+	}
+	return rl, nil
+}
+
+// parseReservation parses a row of the reserved titles
+func parseReservation(s *goquery.Selection, prof Profile) (Reservation, *RowError) {
+	rowErr := func(field string, err error) *RowError {
+		return &RowError{Page: reserveListPage, Field: field, Err: err}
+	}
+	var r Reservation
+	var err error
+	r.TID, err = strconv.Atoi(strings.TrimSpace(s.Find(prof.ReserveTID).Text()))
+	if err != nil {
+		return Reservation{}, rowErr("TID", err)
+	}
+	r.Station, err = StationID(strings.TrimSpace(s.Find(prof.ReserveStation).Text()))
+	if err != nil {
+		return Reservation{}, rowErr("放送局", err)
+	}
+	r.Title = strings.TrimSpace(s.Find(prof.ReserveTitle).Text())
+	r.Quality, err = QualityNum(strings.TrimSpace(s.Find(prof.ReserveQuality).Text()))
+	if err != nil {
+		return Reservation{}, rowErr("画質", err)
+	}
+	r.MP2CM, err = CMEditNum(strings.TrimSpace(s.Find(prof.ReserveMP2CM).Text()))
+	if err != nil {
+		return Reservation{}, rowErr("MPEG2編集", err)
+	}
+	r.MP4CM, err = CMEditNum(strings.TrimSpace(s.Find(prof.ReserveMP4CM).Text()))
+	if err != nil {
+		return Reservation{}, rowErr("MP4編集", err)
+	}
+	pnum, err := strconv.Atoi(strings.TrimSpace(s.Find(prof.ReservePrograms).Text()))
+	if err != nil {
+		return Reservation{}, rowErr("番組数", err)
+	}
+	r.Now = pnum != 0
+	return r, nil
+}
+
+// Pages of the upcoming programs
+const (
+	programListPage  = "/reservation/index.php"
+	programGuidePage = "/animeprogram/index.php"
+)
+
+// ListPrograms : Get upcoming reserved programs.
+// If some rows cannot be parsed, the others are returned with RowErrors.
+func (c *client) ListPrograms(ctx context.Context) ([]Program, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []Program{}, err
+	}
+	loc, err := location()
+	if err != nil {
+		return []Program{}, err
+	}
+	var pl []Program
+	var errs RowErrors
+	for i := 1; i < 100; i++ {
+		path := fmt.Sprintf("%s?p=%d", programListPage, i)
+		doc, err := c.get(ctx, path)
+		if err != nil {
+			return []Program{}, err
+		}
+		rows := doc.Find(prof.ProgramRows)
+		if rows.Length() <= 1 {
+			break
+		}
+		for j := 1; j < rows.Length(); j++ {
+			p, err := parseProgram(rows.Eq(j), prof, loc)
+			if err != nil {
+				err.Page = path
+				err.Row = j
+				errs = append(errs, err)
+				continue
+			}
+			if p.TID == -1 {
+				continue
+			}
+			pl = append(pl, p)
+		}
+	}
+	if len(errs) > 0 {
+		return pl, errs
+	}
+	return pl, nil
+}
+
+// parseProgram parses a row of the reserved programs.
+// Rows of keyword recordings are returned with TID -1.
+func parseProgram(s *goquery.Selection, prof Profile, loc *time.Location) (Program, *RowError) {
+	rowErr := func(field string, err error) *RowError {
+		return &RowError{Field: field, Err: err}
+	}
+	var p Program
+	var err error
+	p.TID, err = strconv.Atoi(strings.TrimSpace(s.Find(prof.ProgramTID).Text()))
+	if err != nil {
+		return Program{}, rowErr("TID", err)
+	}
+	if p.TID == -1 {
+		return p, nil
+	}
+	p.Station = strings.TrimSpace(s.Find(prof.ProgramStation).Text())
+	p.Title = strings.TrimSpace(s.Find(prof.ProgramTitle).Text())
+	p.EpNum = -1
+	if e := strings.TrimSpace(s.Find(prof.ProgramEpNum).Text()); e != "" && e != "[話数]" {
+		p.EpNum, err = strconv.Atoi(e)
+		if err != nil {
+			return Program{}, rowErr("話数", err)
+		}
+	}
+	p.EpTitle = strings.TrimSpace(s.Find(prof.ProgramEpTitle).Text())
+	p.Time, err = parseProgramTime(strings.TrimSpace(s.Find(prof.ProgramTime).Text()), loc)
+	if err != nil {
+		return Program{}, rowErr("開始時刻", err)
+	}
+	return p, nil
+}
+
+// ListBroadcasts : Get upcoming programs whether reserved or not.
+// The program guide has no episode columns, so EpNum is -1 and EpTitle is empty.
+// If some rows cannot be parsed, the others are returned with RowErrors.
+func (c *client) ListBroadcasts(ctx context.Context) ([]Program, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return []Program{}, err
+	}
+	loc, err := location()
+	if err != nil {
+		return []Program{}, err
+	}
+	doc, err := c.get(ctx, programGuidePage)
+	if err != nil {
+		return []Program{}, err
+	}
+	var pl []Program
+	var errs RowErrors
+	rows := doc.Find(prof.BroadcastRows)
+	for i := 1; i < rows.Length(); i++ {
+		p, err := parseBroadcast(rows.Eq(i), prof, loc)
+		if err != nil {
+			err.Row = i
+			errs = append(errs, err)
+			continue
+		}
+		pl = append(pl, p)
+	}
+	if len(errs) > 0 {
+		return pl, errs
+	}
+	return pl, nil
+}
+
+// parseBroadcast parses a row of the program guide
+func parseBroadcast(s *goquery.Selection, prof Profile, loc *time.Location) (Program, *RowError) {
+	rowErr := func(field string, err error) *RowError {
+		return &RowError{Page: programGuidePage, Field: field, Err: err}
+	}
+	p := Program{EpNum: -1}
+	var err error
+	p.TID, err = strconv.Atoi(strings.TrimSpace(s.Find(prof.BroadcastTID).Text()))
+	if err != nil {
+		return Program{}, rowErr("TID", err)
+	}
+	p.Station = strings.TrimSpace(s.Find(prof.BroadcastStation).Text())
+	p.Title = strings.TrimSpace(s.Find(prof.BroadcastTitle).Text())
+	p.Time, err = parseBroadcastTime(strings.TrimSpace(s.Find(prof.BroadcastDate).Text()), loc)
+	if err != nil {
+		return Program{}, rowErr("開始時刻", err)
+	}
+	return p, nil
+}
+
+// parseBroadcastTime parses "2006/01/02(月) 15:04" style time
+func parseBroadcastTime(t string, loc *time.Location) (time.Time, error) {
+	d := strings.Split(t, "(")
+	if len(d) < 2 {
+		return time.Time{}, fmt.Errorf("放送日時の形式が不正 : %s", t)
+	}
+	h := strings.Split(d[1], ")")
+	if len(h) < 2 {
+		return time.Time{}, fmt.Errorf("放送日時の形式が不正 : %s", t)
+	}
+	return time.ParseInLocation("2006/01/02 15:04", strings.TrimSpace(d[0])+" "+strings.TrimSpace(h[1]), loc)
+}
+
+// parseProgramTime parses "2006/01/02(月) 15:04 (30)" style time
+func parseProgramTime(t string, loc *time.Location) (time.Time, error) {
+	d := strings.Split(t, "(")
+	h := strings.Split(t, ")")
+	if len(d) < 2 || len(h) < 2 {
+		return time.Time{}, fmt.Errorf("放送日時の形式が不正 : %s", t)
+	}
+	hh := strings.Split(h[1], " ")
+	if len(hh) < 2 {
+		return time.Time{}, fmt.Errorf("放送日時の形式が不正 : %s", t)
+	}
+	return time.ParseInLocation("2006/01/02 15:04", d[0]+" "+hh[1], loc)
+}
+
+// Messages shown in the completion pages of reservation
+const (
+	msgReserved        = "予約しました"
+	msgAlreadyReserved = "既に予約されています"
+	msgUnknownTID      = "該当するタイトルがありません"
+	msgNotReceived     = "受信できない放送局です"
+	msgDereserved      = "予約を削除しました"
+	msgNotReserved     = "予約されていません"
+)
+
+// Reserve : Reserve the title and check that foltia ANIME LOCKER has accepted it
+func (c *client) Reserve(ctx context.Context, tid int, opt ReserveOptions) error {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return err
+	}
+	found, err := c.reserved(ctx, tid, opt.Station)
+	if err != nil {
+		return err
+	}
+	if found {
+		return &ReserveError{TID: tid, Station: opt.Station, Err: ErrAlreadyReserved}
+	}
+	path := "/reservation/reservecomp.php"
+	path += fmt.Sprintf("?station=%d", opt.Station)
+	path += fmt.Sprintf("&transcodequality=%d", opt.Quality)
+	path += fmt.Sprintf("&cmeditrulempeg2=%d", opt.MP2CM)
+	path += fmt.Sprintf("&cmeditrulemp4=%d", opt.MP4CM)
+	path += fmt.Sprintf("&usedigital=1&tid=%d", tid)
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(doc.Find(prof.CompletionMessage).Text())
+	switch {
+	case strings.Contains(msg, msgAlreadyReserved):
+		return &ReserveError{TID: tid, Station: opt.Station, Err: ErrAlreadyReserved}
+	case strings.Contains(msg, msgUnknownTID):
+		return &ReserveError{TID: tid, Station: opt.Station, Err: ErrUnknownTID}
+	case strings.Contains(msg, msgNotReceived):
+		return &ReserveError{TID: tid, Station: opt.Station, Err: ErrStationNotReceived}
+	}
+	found, err = c.reserved(ctx, tid, opt.Station)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &ReserveError{TID: tid, Station: opt.Station, Err: ErrNotApplied, Message: msg}
+	}
+	return nil
+}
+
+// Dereserve : Cancel the reservation of the title and check that it has been removed
+func (c *client) Dereserve(ctx context.Context, tid int, station int) error {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return err
+	}
+	found, err := c.reserved(ctx, tid, station)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &ReserveError{TID: tid, Station: station, Err: ErrNotReserved}
+	}
+	path := "/reservation/delreserve.php"
+	path += fmt.Sprintf("?sid=%d", station)
+	path += fmt.Sprintf("&delflag=1&tid=%d", tid)
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(doc.Find(prof.CompletionMessage).Text())
+	if strings.Contains(msg, msgNotReserved) {
+		return &ReserveError{TID: tid, Station: station, Err: ErrNotReserved}
+	}
+	found, err = c.reserved(ctx, tid, station)
+	if err != nil {
+		return err
+	}
+	if found {
+		return &ReserveError{TID: tid, Station: station, Err: ErrNotApplied, Message: msg}
+	}
+	return nil
+}
+
+// ReserveProgram : Reserve the title of the program on its station and check that the program is in the reserved programs.
+// foltia ANIME LOCKER reserves by title and station, so the other broadcasts of the title on the station are reserved too.
+func (c *client) ReserveProgram(ctx context.Context, p Program, opt ReserveOptions) error {
+	sid, err := StationID(p.Station)
+	if err != nil {
+		return err
+	}
+	found, err := c.programReserved(ctx, p)
+	if err != nil {
+		return err
+	}
+	if found {
+		return &ProgramError{TID: p.TID, Station: p.Station, Time: p.Time, Err: ErrAlreadyReserved}
+	}
+	opt.Station = sid
+	err = c.Reserve(ctx, p.TID, opt)
+	if err != nil {
+		return err
+	}
+	found, err = c.programReserved(ctx, p)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &ProgramError{TID: p.TID, Station: p.Station, Time: p.Time, Err: ErrNotApplied}
+	}
+	return nil
+}
+
+// programReserved checks whether the program is in the upcoming reserved programs.
+// Rows which cannot be parsed are ignored.
+func (c *client) programReserved(ctx context.Context, p Program) (bool, error) {
+	pl, err := c.ListPrograms(ctx)
+	var errs RowErrors
+	if err != nil && !errors.As(err, &errs) {
+		return false, err
+	}
+	for _, r := range pl {
+		if r.TID == p.TID && r.Station == p.Station && r.Time.Equal(p.Time) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// reserved checks whether the title is reserved on the station.
+// Only TID and the station name are compared so that a row with unknown labels in the other columns does not matter.
+func (c *client) reserved(ctx context.Context, tid int, station int) (bool, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return false, err
+	}
+	name, err := StationName(station)
+	if err != nil {
+		return false, err
+	}
+	doc, err := c.get(ctx, reserveListPage)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	doc.Find(prof.ReserveRows).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		t, err := strconv.Atoi(strings.TrimSpace(s.Find(prof.ReserveTID).Text()))
+		found = err == nil && t == tid && strings.TrimSpace(s.Find(prof.ReserveStation).Text()) == name
+		return !found
+	})
+	return found, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/liebe-magi/falko/foltia"
+	"github.com/liebe-magi/falko/foltia/foltiatest"
+)
+
+func TestListReservationsSkipsUnparsableRows(t *testing.T) {
+	s := foltiatest.NewServer()
+	defer s.Close()
+	s.AddReservation(foltiatest.Reservation{TID: 5500, Station: "テレビ東京", Title: "劇場版テスト", Quality: "4K", MP2CM: "編集しない", MP4CM: "編集しない"})
+	c := foltia.NewClient(s.Host(), nil, "")
+
+	rl, err := c.ListReservations(context.Background())
+	var errs foltia.RowErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("ListReservations error = %v, want 1 RowError", err)
+	}
+	if errs[0].Row != 2 || errs[0].Field != "画質" {
+		t.Errorf("RowError = %+v, want row 2 of 画質", errs[0])
+	}
+	want := foltia.Reservation{TID: 1730, Title: "とある科学の超電磁砲T", Station: 0, Quality: 2, Now: true}
+	if len(rl) != 1 || rl[0] != want {
+		t.Errorf("ListReservations = %+v, want [%+v]", rl, want)
+	}
+}
+
+func TestReserveWithUnparsableRows(t *testing.T) {
+	s := foltiatest.NewServer()
+	defer s.Close()
+	s.AddReservation(foltiatest.Reservation{TID: 5500, Station: "テレビ東京", Title: "劇場版テスト", Quality: "HDのみ", MP2CM: "未知の編集", MP4CM: "編集しない"})
+	c := foltia.NewClient(s.Host(), nil, "")
+	ctx := context.Background()
+
+	err := c.Reserve(ctx, 5800, foltia.ReserveOptions{Station: 19, Quality: 1})
+	if err != nil {
+		t.Fatalf("Reserve : %v", err)
+	}
+	err = c.Reserve(ctx, 5500, foltia.ReserveOptions{Station: 7})
+	if !errors.Is(err, foltia.ErrAlreadyReserved) {
+		t.Errorf("Reserve of the unparsable reservation = %v, want ErrAlreadyReserved", err)
+	}
+	err = c.Dereserve(ctx, 5500, 7)
+	if err != nil {
+		t.Fatalf("Dereserve : %v", err)
+	}
+	for _, r := range s.Reservations() {
+		if r.TID == 5500 {
+			t.Errorf("reservation of 5500 is not removed : %+v", r)
+		}
+	}
+	if n := len(s.Reservations()); n != 2 {
+		t.Errorf("%d reservations, want 2", n)
+	}
+}
+
+func TestListProgramsSkipsUnparsableRows(t *testing.T) {
+	s := foltiatest.NewServer()
+	defer s.Close()
+	s.SetPage("/reservation/index.php?p=1", `<div id="contents"><table><tbody>
+<tr><th>No</th><th>TID</th><th>放送局</th><th>タイトル</th><th>話数</th><th>サブタイトル</th><th>開始時刻(尺)</th></tr>
+<tr><td>1</td><td>1730</td><td>BS11イレブン</td><td><a>とある科学の超電磁砲T</a></td><td>3</td><td>姉妹</td><td>2020/06/06(土) 23:00 (30)</td></tr>
+<tr><td>2</td><td>5500</td><td>テレビ東京</td><td><a>劇場版テスト</a></td><td>第1話</td><td></td><td>2020/06/07(日) 21:00 (120)</td></tr>
+<tr><td>3</td><td>5800</td><td>TOKYO MX</td><td><a>新作テストアニメ</a></td><td>[話数]</td><td></td><td>未定</td></tr>
+<tr><td>4</td><td>-1</td><td>NHK総合</td><td><a>声優</a></td><td></td><td></td><td>2020/06/08(月) 19:00 (30)</td></tr>
+<tr><td>5</td><td>5800</td><td>TOKYO MX</td><td><a>新作テストアニメ</a></td><td>[話数]</td><td></td><td>2020/06/09(火) 01:05 (30)</td></tr>
+</tbody></table></div>`)
+	c := foltia.NewClient(s.Host(), nil, "")
+
+	pl, err := c.ListPrograms(context.Background())
+	var errs foltia.RowErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("ListPrograms error = %v, want 2 RowErrors", err)
+	}
+	if errs[0].Row != 2 || errs[0].Field != "話数" || errs[1].Row != 3 || errs[1].Field != "開始時刻" {
+		t.Errorf("RowErrors = %+v, %+v, want row 2 of 話数 and row 3 of 開始時刻", errs[0], errs[1])
+	}
+	if len(pl) != 2 || pl[0].TID != 1730 || pl[0].EpNum != 3 || pl[1].TID != 5800 || pl[1].EpNum != -1 || pl[1].Time.IsZero() {
+		t.Errorf("ListPrograms = %+v, want 1730 and 5800", pl)
+	}
+}
+
+func TestListBroadcasts(t *testing.T) {
+	s := foltiatest.NewServer()
+	defer s.Close()
+	c := foltia.NewClient(s.Host(), nil, "")
+	ctx := context.Background()
+
+	pl, err := c.ListBroadcasts(ctx)
+	if err != nil {
+		t.Fatalf("ListBroadcasts : %v", err)
+	}
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation : %v", err)
+	}
+	want := foltia.Program{TID: 5500, Station: "テレビ東京", Title: "劇場版テスト", EpNum: -1, Time: time.Date(2020, 6, 7, 21, 0, 0, 0, loc)}
+	if len(pl) != 3 || pl[1].TID != want.TID || pl[1].Station != want.Station || pl[1].Title != want.Title || pl[1].EpNum != want.EpNum || !pl[1].Time.Equal(want.Time) {
+		t.Errorf("ListBroadcasts = %+v, want %+v as the second", pl, want)
+	}
+
+	s.SetPage("/animeprogram/index.php", `<div id="contents"><table><tbody>
+<tr><th>TID</th><th>放送局</th><th>タイトル</th><th>開始時刻</th></tr>
+<tr><td class="TID"></td><td class="station">TOKYO MX</td><td class="title"><a>不明</a></td><td class="date">2020/06/02(火) 01:05</td></tr>
+<tr><td class="TID">5800</td><td class="station">TOKYO MX</td><td class="title"><a>新作テストアニメ</a></td><td class="date">2020/06/02(火) 01:05</td></tr>
+</tbody></table></div>`)
+	pl, err = c.ListBroadcasts(ctx)
+	var errs foltia.RowErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Row != 1 || errs[0].Field != "TID" {
+		t.Fatalf("ListBroadcasts error = %v, want a RowError of TID in row 1", err)
+	}
+	if len(pl) != 1 || pl[0].TID != 5800 {
+		t.Errorf("ListBroadcasts = %+v, want 5800", pl)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import "fmt"
+
+// Station is a struct of broadcast station
+type Station struct {
+	Name   string
+	ID     int
+	StType int
+}
+
+// StationList is a list of broadcast station
+type StationList []Station
+
+// Stations : Get stations known to foltia ANIME LOCKER
+func Stations() StationList {
+	var sl StationList
+	sl = append(sl, Station{Name: "NHK総合", ID: 1, StType: 0})
+	sl = append(sl, Station{Name: "NHK Eテレ", ID: 2, StType: 0})
+	sl = append(sl, Station{Name: "フジテレビ", ID: 3, StType: 0})
+	sl = append(sl, Station{Name: "日本テレビ", ID: 4, StType: 0})
+	sl = append(sl, Station{Name: "TBS", ID: 5, StType: 0})
+	sl = append(sl, Station{Name: "テレビ朝日", ID: 6, StType: 0})
+	sl = append(sl, Station{Name: "テレビ東京", ID: 7, StType: 0})
+	sl = append(sl, Station{Name: "tvk", ID: 8, StType: 0})
+	sl = append(sl, Station{Name: "NHK-BS1", ID: 9, StType: 0})
+	sl = append(sl, Station{Name: "NHK-BS2", ID: 10, StType: 0})
+	sl = append(sl, Station{Name: "チバテレビ", ID: 13, StType: 0})
+	sl = append(sl, Station{Name: "テレ玉", ID: 14, StType: 0})
+	sl = append(sl, Station{Name: "BSテレ東", ID: 15, StType: 1})
+	sl = append(sl, Station{Name: "BS-TBS", ID: 16, StType: 1})
+	sl = append(sl, Station{Name: "BSフジ", ID: 17, StType: 1})
+	sl = append(sl, Station{Name: "BS朝日", ID: 18, StType: 1})
+	sl = append(sl, Station{Name: "TOKYO MX", ID: 19, StType: 0})
+	sl = append(sl, Station{Name: "BS日テレ", ID: 71, StType: 1})
+	sl = append(sl, Station{Name: "BS11イレブン", ID: 128, StType: 1})
+	sl = append(sl, Station{Name: "BS12トゥエルビ", ID: 129, StType: 1})
+	sl = append(sl, Station{Name: "NHK BSプレミアム", ID: 179, StType: 1})
+	sl = append(sl, Station{Name: "TOKYO MX2", ID: 187, StType: 0})
+	sl = append(sl, Station{Name: "BSJapanext", ID: 273, StType: 1})
+	return sl
+}
+
+// StationName : Get station name from station ID
+func StationName(id int) (string, error) {
+	if id == 0 {
+		return "[全局]", nil
+	}
+	for _, s := range Stations() {
+		if id == s.ID {
+			return s.Name, nil
+		}
+	}
+	return "", fmt.Errorf("放送局IDが定義されていません : %d", id)
+}
+
+// StationID : Get station ID from station name
+func StationID(name string) (int, error) {
+	if name == "[全局]" {
+		return 0, nil
+	}
+	for _, s := range Stations() {
+		if name == s.Name {
+			return s.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("放送局名が定義されていません : %s", name)
+}
+
+// QualityNum : Get transcode quality value from its label
+func QualityNum(s string) (int, error) {
+	if s == "変換しない" {
+		return 0, nil
+	}
+	if s == "SDのみ" {
+		return 1, nil
+	}
+	if s == "HDのみ" {
+		return 2, nil
+	}
+	if s == "SD+HD" {
+		return 3, nil
+	}
+	return 0, fmt.Errorf("変換品質が定義されていません : %s", s)
+}
+
+// Quality : Get transcode quality label from its value
+func Quality(v int) (string, error) {
+	if v == 0 {
+		return "変換しない", nil
+	}
+	if v == 1 {
+		return "SDのみ", nil
+	}
+	if v == 2 {
+		return "HDのみ", nil
+	}
+	if v == 3 {
+		return "SD+HD", nil
+	}
+	return "", fmt.Errorf("変換品質の値が定義されていません : %d", v)
+}
+
+// CMEditNum : Get CM edit rule value from its label
+func CMEditNum(s string) (int, error) {
+	if s == "編集しない" {
+		return 0, nil
+	}
+	if s == "本編のみ(CMカット)" {
+		return 1, nil
+	}
+	if s == "CMのみ(本編カット)" {
+		return 2, nil
+	}
+	if s == "本編+CM(同尺並び替え)" {
+		return 3, nil
+	}
+	if s == "チャプタ追加" {
+		return 4, nil
+	}
+	return 0, fmt.Errorf("CMカットルールが定義されていません : %s", s)
+}
+
+// CMEdit : Get CM edit rule label from its value
+func CMEdit(v int) (string, error) {
+	if v == 0 {
+		return "編集しない", nil
+	}
+	if v == 1 {
+		return "本編のみ(CMカット)", nil
+	}
+	if v == 2 {
+		return "CMのみ(本編カット)", nil
+	}
+	if v == 3 {
+		return "本編+CM(同尺並び替え)", nil
+	}
+	if v == 4 {
+		return "チャプタ追加", nil
+	}
+
+	return "", fmt.Errorf("CMカットルールの値が定義されていません : %d", v)
+}
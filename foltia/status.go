@@ -0,0 +1,80 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package foltia
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Status is a struct of system information
+type Status struct {
+	Version        string
+	Profile        string
+	Serial         string
+	Storage        string
+	StorageRemain  string
+	StoragePercent int
+	RunningDays    int
+}
+
+// SystemStatus : Get system information
+func (c *client) SystemStatus(ctx context.Context) (Status, error) {
+	prof, err := c.profile(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	path := "/setup/about.php"
+	doc, err := c.get(ctx, path)
+	if err != nil {
+		return Status{}, err
+	}
+	var s Status
+	s.Profile = prof.Name
+	s.Version, _ = labeledValue(doc.Selection, prof.AboutRows, versionLabel)
+	serial, ok := labeledValue(doc.Selection, prof.AboutRows, prof.AboutSerialLabel)
+	if !ok {
+		return Status{}, &ParseError{Page: path, Err: fmt.Errorf("要素が見つかりません : %s", prof.AboutSerialLabel)}
+	}
+	s.Serial = strings.TrimSpace(strings.Split(serial, "\n")[0])
+	days, ok := labeledValue(doc.Selection, prof.AboutRows, prof.AboutRunningLabel)
+	if !ok {
+		return Status{}, &ParseError{Page: path, Err: fmt.Errorf("要素が見つかりません : %s", prof.AboutRunningLabel)}
+	}
+	s.RunningDays, err = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(days, "days")))
+	if err != nil {
+		return Status{}, &ParseError{Page: path, Err: err}
+	}
+	path = "/recorded/recfiles_tid.php"
+	doc, err = c.get(ctx, path)
+	if err != nil {
+		return Status{}, err
+	}
+	spent, err := find(doc.Selection, prof.StorageSpent)
+	if err != nil {
+		return Status{}, &ParseError{Page: path, Err: err}
+	}
+	s.StoragePercent, err = strconv.Atoi(strings.TrimSuffix(spent, "%"))
+	if err != nil {
+		return Status{}, &ParseError{Page: path, Err: err}
+	}
+	s.Storage = strings.TrimSpace(doc.Find(prof.StorageTotal).Text())
+	s.StorageRemain = strings.TrimSpace(doc.Find(prof.StorageRest).Text())
+	return s, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syoboi
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is a cached response of the API.
+// URL is the request of the response, and Since is the LastUpdate watermark of a TitleLookup request.
+type cacheEntry struct {
+	Key          string
+	URL          string
+	Since        time.Time
+	ETag         string
+	LastModified string
+	Fetched      time.Time
+	Body         []byte
+}
+
+// covers checks whether the entry can answer the request of the watermark.
+// A response since an earlier watermark has all titles updated since a later one.
+func (e *cacheEntry) covers(since time.Time) bool {
+	return !e.Since.After(since)
+}
+
+// cache stores the responses in dir, one file per key.
+// A new response of the key replaces the previous one.
+// An empty dir disables the cache, and readOnly disables only storing.
+type cache struct {
+	dir      string
+	readOnly bool
+}
+
+func (c cache) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns nil if the key is not cached
+func (c cache) load(key string) (*cacheEntry, error) {
+	if c.dir == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e cacheEntry
+	err = json.Unmarshal(b, &e)
+	if err != nil || e.Key != key {
+		// A broken entry is the same as a missing one
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// prune removes the entries and temporary files not written for the retention
+func (c cache) prune(retention time.Duration) error {
+	if c.dir == "" || c.readOnly {
+		return nil
+	}
+	el, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	limit := time.Now().Add(-retention)
+	for _, e := range el {
+		info, err := e.Info()
+		if err != nil || info.IsDir() || !info.ModTime().Before(limit) {
+			continue
+		}
+		err = os.Remove(filepath.Join(c.dir, e.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// store writes the entry atomically so that an interrupted write does not break the cache
+func (c cache) store(e *cacheEntry) error {
+	if c.dir == "" || c.readOnly {
+		return nil
+	}
+	err := os.MkdirAll(c.dir, 0777)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), c.path(e.Key))
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
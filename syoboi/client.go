@@ -0,0 +1,179 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package syoboi provides a client of the Syoboi Calendar database API
+package syoboi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/liebe-magi/falko/fetch"
+)
+
+// DefaultBase is the URL of the database API of Syoboi Calendar
+const DefaultBase = "http://cal.syoboi.jp/db.php"
+
+// DefaultTTL is the time during which a cached response is used without asking Syoboi Calendar
+const DefaultTTL = time.Hour
+
+// DefaultRPS is the rate of the requests to Syoboi Calendar used when none is configured
+const DefaultRPS = 1
+
+// cacheRetention is the time after which a cached response not written again is removed
+const cacheRetention = 30 * 24 * time.Hour
+
+// ErrNotCached is returned in offline mode when the response is not cached
+var ErrNotCached = errors.New("オフラインのためキャッシュのない情報は取得できません")
+
+// ErrUnexpectedNotModified is returned when Syoboi Calendar answers 304 to a request without a cached response
+var ErrUnexpectedNotModified = errors.New("キャッシュのないリクエストに304が返されました")
+
+// Options is a struct of the options of the client
+type Options struct {
+	// CacheDir is the directory of the response cache. The cache is disabled if it is empty.
+	CacheDir string
+	// TTL is the time during which a cached response is used as is. DefaultTTL is used if it is 0.
+	TTL time.Duration
+	// RPS is the maximum requests per second. DefaultRPS is used if it is not positive.
+	RPS float64
+	// Offline makes the client use only the cache and never send requests
+	Offline bool
+	// ReadOnly makes the client use the cache without writing the new responses to it
+	ReadOnly bool
+}
+
+// Client is a client of Syoboi Calendar
+type Client interface {
+	// TitleLookup : Get the titles updated since the time, or all titles if since is zero
+	TitleLookup(ctx context.Context, since time.Time) ([]Title, error)
+	// ProgLookup : Get the programs of the title on the channel which start between from and to
+	ProgLookup(ctx context.Context, tid int, chid int, from time.Time, to time.Time) ([]Program, error)
+}
+
+type client struct {
+	base    string
+	fetch   *fetch.Client
+	cache   cache
+	ttl     time.Duration
+	offline bool
+	pruned  sync.Once
+}
+
+// NewClient : Create a client for Syoboi Calendar.
+// The requests are rate-limited by opt.RPS regardless of the limiter of f.
+func NewClient(f *fetch.Client, opt Options) Client {
+	if f == nil {
+		f = fetch.New(fetch.DefaultTimeout)
+	}
+	if opt.TTL == 0 {
+		opt.TTL = DefaultTTL
+	}
+	if opt.RPS <= 0 {
+		opt.RPS = DefaultRPS
+	}
+	cf := *f
+	cf.Limiter = fetch.NewLimiter(opt.RPS)
+	return &client{
+		base:    DefaultBase,
+		fetch:   &cf,
+		cache:   cache{dir: opt.CacheDir, readOnly: opt.ReadOnly},
+		ttl:     opt.TTL,
+		offline: opt.Offline,
+	}
+}
+
+// get returns the response of the query, which is cached under key.
+// since is the LastUpdate watermark of a TitleLookup query, and zero for the others.
+// The cached response is used if it covers the query and is fresh, otherwise Syoboi Calendar is asked whether it is modified.
+// The stale response is used if Syoboi Calendar cannot be reached.
+func (c *client) get(ctx context.Context, key string, q url.Values, since time.Time) (*goquery.Document, error) {
+	u := c.base + "?" + q.Encode()
+	e, err := c.cache.load(key)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && !e.covers(since) {
+		e = nil
+	}
+	if c.offline {
+		if e == nil {
+			return nil, ErrNotCached
+		}
+		return parse(e.Body)
+	}
+	if e != nil && time.Since(e.Fetched) < c.ttl {
+		return parse(e.Body)
+	}
+	// The validators are only valid for the same request
+	header := http.Header{}
+	if e != nil && e.URL == u && e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	if e != nil && e.URL == u && e.LastModified != "" {
+		header.Set("If-Modified-Since", e.LastModified)
+	}
+	res, err := c.fetch.Fetch(ctx, u, header)
+	if err != nil {
+		if e == nil || ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("しょぼいカレンダーに接続できないためキャッシュを使用 (%s取得) : %s", e.Fetched.Format("2006/01/02 15:04"), err)
+		return parse(e.Body)
+	}
+	if res.Status == http.StatusNotModified {
+		if len(header) == 0 {
+			return nil, fmt.Errorf("%w : %s", ErrUnexpectedNotModified, u)
+		}
+		e.Fetched = time.Now()
+	} else {
+		e = &cacheEntry{
+			Key:          key,
+			URL:          u,
+			Since:        since,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+			Fetched:      time.Now(),
+			Body:         res.Body,
+		}
+	}
+	c.pruned.Do(func() {
+		if err := c.cache.prune(cacheRetention); err != nil {
+			log.Printf("しょぼいカレンダーのキャッシュを整理できませんでした : %s", err)
+		}
+	})
+	err = c.cache.store(e)
+	if err != nil {
+		return nil, err
+	}
+	return parse(e.Body)
+}
+
+func parse(body []byte) (*goquery.Document, error) {
+	return goquery.NewDocumentFromReader(bytes.NewReader(body))
+}
+
+func location() (*time.Location, error) {
+	return time.LoadLocation("Asia/Tokyo")
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syoboi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const titleLookupResponse = `<TitleLookupResponse><TitleItems>
+<TitleItem><TID>1730</TID><Title>とある科学の超電磁砲T</Title><LastUpdate>2020-06-01 12:00:00</LastUpdate></TitleItem>
+<TitleItem><TID>5500</TID><Title>劇場版テスト</Title><LastUpdate>2020-05-01 12:00:00</LastUpdate></TitleItem>
+</TitleItems></TitleLookupResponse>`
+
+// fakeServer serves the titles with an ETag and records the requests
+type fakeServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	// notModified makes every response 304
+	notModified bool
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		nm := s.notModified
+		s.mu.Unlock()
+		if nm || r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(titleLookupResponse))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) lastRequest() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func newTestClient(s *fakeServer, dir string, opt Options) *client {
+	opt.CacheDir = dir
+	opt.RPS = 1000
+	c := NewClient(nil, opt).(*client)
+	c.base = s.URL
+	return c
+}
+
+func cacheFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	l, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestTitleLookupCachedByCommand(t *testing.T) {
+	s := newFakeServer(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+	loc, err := location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	since1 := time.Date(2020, 4, 1, 0, 0, 0, 0, loc)
+	since2 := time.Date(2020, 5, 15, 0, 0, 0, 0, loc)
+
+	tl, err := newTestClient(s, dir, Options{}).TitleLookup(ctx, since1)
+	if err != nil || len(tl) != 2 {
+		t.Fatalf("TitleLookup = %+v, %v, want 2 titles", tl, err)
+	}
+
+	// The watermark has moved since the response was cached
+	tl, err = newTestClient(s, dir, Options{Offline: true}).TitleLookup(ctx, since2)
+	if err != nil || len(tl) != 1 || tl[0].TID != 1730 {
+		t.Errorf("offline TitleLookup since the later watermark = %+v, %v, want 1730", tl, err)
+	}
+	_, err = newTestClient(s, dir, Options{Offline: true}).TitleLookup(ctx, time.Time{})
+	if !errors.Is(err, ErrNotCached) {
+		t.Errorf("offline TitleLookup of all titles = %v, want ErrNotCached", err)
+	}
+
+	// The stale response of the other watermark is replaced without conditional headers
+	_, err = newTestClient(s, dir, Options{TTL: time.Nanosecond}).TitleLookup(ctx, since2)
+	if err != nil {
+		t.Fatalf("TitleLookup : %v", err)
+	}
+	if r := s.lastRequest(); r.Header.Get("If-None-Match") != "" || r.URL.Query().Get("LastUpdate") != "20200515_000000-" {
+		t.Errorf("request = %s %v, want since the later watermark without If-None-Match", r.URL, r.Header)
+	}
+	if l := cacheFiles(t, dir); len(l) != 1 {
+		t.Errorf("cache files = %v, want 1", l)
+	}
+
+	// The same request is conditional
+	tl, err = newTestClient(s, dir, Options{TTL: time.Nanosecond}).TitleLookup(ctx, since2)
+	if err != nil || len(tl) != 1 {
+		t.Fatalf("TitleLookup = %+v, %v, want 1 title", tl, err)
+	}
+	if r := s.lastRequest(); r.Header.Get("If-None-Match") != `"v1"` {
+		t.Errorf("request headers = %v, want If-None-Match", r.Header)
+	}
+}
+
+func TestNotModifiedWithoutCache(t *testing.T) {
+	s := newFakeServer(t)
+	s.notModified = true
+	dir := t.TempDir()
+
+	_, err := newTestClient(s, dir, Options{}).ProgLookup(context.Background(), 1730, 128, time.Now(), time.Now())
+	if !errors.Is(err, ErrUnexpectedNotModified) {
+		t.Errorf("ProgLookup = %v, want ErrUnexpectedNotModified", err)
+	}
+	if l := cacheFiles(t, dir); len(l) != 0 {
+		t.Errorf("cache files = %v, want none", l)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	s := newFakeServer(t)
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.json")
+	err := os.WriteFile(old, []byte("{}"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-cacheRetention - time.Hour)
+	err = os.Chtimes(old, past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTestClient(s, dir, Options{ReadOnly: true}).TitleLookup(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatalf("TitleLookup : %v", err)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Errorf("stale entry is removed by the read-only client : %v", err)
+	}
+	_, err = newTestClient(s, dir, Options{}).TitleLookup(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatalf("TitleLookup : %v", err)
+	}
+	if _, err := os.Stat(old); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stale entry is not removed : %v", err)
+	}
+	if l := cacheFiles(t, dir); len(l) != 1 {
+		t.Errorf("cache files = %v, want the new entry", l)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syoboi
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Program is a broadcast of a title in Syoboi Calendar.
+// Count is -1 if the program has no episode number.
+type Program struct {
+	PID      int
+	TID      int
+	ChID     int
+	StTime   time.Time
+	EdTime   time.Time
+	Count    int
+	SubTitle string
+	Flag     int
+}
+
+// Flags of Program
+const (
+	FlagNotice  = 1
+	FlagNew     = 2
+	FlagFinal   = 4
+	FlagRebroad = 8
+)
+
+// Special : Check whether the program is a special which has no episode number
+func (p Program) Special() bool {
+	return p.Count < 0
+}
+
+// ProgLookup : Get the programs of the title on the channel which start between from and to
+func (c *client) ProgLookup(ctx context.Context, tid int, chid int, from time.Time, to time.Time) ([]Program, error) {
+	loc, err := location()
+	if err != nil {
+		return []Program{}, err
+	}
+	q := url.Values{}
+	q.Set("Command", "ProgLookup")
+	q.Set("TID", strconv.Itoa(tid))
+	q.Set("ChID", strconv.Itoa(chid))
+	q.Set("StTime", from.In(loc).Format("20060102_150405")+"-"+to.In(loc).Format("20060102_150405"))
+	q.Set("JOIN", "SubTitles")
+	doc, err := c.get(ctx, c.base+"?"+q.Encode(), q, time.Time{})
+	if err != nil {
+		return []Program{}, err
+	}
+	var pl []Program
+	var perr error
+	doc.Find("ProgLookupResponse > ProgItems > ProgItem").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if text(s, "Deleted") == "1" {
+			return true
+		}
+		p, err := parseProgram(s, loc)
+		if err != nil {
+			perr = err
+			return false
+		}
+		pl = append(pl, p)
+		return true
+	})
+	if perr != nil {
+		return []Program{}, perr
+	}
+	return pl, nil
+}
+
+func parseProgram(s *goquery.Selection, loc *time.Location) (Program, error) {
+	var p Program
+	var err error
+	p.PID, err = strconv.Atoi(text(s, "PID"))
+	if err != nil {
+		return Program{}, err
+	}
+	p.TID, err = strconv.Atoi(text(s, "TID"))
+	if err != nil {
+		return Program{}, err
+	}
+	p.ChID, err = strconv.Atoi(text(s, "ChID"))
+	if err != nil {
+		return Program{}, err
+	}
+	p.StTime, err = time.ParseInLocation("2006-01-02 15:04:05", text(s, "StTime"), loc)
+	if err != nil {
+		return Program{}, err
+	}
+	if o := text(s, "StOffset"); o != "" && o != "0" {
+		offset, err := strconv.Atoi(o)
+		if err != nil {
+			return Program{}, err
+		}
+		p.StTime = p.StTime.Add(time.Duration(offset) * time.Second)
+	}
+	p.EdTime, err = time.ParseInLocation("2006-01-02 15:04:05", text(s, "EdTime"), loc)
+	if err != nil {
+		return Program{}, err
+	}
+	p.Count = -1
+	if c := text(s, "Count"); c != "" {
+		p.Count, err = strconv.Atoi(c)
+		if err != nil {
+			return Program{}, err
+		}
+	}
+	p.SubTitle = text(s, "STSubTitle")
+	if p.SubTitle == "" {
+		p.SubTitle = text(s, "SubTitle")
+	}
+	if f := text(s, "Flag"); f != "" {
+		p.Flag, err = strconv.Atoi(f)
+		if err != nil {
+			return Program{}, err
+		}
+	}
+	return p, nil
+}
+
+// Nearest : Get the program which starts nearest to t. It returns false if pl is empty.
+func Nearest(pl []Program, t time.Time) (Program, bool) {
+	var nearest Program
+	found := false
+	for _, p := range pl {
+		if !found || absDuration(p.StTime.Sub(t)) < absDuration(nearest.StTime.Sub(t)) {
+			nearest = p
+			found = true
+		}
+	}
+	return nearest, found
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func text(s *goquery.Selection, name string) string {
+	return strings.TrimSpace(s.ChildrenFiltered(name).Text())
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 liebe-magi <liebe.magi@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syoboi
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Title is a title in Syoboi Calendar
+type Title struct {
+	TID        int
+	Title      string
+	Yomi       string
+	FirstYear  int
+	LastUpdate time.Time
+}
+
+// TitleLookup : Get the titles updated since the time, or all titles if since is zero.
+// The response is cached under the command regardless of since, so that only the latest one is kept.
+func (c *client) TitleLookup(ctx context.Context, since time.Time) ([]Title, error) {
+	loc, err := location()
+	if err != nil {
+		return []Title{}, err
+	}
+	q := url.Values{}
+	q.Set("Command", "TitleLookup")
+	q.Set("TID", "*")
+	if !since.IsZero() {
+		q.Set("LastUpdate", since.In(loc).Format("20060102_150405")+"-")
+	}
+	doc, err := c.get(ctx, "TitleLookup", q, since)
+	if err != nil {
+		return []Title{}, err
+	}
+	var tl []Title
+	doc.Find("TitleLookupResponse > TitleItems > TitleItem").Each(func(i int, s *goquery.Selection) {
+		var t Title
+		t.TID, _ = strconv.Atoi(text(s, "TID"))
+		t.Title = text(s, "Title")
+		t.Yomi = text(s, "TitleYomi")
+		t.FirstYear, _ = strconv.Atoi(text(s, "FirstYear"))
+		t.LastUpdate, _ = time.ParseInLocation("2006-01-02 15:04:05", text(s, "LastUpdate"), loc)
+		// The cached response since an earlier watermark has older titles too
+		if !since.IsZero() && t.LastUpdate.Before(since) {
+			return
+		}
+		tl = append(tl, t)
+	})
+	return tl, nil
+}